@@ -28,366 +28,10406 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
+	htmltemplate "html/template"
 	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 // HuggingFaceRequest representa el payload de la solicitud para la API de HuggingFace
 type HuggingFaceRequest struct {
 	Inputs     string                 `json:"inputs"`
 	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Options    *HuggingFaceOptions    `json:"options,omitempty"`
 }
 
-// HuggingFaceResponse representa la respuesta de la API
-type HuggingFaceResponse []struct {
-	SummaryText string `json:"summary_text"`
+// HuggingFaceOptions son las opciones de inferencia que acepta el router de HuggingFace
+type HuggingFaceOptions struct {
+	// WaitForModel hace que la API espere a que el modelo cargue en lugar de responder 503
+	WaitForModel bool `json:"wait_for_model,omitempty"`
 }
 
 // HuggingFaceError representa las respuestas de error de la API
 type HuggingFaceError struct {
 	Error string `json:"error"`
+	// EstimatedTime es el tiempo estimado (en segundos) hasta que el modelo termine de cargar
+	EstimatedTime float64 `json:"estimated_time,omitempty"`
 }
 
 const (
-	// Endpoint de la API de Inferencia de HuggingFace para el modelo de resumen BART
+	// Router de HuggingFace: las URLs de modelo tienen la forma
+	// <routerBaseURL>/<proveedor>/models/<id del modelo>
+	// Documentación: https://huggingface.co/docs/inference-providers
+	routerBaseURL   = "https://router.huggingface.co"
+	defaultProvider = "hf-inference"
+
+	// Proveedor para apps Gradio alojadas en Hugging Face Spaces: el ID del
+	// modelo es el del Space ("usuario/nombre") y se usa su API REST
+	// Documentación: https://www.gradio.app/guides/querying-gradio-apps-with-curl
+	spacesProvider = "spaces"
+	// Endpoint por defecto de la app Gradio (gr.Interface expone "predict")
+	defaultGradioAPI = "predict"
+
+	// Modelo de resumen por defecto (BART)
 	// El modelo facebook/bart-large-cnn está optimizado para resumir noticias y artículos
 	// Página del modelo: https://huggingface.co/facebook/bart-large-cnn
-	apiURL = "https://router.huggingface.co/hf-inference/models/facebook/bart-large-cnn"
+	defaultModel = "facebook/bart-large-cnn"
+
+	// Timeout por defecto para esperar a que un endpoint esté listo (--warmup)
+	defaultWarmupTimeout = 5 * time.Minute
+
+	// Timeouts por defecto del cliente HTTP: total por solicitud y de conexión
+	defaultRequestTimeout = 30 * time.Second
+	defaultConnectTimeout = 10 * time.Second
 
 	// Longitud máxima de entrada para evitar límites de la API
 	maxInputLength = 1024
 
-	// Configuración de reintentos para solicitudes a la API
-	maxRetries        = 3
-	initialRetryDelay = 2 * time.Second
+	// Política de reintentos por defecto para solicitudes a la API
+	// (configurable con flags o con el archivo de configuración)
+	defaultMaxRetries     = 2
+	defaultRetryBaseDelay = 2 * time.Second
+	defaultRetryMaxDelay  = 30 * time.Second
+	defaultRetryJitter    = 0.0
 )
 
+// summaryTypes son los tipos de resumen soportados por --type
+var summaryTypes = []string{"short", "medium", "bullet", "outline", "timeline", "proscons", "glossary", "toc", "feedback"}
+
+// needsModel indica si el tipo de resumen requiere llamar al modelo; la
+// cronología se resuelve localmente sobre el documento
+func needsModel(summaryType string) bool {
+	return summaryType != "timeline"
+}
+
+// isExtractionType indica los tipos que extraen datos del documento (timeline,
+// glossary) en lugar de resumirlo, y que por eso no admiten --compare,
+// --with-sources, --per-speaker ni --csv/--jsonl
+func isExtractionType(summaryType string) bool {
+	return summaryType == "timeline" || summaryType == "glossary"
+}
+
+// isValidSummaryType indica si el tipo de resumen está soportado
+func isValidSummaryType(summaryType string) bool {
+	for _, t := range summaryTypes {
+		if t == summaryType {
+			return true
+		}
+	}
+	return false
+}
+
+// LevelTrace es un nivel más detallado que Debug, usado con -vv para volcar
+// los payloads completos de solicitud y respuesta
+const LevelTrace = slog.LevelDebug - 4
+
+// setupLogging configura el logger por defecto (slog) según la verbosidad pedida.
+// Todos los diagnósticos van a stderr para no contaminar el resumen en stdout:
+//   - quiet: solo errores
+//   - normal: información y advertencias
+//   - -v: depuración (solicitudes, respuestas y tiempos por intento)
+//   - -vv: trazas con los cuerpos completos de solicitud/respuesta
+func setupLogging(verbosity int, quiet bool) {
+	level := slog.LevelInfo
+	switch {
+	case quiet:
+		level = slog.LevelError
+	case verbosity >= 2:
+		level = LevelTrace
+	case verbosity == 1:
+		level = slog.LevelDebug
+	}
+
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// La marca de tiempo solo aporta en modo depuración
+			if a.Key == slog.TimeKey && len(groups) == 0 && verbosity == 0 {
+				return slog.Attr{}
+			}
+			if a.Key == slog.LevelKey {
+				if lvl, ok := a.Value.Any().(slog.Level); ok && lvl == LevelTrace {
+					a.Value = slog.StringValue("TRACE")
+				}
+			}
+			return a
+		},
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(logOutput, opts)))
+}
+
+// logOutput es el destino de los logs: stderr, salvo mientras el tablero de
+// un lote lo reemplaza para escribir los mensajes sin romperlo
+var logOutput = &logWriter{w: os.Stderr}
+
+// logWriter es un io.Writer cuyo destino puede cambiarse durante la ejecución
+type logWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	w := l.w
+	l.mu.Unlock()
+	return w.Write(p)
+}
+
+// redirect cambia el destino y devuelve el anterior
+func (l *logWriter) redirect(w io.Writer) io.Writer {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	previous := l.w
+	l.w = w
+	return previous
+}
+
+// sanitizeToken oculta el token de API para poder mostrarlo en los logs
+func sanitizeToken(token string) string {
+	if len(token) <= 8 {
+		return "****"
+	}
+	return token[:3] + "****" + token[len(token)-4:]
+}
+
+// redactURL prepara una URL para logs e informes: sin contraseña (ver
+// url.Redacted), sin query ni fragmento y, si no se pide la ruta, solo con el
+// host (la ruta de un webhook suele ser el secreto). Lo que no es una URL
+// absoluta se devuelve tal cual
+func redactURL(raw string, keepPath bool) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return raw
+	}
+	u.RawQuery, u.Fragment = "", ""
+	if !keepPath {
+		u.Path, u.RawPath = "", ""
+	}
+	return u.Redacted()
+}
+
+// redactedFlags devuelve los flags indicados en la línea de comandos, para
+// registrar la configuración de la ejecución sin secretos: las URLs pasan por
+// redactURL, y del webhook solo queda el host
+func redactedFlags(fs *flag.FlagSet) map[string]string {
+	flags := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		flags[f.Name] = redactURL(f.Value.String(), f.Name != "notify-webhook")
+	})
+	return flags
+}
+
 func main() {
-	// Verificar token de API
-	apiToken := os.Getenv("HUGGINGFACE_API_TOKEN")
-	if apiToken == "" {
-		fmt.Println("Error: No se encontró el token de HuggingFace API")
-		fmt.Println("")
-		fmt.Println("Para usar esta herramienta, necesitas un token gratuito de HuggingFace:")
-		fmt.Println("1. Ve a: https://huggingface.co/settings/tokens")
-		fmt.Println("2. Crea un nuevo token (cuenta gratuita)")
-		fmt.Println("3. Configura la variable de entorno:")
-		fmt.Println("")
-		fmt.Println("   PowerShell (sin comillas internas):")
-		fmt.Println("   $env:HUGGINGFACE_API_TOKEN = \"tu_token_aqui\"")
-		fmt.Println("")
-		fmt.Println("   PowerShell (con comillas simples):")
-		fmt.Println("   $env:HUGGINGFACE_API_TOKEN = 'tu_token_aqui'")
-		fmt.Println("")
-		fmt.Println("   CMD:")
-		fmt.Println("   set HUGGINGFACE_API_TOKEN=tu_token_aqui")
-		fmt.Println("")
-		fmt.Println("   Linux/Mac:")
-		fmt.Println("   export HUGGINGFACE_API_TOKEN=tu_token_aqui")
-		fmt.Println("")
-		fmt.Println("4. Verifica con: echo $env:HUGGINGFACE_API_TOKEN")
-		os.Exit(1)
+	// Subcomandos. "revisions" comparte los flags del comando principal y
+	// "completion"/"docs" los describen
+	args := os.Args[1:]
+	command := ""
+	if len(args) > 0 {
+		switch args[0] {
+		case "auth":
+			runAuthCommand(args[1:])
+			return
+		case "history":
+			runHistoryCommand(args[1:])
+			return
+		case "config":
+			runConfigCommand(args[1:])
+			return
+		case "revisions", "completion", "docs":
+			command, args = args[0], args[1:]
+		}
 	}
 
 	// Define CLI flags
 	var summaryType string
 	var inputFile string
+	var verbose, veryVerbose, quiet bool
+	var errorFormat string
+	var configPath string
+	var maxRetries int
+	var retryBaseDelay, retryMaxDelay time.Duration
+	var retryJitter float64
+	var outputFormat string
+	var modelName, provider, endpoint string
+	var warmup, healthCheck bool
+	var warmupTimeout time.Duration
+	var deadline time.Duration
+	var dryRun bool
+	var showStats bool
+	var compareList string
+	var proxyURL, caBundle string
+	var insecureSkipVerify bool
+	var requestTimeout, connectTimeout time.Duration
+	var maxWords, minWords, maxTokens, minTokens int
+	var compressionRatio float64
+	var perSpeaker bool
+	var filterMode, filterTerms string
+	var csvMode, jsonlMode, resume bool
+	var column, summaryColumn, outputPath string
+	var outputDir string
+	var runReportPath string
+	var notifyWebhook, slackChannel string
+	var noClobber, backup bool
+	var withSources bool
+	var lockTimeout time.Duration
+	var hierarchical bool
+	var maxMemory string
+	var reportPath string
+	var taskList string
+	var maxRequests, maxInputChars int
+	var minInputWords int
+	var shortInput string
+	var weightPattern string
+	var depth int
+	var symlinkPolicy string
+	var followSymlinks bool
+	var historyDB string
+	var noHistory bool
+	var tuiMode bool
+	var presetName string
+	var protocol string
+	var chaosSpec string
+
+	typeList := strings.Join(summaryTypes, ", ")
+	flag.StringVar(&summaryType, "type", "medium", "Summary type: "+typeList)
+	flag.StringVar(&summaryType, "t", "medium", "Summary type: "+typeList+" (shorthand)")
+	flag.StringVar(&presetName, "preset", "", "Preset from the \"presets\" config section (e.g. installed with \"config import\"): a --type with its own prompt, length and --report template")
+	flag.StringVar(&inputFile, "input", "", "Path to the text file to summarize")
+	flag.BoolVar(&verbose, "v", false, "Verbose output: log requests, responses and timing per attempt")
+	flag.BoolVar(&veryVerbose, "vv", false, "Very verbose output: also dump full request and response bodies")
+	flag.BoolVar(&quiet, "quiet", false, "Suppress warnings and informational messages")
+	flag.BoolVar(&quiet, "q", false, "Suppress warnings and informational messages (shorthand)")
+	flag.StringVar(&errorFormat, "error-format", "text", "Error output format on stderr: text or json")
+	flag.StringVar(&outputFormat, "format", "text", "Output format: text, json, structured (JSON with title, one_liner, key_points, entities, sentiment), or markdown (with --hierarchical)")
+	flag.StringVar(&modelName, "model", "", "Model ID, \"id:provider\", or alias from the config/built-in registry (default "+defaultModel+")")
+	flag.StringVar(&provider, "provider", "", "Inference provider used through the HuggingFace router (default "+defaultProvider+"), or \""+spacesProvider+"\" for a Gradio app on a Space")
+	flag.StringVar(&endpoint, "endpoint", "", "Full URL of a dedicated Inference Endpoint; overrides the router URL")
+	flag.BoolVar(&warmup, "warmup", false, "Wait until the endpoint is ready (model loaded / scaled up) before summarizing")
+	flag.DurationVar(&warmupTimeout, "warmup-timeout", defaultWarmupTimeout, "Maximum time to wait for the endpoint to become ready")
+	flag.BoolVar(&healthCheck, "health-check", false, "Only check whether the endpoint is ready and exit")
+	flag.BoolVar(&dryRun, "dry-run", false, "Report input size, model, parameters and expected API calls without sending any request")
+	flag.IntVar(&maxWords, "max-words", 0, "Maximum summary length in words (overrides the preset of --type)")
+	flag.IntVar(&minWords, "min-words", 0, "Minimum summary length in words (overrides the preset of --type)")
+	flag.IntVar(&maxTokens, "max-tokens", 0, "Maximum summary length in tokens (overrides the preset of --type)")
+	flag.IntVar(&minTokens, "min-tokens", 0, "Minimum summary length in tokens (overrides the preset of --type)")
+	flag.Float64Var(&compressionRatio, "compression-ratio", 0, "Target summary length as a fraction of the input, e.g. 0.1 for roughly 10%")
+	flag.BoolVar(&perSpeaker, "per-speaker", false, "For transcripts with speaker labels, summarize each participant's contributions separately")
+	flag.StringVar(&filterMode, "content-filter", "", "Filter profanity/unsafe terms in the output: off, mask, flag (warn on stderr) or block (exit with code 7)")
+	flag.StringVar(&filterTerms, "filter-terms", "", "File with additional terms for --content-filter, one per line")
+	flag.BoolVar(&csvMode, "csv", false, "Treat the input as CSV and summarize the --column of every row")
+	flag.BoolVar(&jsonlMode, "jsonl", false, "Treat the input as JSON Lines and summarize the --column field of every record")
+	flag.StringVar(&column, "column", "", "Column (CSV) or field (JSONL) with the text to summarize")
+	flag.StringVar(&summaryColumn, "summary-column", "summary", "Column or field where the summary is written")
+	flag.StringVar(&outputPath, "output", "", "Write the summary to this file instead of stdout (for --csv/--jsonl default: <input>.summarized.<ext>)")
+	flag.DurationVar(&lockTimeout, "lock-timeout", defaultLockTimeout, "How long to wait for another run to release the history database, manifest or output being written")
+	flag.BoolVar(&noClobber, "no-clobber", false, "Never overwrite existing output files (batch runs skip those inputs)")
+	flag.BoolVar(&backup, "backup", false, "Keep a copy of an existing output file as <file>.bak before replacing it")
+	flag.BoolVar(&resume, "resume", false, "Continue an interrupted --csv/--jsonl or --output-dir run, skipping what is already done")
+	flag.StringVar(&symlinkPolicy, "symlinks", "skip", "Symlinks found while walking input directories: skip, follow or error")
+	flag.BoolVar(&followSymlinks, "follow-symlinks", false, "Follow symlinks while walking input directories (same as --symlinks follow)")
+	flag.StringVar(&outputDir, "output-dir", "", "Summarize several files, writing <name>.summary.txt (or .json) for each one into this directory")
+	flag.BoolVar(&withSources, "with-sources", false, "After the summary, list the source sentences (with line numbers) that support each summary sentence or bullet")
+	flag.BoolVar(&hierarchical, "hierarchical", false, "Summarize long documents as a tree (sections -> chapters -> document), keeping every level in the output")
+	flag.StringVar(&weightPattern, "weight-pattern", "", "Regular expression for paragraphs that must always reach the model (as do regions marked with <!-- summarize:must -->)")
+	flag.IntVar(&maxRequests, "max-requests", 0, "Refuse to send more than this many API requests in this run (0 = config file or no limit)")
+	flag.IntVar(&maxInputChars, "max-input-chars", 0, "Refuse to send more than this many input characters in this run (0 = config file or no limit)")
+	flag.IntVar(&minInputWords, "min-input-words", 0, "Do not call the model for inputs shorter than this many words; see --short-input (0 = config file or always summarize)")
+	flag.StringVar(&shortInput, "short-input", "", "Output for inputs below --min-input-words: passthrough (the text unchanged, default) or notice")
+	flag.StringVar(&taskList, "tasks", "", "Run several tasks over one read of the input and print a combined result, e.g. summary,keywords,entities,sentiment (also title or any --type)")
+	flag.StringVar(&reportPath, "report", "", "Fill a Go template (Markdown, or HTML if the name contains .html) with one pipeline per section, e.g. {{.Title}}, {{.Summary \"short\"}}, {{.KeyPoints}}, {{.Keywords 8}}")
+	flag.StringVar(&notifyWebhook, "notify-webhook", "", "POST each completed summary as JSON to this URL")
+	flag.StringVar(&slackChannel, "slack-channel", "", "Post each completed summary to this Slack channel (needs SLACK_BOT_TOKEN)")
+	flag.StringVar(&runReportPath, "run-report", "", "In batch mode, write a JSON report of the run (files, durations, retries, failures, settings) to this file")
+	flag.StringVar(&maxMemory, "max-memory", "", "In batch mode, maximum size of document text kept in memory (e.g. 256MB); the rest is spooled to temporary files")
+	flag.DurationVar(&deadline, "deadline", 0, "Overall time limit for the run (e.g. 2m); --hierarchical plans its calls to fit and prints a partial summary if time runs out")
+	flag.IntVar(&depth, "depth", 0, "With --hierarchical, number of levels to build below the document summary (0 = follow all headings)")
+	flag.StringVar(&compareList, "compare", "", "Comma-separated models to run concurrently on the same input and compare side by side")
+	flag.BoolVar(&tuiMode, "tui", false, "Interactive terminal UI: file list with live progress and the selected summary, with keys to re-run a file with another --type or --model")
+	flag.StringVar(&protocol, "protocol", "", "Serve as a long-lived child process: jsonrpc reads JSON-RPC 2.0 requests (summarize, types, models, ping, shutdown), one per line, on stdin and writes responses on stdout")
+	flag.BoolVar(&showStats, "stats", false, "Print a metrics footer (requests, retries, latency percentiles, sizes) on stderr")
+	flag.StringVar(&historyDB, "history-db", "", "SQLite database where every summary is saved (default: <user config dir>/summarizer/history.db)")
+	flag.BoolVar(&noHistory, "no-history", false, "Do not save this run in the summary history")
+	flag.StringVar(&configPath, "config", "", "Path to a JSON config file (default: <user config dir>/summarizer/config.json)")
+	flag.IntVar(&maxRetries, "max-retries", defaultMaxRetries, "Number of retries after the first failed attempt (0 disables retries)")
+	flag.DurationVar(&retryBaseDelay, "retry-base-delay", defaultRetryBaseDelay, "Delay before the first retry; doubled on each further retry")
+	flag.DurationVar(&retryMaxDelay, "retry-max-delay", defaultRetryMaxDelay, "Upper bound for the delay between retries")
+	flag.Float64Var(&retryJitter, "retry-jitter", defaultRetryJitter, "Random jitter applied to each retry delay, as a fraction between 0 and 1")
+	flag.StringVar(&proxyURL, "proxy", "", "HTTP(S) proxy URL (default: HTTPS_PROXY/HTTP_PROXY environment variables)")
+	flag.StringVar(&caBundle, "ca-bundle", "", "PEM file with additional root CAs to trust (e.g. a corporate CA)")
+	flag.BoolVar(&insecureSkipVerify, "insecure-skip-verify", false, "Disable TLS certificate verification (INSECURE, for debugging only)")
+	flag.DurationVar(&requestTimeout, "timeout", defaultRequestTimeout, "Total timeout for each HTTP request")
+	flag.DurationVar(&connectTimeout, "connect-timeout", defaultConnectTimeout, "Timeout for establishing connections (TCP and TLS handshake)")
+	// --chaos es un modo de pruebas oculto: solo existe con SUMMARIZER_CHAOS=1
+	if os.Getenv(chaosEnv) == "1" {
+		flag.StringVar(&chaosSpec, "chaos", "", "Inject simulated failures into API requests, as fault=probability pairs: 429, 503, truncate, timeout (and seed=N), e.g. 429=0.2,503=0.1,timeout=0.05")
+	}
+
+	if command == "completion" || command == "docs" {
+		runDocsCommand(command, args, flag.CommandLine)
+		return
+	}
+
+	flag.CommandLine.Parse(args)
+
+	verbosity := 0
+	if verbose {
+		verbosity = 1
+	}
+	if veryVerbose {
+		verbosity = 2
+	}
+	setupLogging(verbosity, quiet)
+
+	errorFormat = strings.ToLower(errorFormat)
+	if errorFormat != "text" && errorFormat != "json" {
+		errorFormat = "text"
+		exitWithError(newCLIError(exitUsage, "usage",
+			fmt.Errorf("invalid error format '%s'. Must be: text or json", flag.Lookup("error-format").Value)), errorFormat)
+	}
+
+	// Cargar configuración; los flags explícitos tienen prioridad sobre el archivo
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		exitWithError(newCLIError(exitUsage, "usage", err), errorFormat)
+	}
+
+	// Un preset fija tipo, instrucción, longitud y plantilla; los flags
+	// explícitos tienen prioridad
+	var presetPrompts map[string]string
+	if presetName != "" {
+		preset, ok := cfg.Presets[presetName]
+		if !ok {
+			names := make([]string, 0, len(cfg.Presets))
+			for name := range cfg.Presets {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			err := newCLIError(exitUsage, "usage", fmt.Errorf("unknown preset '%s'", presetName))
+			err.Hint = "Presets in the config file: " + strings.Join(names, ", ")
+			if len(names) == 0 {
+				err.Hint = "The config file has no presets; add a \"presets\" section or install a bundle with \"config import\""
+			}
+			exitWithError(err, errorFormat)
+		}
+		if err := preset.validate(presetName); err != nil {
+			exitWithError(newCLIError(exitUsage, "usage", err), errorFormat)
+		}
+		explicit := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+		if !explicit["type"] && !explicit["t"] {
+			summaryType = preset.Type
+		}
+		if !explicit["max-words"] && preset.MaxWords > 0 {
+			maxWords = preset.MaxWords
+		}
+		if !explicit["min-words"] && preset.MinWords > 0 {
+			minWords = preset.MinWords
+		}
+		if !explicit["report"] && preset.Template != "" {
+			reportPath = preset.Template
+		}
+		if preset.Prompt != "" {
+			presetPrompts = map[string]string{strings.ToLower(summaryType): preset.Prompt}
+		}
+	}
+
+	policy := RetryPolicy{
+		MaxRetries: cfg.Retry.MaxRetries.orDefault(defaultMaxRetries),
+		BaseDelay:  cfg.Retry.BaseDelay.orDefault(defaultRetryBaseDelay),
+		MaxDelay:   cfg.Retry.MaxDelay.orDefault(defaultRetryMaxDelay),
+		Jitter:     cfg.Retry.Jitter.orDefault(defaultRetryJitter),
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "max-retries":
+			policy.MaxRetries = maxRetries
+		case "retry-base-delay":
+			policy.BaseDelay = retryBaseDelay
+		case "retry-max-delay":
+			policy.MaxDelay = retryMaxDelay
+		case "retry-jitter":
+			policy.Jitter = retryJitter
+		}
+	})
+	if err := policy.validate(); err != nil {
+		exitWithError(newCLIError(exitUsage, "usage", err), errorFormat)
+	}
+	slog.Debug("Effective retry policy", "max_retries", policy.MaxRetries, "base_delay", policy.BaseDelay,
+		"max_delay", policy.MaxDelay, "jitter", policy.Jitter)
+
+	httpOpts := HTTPOptions{
+		Proxy:              cfg.HTTP.Proxy,
+		CABundle:           cfg.HTTP.CABundle,
+		InsecureSkipVerify: cfg.HTTP.InsecureSkipVerify,
+		Timeout:            cfg.HTTP.Timeout.orDefault(defaultRequestTimeout),
+		ConnectTimeout:     cfg.HTTP.ConnectTimeout.orDefault(defaultConnectTimeout),
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "proxy":
+			httpOpts.Proxy = proxyURL
+		case "ca-bundle":
+			httpOpts.CABundle = caBundle
+		case "insecure-skip-verify":
+			httpOpts.InsecureSkipVerify = insecureSkipVerify
+		case "timeout":
+			httpOpts.Timeout = requestTimeout
+		case "connect-timeout":
+			httpOpts.ConnectTimeout = connectTimeout
+		}
+	})
+	httpClient, err := buildHTTPClient(httpOpts)
+	if err != nil {
+		exitWithError(newCLIError(exitUsage, "usage", err), errorFormat)
+	}
+	// Las notificaciones usan el cliente sin --chaos: las fallas simuladas son
+	// solo del proveedor
+	notifyHTTP := httpClient
+	if chaosSpec != "" {
+		chaos, err := parseChaosSpec(chaosSpec)
+		if err != nil {
+			exitWithError(newCLIError(exitUsage, "usage", err), errorFormat)
+		}
+		// Igual que con --insecure-skip-verify, la advertencia se ve incluso con --quiet
+		fmt.Fprintf(os.Stderr, "WARNING: --chaos is injecting simulated failures into API requests (seed %d).\n", chaos.Seed)
+		chaosClient := *httpClient
+		chaosClient.Transport = newChaosTransport(httpClient.Transport, chaos)
+		httpClient = &chaosClient
+	}
+
+	// Verificar token de API: primero la variable de entorno, luego el llavero del sistema.
+	// Solo se busca si la ejecución va a llamar al modelo: --dry-run y los tipos
+	// locales no consultan el llavero
+	apiToken := &tokenLookup{}
+	if !dryRun && needsModel(strings.ToLower(summaryType)) && apiToken.get() == "" {
+		exitWithError(errMissingToken(), errorFormat)
+	}
+
+	target, err := resolveModel(modelName, provider, endpoint, cfg)
+	if err != nil {
+		exitWithError(newCLIError(exitUsage, "usage", err), errorFormat)
+	}
+	slog.Debug("Resolved model", "name", target.Name, "id", target.ID, "provider", target.Provider,
+		"url", target.URL, "dedicated", target.Dedicated)
+
+	// Longitud del resumen: los flags explícitos reemplazan los valores del tipo
+	length, err := newLengthOptions(maxWords, minWords, maxTokens, minTokens, compressionRatio)
+	if err != nil {
+		exitWithError(newCLIError(exitUsage, "usage", err), errorFormat)
+	}
+
+	client := newAPIClient(apiToken, target, policy, httpClient)
+	client.Length = length
+	client.Prompts = presetPrompts
+	if deadline < 0 {
+		exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--deadline must not be negative")), errorFormat)
+	} else if deadline > 0 {
+		client.Deadline = time.Now().Add(deadline)
+	}
+
+	// Filtro de contenido de la salida, para resúmenes que se publican automáticamente
+	if filterMode == "" {
+		filterMode = cfg.Filter.Mode
+	}
+	if filterTerms == "" {
+		filterTerms = cfg.Filter.TermsFile
+	}
+	contentFilter, err := newContentFilter(filterMode, cfg.Filter.Terms, filterTerms)
+	if err != nil {
+		exitWithError(newCLIError(exitUsage, "usage", err), errorFormat)
+	}
+	filterOutput := func(text string) string {
+		filtered, err := contentFilter.filter(text)
+		if err != nil {
+			exitWithError(err, errorFormat)
+		}
+		return filtered
+	}
+	client.Metrics.Started = time.Now()
+	if showStats {
+		addExitHook(func() { client.Metrics.writeFooter(os.Stderr) })
+	}
+	defer runExitHooks()
+
+	// Comprobar el estado del endpoint sin resumir nada
+	if healthCheck {
+		ready, _, err := client.checkHealth()
+		if err != nil {
+			exitWithError(fmt.Errorf("health check: %w", err), errorFormat)
+		}
+		if !ready {
+			exitWithError(newCLIError(exitAPI, "api", fmt.Errorf("endpoint %s is not ready yet", target.URL)), errorFormat)
+		}
+		fmt.Printf("Endpoint %s is ready\n", target.URL)
+		return
+	}
+
+	// Resumir los cambios entre dos versiones de un documento
+	if command == "revisions" {
+		if flag.NArg() != 2 {
+			err := newCLIError(exitUsage, "usage", fmt.Errorf("revisions needs exactly two files"))
+			err.Hint = "Usage: go run solution_summarizer.go revisions [flags] <old file> <new file>"
+			exitWithError(err, errorFormat)
+		}
+		report, err := client.summarizeRevisions(flag.Arg(0), flag.Arg(1), outputFormat)
+		if err != nil {
+			exitWithError(err, errorFormat)
+		}
+		fmt.Println(filterOutput(report))
+		return
+	}
+
+	// Handle positional argument if --input not provided
+	// (--protocol recibe los textos por stdin)
+	if inputFile == "" && protocol == "" {
+		args := flag.Args()
+		if len(args) > 0 {
+			inputFile = args[0]
+		} else {
+			err := newCLIError(exitUsage, "usage", fmt.Errorf("no input file specified"))
+			typeChoices := strings.Join(summaryTypes, "|")
+			err.Hint = "Usage: go run solution_summarizer.go --input <file> --type <" + typeChoices + ">\n" +
+				"   or: go run solution_summarizer.go -t <" + typeChoices + "> <file>"
+			exitWithError(err, errorFormat)
+		}
+	}
+
+	// Validate summary type
+	summaryType = strings.ToLower(summaryType)
+	if !isValidSummaryType(summaryType) {
+		exitWithError(newCLIError(exitUsage, "usage",
+			fmt.Errorf("invalid summary type '%s'. Must be: %s", summaryType, typeList)), errorFormat)
+	}
+
+	// Validate output format
+	outputFormat = strings.ToLower(outputFormat)
+	if outputFormat != "text" && outputFormat != "json" && outputFormat != "structured" && outputFormat != "markdown" {
+		exitWithError(newCLIError(exitUsage, "usage",
+			fmt.Errorf("invalid output format '%s'. Must be: text, json, structured or markdown", outputFormat)), errorFormat)
+	}
+	if outputFormat == "markdown" && !hierarchical {
+		exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--format markdown requires --hierarchical")), errorFormat)
+	}
+	if hierarchical {
+		switch {
+		case summaryType != "short" && summaryType != "medium" && summaryType != "bullet":
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--hierarchical supports --type short, medium or bullet")), errorFormat)
+		case outputFormat == "structured":
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--hierarchical supports --format text, json or markdown")), errorFormat)
+		case depth < 0:
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--depth must not be negative")), errorFormat)
+		}
+	}
+
+	// Validar los modelos a comparar
+	var compareNames []string
+	if compareList != "" {
+		for _, name := range strings.Split(compareList, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				compareNames = append(compareNames, name)
+			}
+		}
+		switch {
+		case len(compareNames) < 2:
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--compare needs at least two models")), errorFormat)
+		case endpoint != "":
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--compare cannot be combined with --endpoint; define per-model endpoints in the config file")), errorFormat)
+		case isExtractionType(summaryType) || summaryType == "toc":
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--compare does not support --type %s", summaryType)), errorFormat)
+		case outputFormat == "structured":
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--compare supports --format text or json")), errorFormat)
+		case perSpeaker:
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--compare cannot be combined with --per-speaker")), errorFormat)
+		}
+	}
+	var report *reportTemplate
+	if reportPath != "" {
+		switch {
+		case hierarchical || perSpeaker || withSources || len(compareNames) > 0 || csvMode || jsonlMode:
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--report cannot be combined with --hierarchical, --per-speaker, --with-sources, --compare, --csv or --jsonl")), errorFormat)
+		case outputFormat != "text":
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--report writes the template as is; --format cannot be used")), errorFormat)
+		}
+		if report, err = loadReportTemplate(resolveReportPath(reportPath, configPath)); err != nil {
+			exitWithError(newCLIError(exitUsage, "usage", err), errorFormat)
+		}
+	}
+	var tasks []string
+	if taskList != "" {
+		if tasks, err = parseTasks(taskList); err != nil {
+			exitWithError(newCLIError(exitUsage, "usage", err), errorFormat)
+		}
+		switch {
+		case report != nil || hierarchical || perSpeaker || withSources || len(compareNames) > 0 || csvMode || jsonlMode:
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--tasks cannot be combined with --report, --hierarchical, --per-speaker, --with-sources, --compare, --csv or --jsonl")), errorFormat)
+		case outputFormat != "text" && outputFormat != "json":
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--tasks supports --format text or json")), errorFormat)
+		}
+	}
+	if hierarchical && (perSpeaker || withSources || len(compareNames) > 0 || csvMode || jsonlMode) {
+		exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--hierarchical cannot be combined with --per-speaker, --with-sources, --compare, --csv or --jsonl")), errorFormat)
+	}
+	if withSources {
+		switch {
+		case isExtractionType(summaryType) || summaryType == "toc" || perSpeaker:
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--with-sources is not supported with --type %s or --per-speaker", summaryType)), errorFormat)
+		case outputFormat == "structured" || len(compareNames) > 0 || csvMode || jsonlMode:
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--with-sources supports a single summary with --format text or json")), errorFormat)
+		}
+	}
+	if perSpeaker {
+		switch {
+		case isExtractionType(summaryType) || summaryType == "toc" || summaryType == "feedback":
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--per-speaker does not support --type %s", summaryType)), errorFormat)
+		case outputFormat == "structured":
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--per-speaker supports --format text or json")), errorFormat)
+		}
+	}
+
+	// Pasajes prioritarios además de los marcados en el documento
+	var weight *regexp.Regexp
+	if weightPattern != "" {
+		if weight, err = regexp.Compile(weightPattern); err != nil {
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("invalid --weight-pattern: %w", err)), errorFormat)
+		}
+	}
+
+	// Límites de uso para no agotar un token compartido por accidente
+	budget := newRequestBudget(cfg.Budget, lockTimeout)
+	if maxRequests > 0 {
+		budget.MaxRequests = maxRequests
+	}
+	if maxInputChars > 0 {
+		budget.MaxInputChars = maxInputChars
+	}
+	if maxRequests < 0 || maxInputChars < 0 {
+		exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--max-requests and --max-input-chars must not be negative")), errorFormat)
+	}
+	if budget.enabled() {
+		client.Budget = budget
+	}
+
+	// Entradas demasiado cortas para resumir: se devuelven sin llamar al modelo
+	guard := shortInputGuard{MinWords: cfg.Input.MinWords, Mode: cfg.Input.ShortInput}
+	if minInputWords > 0 {
+		guard.MinWords = minInputWords
+	}
+	if shortInput != "" {
+		guard.Mode = shortInput
+	}
+	if guard.Mode = strings.ToLower(guard.Mode); guard.Mode == "" {
+		guard.Mode = "passthrough"
+	}
+	switch {
+	case minInputWords < 0 || guard.MinWords < 0:
+		exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--min-input-words must not be negative")), errorFormat)
+	case guard.Mode != "passthrough" && guard.Mode != "notice":
+		exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("invalid --short-input '%s'. Must be: passthrough or notice", guard.Mode)), errorFormat)
+	}
+	client.ShortInput = guard
+
+	// Guardar cada resumen en el historial para poder recuperarlo después
+	if historyDB == "" {
+		historyDB = cfg.History.Path
+	}
+	// Sin una base pedida explícitamente, la falta de sqlite3 no merece
+	// advertencias: el historial por defecto simplemente no se guarda
+	historyExplicit := historyDB != ""
+	var historyUnavailable sync.Once
+	if historyDB == "" {
+		historyDB = defaultHistoryPath()
+	}
+	historyModel := target.ID
+	if !needsModel(summaryType) {
+		historyModel = "local"
+	}
+	saveHistoryAs := func(path, document, output, model, summaryType string) {
+		if noHistory || cfg.History.Disabled {
+			return
+		}
+		if _, err := exec.LookPath("sqlite3"); err != nil && !historyExplicit {
+			historyUnavailable.Do(func() {
+				slog.Debug("Summary history disabled", "error", errSQLiteUnavailable)
+			})
+			return
+		}
+		entry := historyEntry{
+			InputHash: hashInput(document),
+			FileName:  path,
+			Model:     model,
+			Type:      summaryType,
+			Summary:   output,
+		}
+		if abs, err := filepath.Abs(path); err == nil && path != "" {
+			entry.FileName = abs
+		}
+		lock, err := acquireLock(historyDB, lockTimeout)
+		if err != nil {
+			slog.Warn("Summary not saved to history", "error", err)
+			return
+		}
+		id, err := recordHistory(historyDB, entry)
+		lock.release()
+		if err != nil {
+			slog.Warn("Summary not saved to history (use --no-history to disable)", "error", err)
+			return
+		}
+		slog.Debug("Summary saved to history", "id", id, "db", historyDB)
+	}
+	saveHistory := func(path, document, output string) {
+		saveHistoryAs(path, document, output, historyModel, summaryType)
+	}
+
+	// Entregar cada resumen terminado a un webhook o a un canal de Slack
+	notify := &notifier{Webhook: notifyWebhook, SlackChannel: slackChannel, HTTP: notifyHTTP, Retry: policy,
+		Model: historyModel, SummaryType: summaryType}
+
+	// La sección "outputs" del config reparte el resumen de un solo documento
+	// entre varios destinos, cada uno con su formato
+	outputs := cfg.Outputs
+	slackNeeded := slackChannel != ""
+	for i := range outputs {
+		render := renderOptions{Format: outputFormat, Hierarchical: hierarchical, Report: report, Tasks: tasks}
+		if err := outputs[i].validate(i, render); err != nil {
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("config: %w", err)), errorFormat)
+		}
+		slackNeeded = slackNeeded || outputs[i].SlackChannel != ""
+	}
+	if slackNeeded {
+		if notify.SlackToken = os.Getenv("SLACK_BOT_TOKEN"); notify.SlackToken == "" {
+			err := newCLIError(exitAuth, "auth", fmt.Errorf("--slack-channel needs a Slack bot token"))
+			if slackChannel == "" {
+				err = newCLIError(exitAuth, "auth", fmt.Errorf("the Slack outputs in the config file need a Slack bot token"))
+			}
+			err.Hint = "Set SLACK_BOT_TOKEN to a bot token (xoxb-...) with the chat:write scope"
+			exitWithError(err, errorFormat)
+		}
+	}
+	if notifyWebhook != "" {
+		if u, err := url.Parse(notifyWebhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("invalid --notify-webhook '%s': must be an http(s) URL", notifyWebhook)), errorFormat)
+		}
+	}
+
+	// Los archivos de salida se escriben de forma atómica (archivo temporal + rename)
+	if noClobber && backup {
+		exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--no-clobber and --backup cannot be combined")), errorFormat)
+	}
+	writePolicy := outputPolicy{NoClobber: noClobber, Backup: backup}
+
+	// Resumir con un tipo, formato o modelo distinto del de los flags, para
+	// --tui y --protocol; los clientes de cada modelo se crean una sola vez
+	models := modelNames(cfg)
+	if !slices.Contains(models, target.Name) {
+		models = append([]string{target.Name}, models...)
+	}
+	clients := map[string]*APIClient{target.Name: client}
+	clientFor := func(model string) (*APIClient, error) {
+		if c, ok := clients[model]; ok {
+			return c, nil
+		}
+		t, err := resolveModel(model, provider, "", cfg)
+		if err != nil {
+			return nil, err
+		}
+		c := newAPIClient(apiToken, t, policy, httpClient)
+		c.Metrics, c.Length, c.Deadline, c.Budget = client.Metrics, client.Length, client.Deadline, client.Budget
+		c.Prompts, c.ShortInput = client.Prompts, client.ShortInput
+		clients[model] = c
+		return c, nil
+	}
+	summarizeWith := func(path, document, summaryType, format, model string) (string, error) {
+		c, err := clientFor(model)
+		if err != nil {
+			return "", newCLIError(exitUsage, "usage", err)
+		}
+		render := renderOptions{Type: summaryType, Format: format, PerSpeaker: perSpeaker, WithSources: withSources,
+			Hierarchical: hierarchical, Depth: depth, Quiet: true, Report: report, Tasks: tasks, Weight: weight}
+		if withSources {
+			render.SourceText = document
+			if path != "" {
+				render.SourceText = rawText(path)
+			}
+		}
+		output, err := c.summarizeDocument(document, render)
+		if err == nil {
+			output, err = contentFilter.filter(output)
+		}
+		if err != nil {
+			return "", err
+		}
+		historyModel := c.Target.ID
+		if !needsModel(summaryType) {
+			historyModel = "local"
+		}
+		saveHistoryAs(path, document, output, historyModel, summaryType)
+		return output, nil
+	}
+
+	// Proceso de larga duración para editores y otras herramientas: solicitudes
+	// JSON-RPC por stdin y respuestas por stdout
+	if protocol != "" {
+		switch {
+		case protocol != "jsonrpc":
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("invalid --protocol '%s'. Must be: jsonrpc", protocol)), errorFormat)
+		case tuiMode || dryRun || len(compareNames) > 0 || csvMode || jsonlMode || outputDir != "" || outputPath != "" || len(flag.Args()) > 0 || inputFile != "":
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--protocol reads its input from stdin; it cannot be combined with input files, --tui, --dry-run, --compare, --csv, --jsonl, --output-dir or --output")), errorFormat)
+		}
+		if warmup && needsModel(summaryType) {
+			if err := client.warmUp(warmupTimeout); err != nil {
+				exitWithError(fmt.Errorf("warming up endpoint: %w", err), errorFormat)
+			}
+		}
+		// stdin es el canal de las solicitudes: superar el presupuesto no se pregunta
+		if client.Budget != nil {
+			client.Budget.noPrompt = true
+		}
+		handlers := map[string]rpcHandler{
+			"summarize": func(raw json.RawMessage) (interface{}, error) {
+				params := rpcSummarizeParams{Type: summaryType, Format: outputFormat, Model: target.Name}
+				if err := decodeRPCParams(raw, &params); err != nil {
+					return nil, err
+				}
+				params.Type, params.Format = strings.ToLower(params.Type), strings.ToLower(params.Format)
+				switch {
+				case (params.Text == "") == (params.File == ""):
+					return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid params: exactly one of text or file is required"}
+				case !isValidSummaryType(params.Type):
+					return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("invalid params: unknown type '%s'", params.Type), Data: summaryTypes}
+				case params.Format != "text" && params.Format != "json" && params.Format != "structured" && (params.Format != "markdown" || !hierarchical):
+					return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("invalid params: unsupported format '%s'", params.Format)}
+				}
+				document := params.Text
+				if params.File != "" {
+					content, err := readFile(params.File)
+					if err != nil {
+						return nil, newCLIError(exitInput, "input", fmt.Errorf("reading file '%s': %w", params.File, err))
+					}
+					document = content
+				}
+				output, err := summarizeWith(params.File, document, params.Type, params.Format, params.Model)
+				if err != nil {
+					return nil, err
+				}
+				modelID := "local"
+				if needsModel(params.Type) {
+					c, _ := clientFor(params.Model)
+					modelID = c.Target.ID
+				}
+				return rpcSummary{Summary: output, Type: params.Type, Format: params.Format, Model: modelID, Chars: len(document)}, nil
+			},
+			"types":  func(json.RawMessage) (interface{}, error) { return summaryTypes, nil },
+			"models": func(json.RawMessage) (interface{}, error) { return models, nil },
+			"ping":   func(json.RawMessage) (interface{}, error) { return "pong", nil },
+		}
+		if err := serveJSONRPC(os.Stdin, os.Stdout, handlers); err != nil {
+			exitWithError(err, errorFormat)
+		}
+		return
+	}
+
+	// Resumir una columna de un CSV o un campo de un JSONL, fila por fila
+	if csvMode || jsonlMode {
+		switch {
+		case csvMode && jsonlMode:
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--csv and --jsonl cannot be combined")), errorFormat)
+		case column == "":
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--csv/--jsonl need --column with the name of the text column")), errorFormat)
+		case isExtractionType(summaryType) || summaryType == "toc":
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--csv/--jsonl do not support --type %s", summaryType)), errorFormat)
+		case len(compareNames) > 0 || perSpeaker:
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--csv/--jsonl cannot be combined with --compare or --per-speaker")), errorFormat)
+		}
+		opts := tableOptions{
+			Input:         inputFile,
+			Output:        outputPath,
+			Format:        "csv",
+			Column:        column,
+			SummaryColumn: summaryColumn,
+			Resume:        resume,
+			Policy:        writePolicy,
+			LockTimeout:   lockTimeout,
+		}
+		if jsonlMode {
+			opts.Format = "jsonl"
+		}
+		if opts.Output == "" {
+			ext := filepath.Ext(inputFile)
+			opts.Output = strings.TrimSuffix(inputFile, ext) + ".summarized" + ext
+		}
+		if warmup && !dryRun {
+			if err := client.warmUp(warmupTimeout); err != nil {
+				exitWithError(fmt.Errorf("warming up endpoint: %w", err), errorFormat)
+			}
+		}
+		summarizeRow := func(text string) (string, error) {
+			if output, skipped := client.ShortInput.check(text); skipped {
+				return contentFilter.filter(output)
+			}
+			text = markPriority(text, weight)
+			if len(text) > maxInputLength {
+				slog.Debug("Row truncated", "original_chars", len(text), "max_chars", maxInputLength)
+				text = fitPriority(text, maxInputLength)
+			}
+			summary, err := client.summarizeText(text, summaryType)
+			if err != nil {
+				return "", err
+			}
+			return contentFilter.filter(renderSummary(summary, text, summaryType))
+		}
+		if err := runTableMode(opts, summarizeRow, dryRun, quiet); err != nil {
+			exitWithError(err, errorFormat)
+		}
+		return
+	}
+
+	// Procesar varios archivos: cada resumen se escribe en --output-dir y el
+	// manifiesto registra los terminados para poder reanudar con --resume
+	batchInputs := flag.Args()
+	if inputFile != "" && (len(batchInputs) == 0 || batchInputs[0] != inputFile) {
+		batchInputs = append([]string{inputFile}, batchInputs...)
+	}
+	if followSymlinks {
+		symlinkPolicy = "follow"
+	}
+	if symlinkPolicy != "skip" && symlinkPolicy != "follow" && symlinkPolicy != "error" {
+		exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("invalid --symlinks '%s'. Must be: skip, follow or error", symlinkPolicy)), errorFormat)
+	}
+	batchInputs, hasDir, err := expandInputs(batchInputs, symlinkPolicy)
+	if err != nil {
+		exitWithError(newCLIError(exitInput, "input", err), errorFormat)
+	}
+	if hasDir && len(batchInputs) == 0 {
+		exitWithError(newCLIError(exitInput, "input", fmt.Errorf("no text files found in the input directories")), errorFormat)
+	}
+	if runReportPath != "" && len(batchInputs) <= 1 && outputDir == "" && !hasDir {
+		exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--run-report is only available in batch mode (--output-dir)")), errorFormat)
+	}
+
+	// Interfaz interactiva: los resúmenes se muestran en la terminal
+	if tuiMode {
+		switch {
+		case dryRun || len(compareNames) > 0 || outputDir != "" || outputPath != "":
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--tui cannot be combined with --dry-run, --compare, --output-dir or --output")), errorFormat)
+		case len(batchInputs) == 0:
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--tui needs at least one input file")), errorFormat)
+		}
+		if warmup && needsModel(summaryType) {
+			if err := client.warmUp(warmupTimeout); err != nil {
+				exitWithError(fmt.Errorf("warming up endpoint: %w", err), errorFormat)
+			}
+		}
+		// La interfaz ocupa la terminal: superar el presupuesto no se pregunta
+		if client.Budget != nil {
+			client.Budget.noPrompt = true
+		}
+		summarize := func(path, summaryType, model string) (string, error) {
+			document, err := readFile(path)
+			if err != nil {
+				return "", newCLIError(exitInput, "input", fmt.Errorf("reading file '%s': %w", path, err))
+			}
+			return summarizeWith(path, document, summaryType, outputFormat, model)
+		}
+		opts := tuiOptions{Types: summaryTypes, Models: models, Type: summaryType, Model: target.Name}
+		if err := runTUI(batchInputs, opts, summarize); err != nil {
+			exitWithError(err, errorFormat)
+		}
+		return
+	}
+
+	if len(batchInputs) > 1 || outputDir != "" || hasDir {
+		if len(outputs) > 0 {
+			slog.Warn("The outputs section of the config file only applies to single-document runs; ignoring it")
+		}
+		switch {
+		case outputDir == "":
+			err := newCLIError(exitUsage, "usage", fmt.Errorf("summarizing %d files needs --output-dir", len(batchInputs)))
+			err.Hint = "Usage: go run solution_summarizer.go --output-dir <dir> [flags] <file>..."
+			exitWithError(err, errorFormat)
+		case len(compareNames) > 0:
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--compare cannot be combined with --output-dir")), errorFormat)
+		}
+		if warmup && !dryRun && needsModel(summaryType) {
+			if err := client.warmUp(warmupTimeout); err != nil {
+				exitWithError(fmt.Errorf("warming up endpoint: %w", err), errorFormat)
+			}
+		}
+		memoryCap, err := parseByteSize(maxMemory)
+		if err != nil {
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("invalid --max-memory: %w", err)), errorFormat)
+		}
+		opts := batchOptions{OutputDir: outputDir, Extension: ".txt", Resume: resume, NeedsModel: needsModel(summaryType), Policy: writePolicy,
+			LockTimeout: lockTimeout, MaxMemory: memoryCap, Metrics: client.Metrics, ReportPath: runReportPath, Notify: notify,
+			Budget: client.Budget}
+		opts.Settings = runSettings{
+			Model:       target.Name,
+			ModelID:     target.ID,
+			Provider:    target.Provider,
+			Endpoint:    redactURL(target.URL, true),
+			SummaryType: summaryType,
+			Format:      outputFormat,
+			OutputDir:   outputDir,
+			Resume:      resume,
+			MaxRetries:  policy.MaxRetries,
+			Flags:       redactedFlags(flag.CommandLine),
+		}
+		switch outputFormat {
+		case "json", "structured":
+			opts.Extension = ".json"
+		case "markdown":
+			opts.Extension = ".md"
+		}
+		if report != nil {
+			opts.Extension = report.Extension
+		}
+		process := func(path, document string) (string, error) {
+			client.waitForQuota()
+			// El tablero del lote ya muestra el avance: sin la barra de --hierarchical
+			render := renderOptions{Type: summaryType, Format: outputFormat, PerSpeaker: perSpeaker, WithSources: withSources,
+				Hierarchical: hierarchical, Depth: depth, Quiet: true, Report: report, Tasks: tasks, Weight: weight}
+			if withSources {
+				render.SourceText = rawText(path)
+			}
+			output, err := client.summarizeDocument(document, render)
+			if err != nil {
+				return "", err
+			}
+			if output, err = contentFilter.filter(output); err != nil {
+				return "", err
+			}
+			saveHistory(path, document, output)
+			return output, nil
+		}
+		if err := runBatch(batchInputs, opts, process, dryRun, quiet); err != nil {
+			exitWithError(err, errorFormat)
+		}
+		return
+	}
+
+	// Leer el archivo de entrada
+	content, err := readFile(inputFile)
+	if err != nil {
+		exitWithError(newCLIError(exitInput, "input",
+			fmt.Errorf("reading file '%s': %w", inputFile, err)), errorFormat)
+	}
+
+	// Conservar el documento completo para los campos derivados de la salida estructurada
+	document := content
+
+	if dryRun {
+		printDryRun(inputFile, document, renderOptions{Type: summaryType, PerSpeaker: perSpeaker, Hierarchical: hierarchical, Depth: depth, Report: report, Tasks: tasks}, client, warmup)
+		return
+	}
+
+	// Comparar varios modelos sobre la misma entrada
+	if len(compareNames) > 0 {
+		if len(outputs) > 0 {
+			slog.Warn("The outputs section of the config file does not apply to --compare; ignoring it")
+		}
+		content = prepareInput(document, summaryType, weight)
+		var clients []*APIClient
+		for _, name := range compareNames {
+			t, err := resolveModel(name, provider, "", cfg)
+			if err != nil {
+				exitWithError(newCLIError(exitUsage, "usage", err), errorFormat)
+			}
+			c := newAPIClient(apiToken, t, policy, httpClient)
+			// Todas las ejecuciones comparten las métricas de --stats
+			c.Metrics = client.Metrics
+			c.Length = client.Length
+			c.Prompts = client.Prompts
+			c.ShortInput = client.ShortInput
+			clients = append(clients, c)
+		}
+		results := compareModels(clients, content, document, summaryType, warmup, warmupTimeout)
+		if outputFormat == "json" {
+			data, _ := json.MarshalIndent(results, "", "  ")
+			fmt.Println(filterOutput(string(data)))
+		} else {
+			fmt.Println(filterOutput(formatSideBySide(results, terminalWidth())))
+		}
+		for _, r := range results {
+			if r.Error == "" {
+				return
+			}
+		}
+		exitWithError(newCLIError(exitAPI, "api", fmt.Errorf("all %d models failed", len(results))), errorFormat)
+	}
+
+	// Esperar a que el endpoint esté listo (modelo cargado o endpoint escalado desde cero)
+	if warmup && needsModel(summaryType) {
+		if err := client.warmUp(warmupTimeout); err != nil {
+			exitWithError(fmt.Errorf("warming up endpoint: %w", err), errorFormat)
+		}
+	}
+
+	// Con --no-clobber, fallar antes de llamar al modelo si la salida ya existe
+	if outputPath != "" {
+		if err := writePolicy.check(outputPath); err != nil {
+			exitWithError(err, errorFormat)
+		}
+	}
+
+	// Generar y mostrar el resumen
+	render := renderOptions{Type: summaryType, Format: outputFormat, PerSpeaker: perSpeaker, WithSources: withSources,
+		Hierarchical: hierarchical, Depth: depth, Quiet: quiet, Report: report, Tasks: tasks, Weight: weight}
+	if withSources {
+		render.SourceText = rawText(inputFile)
+	}
+	if len(outputs) > 0 {
+		client.Memo = newSummaryMemo()
+	}
+	output, err := client.summarizeDocument(document, render)
+	if err != nil {
+		exitWithError(err, errorFormat)
+	}
+	output = filterOutput(output)
+	if outputPath != "" {
+		path, err := fsPath(outputPath)
+		if err == nil {
+			err = writeFileAtomic(path, []byte(output+"\n"), writePolicy)
+		}
+		if err != nil {
+			exitWithError(fmt.Errorf("writing output: %w", err), errorFormat)
+		}
+		slog.Info("Summary written", "file", outputPath)
+	} else {
+		fmt.Println(output)
+	}
+	saveHistory(inputFile, document, output)
+	notify.send(inputFile, outputPath, output)
+
+	if len(outputs) > 0 {
+		renderAs := func(format string) (string, error) {
+			if format == outputFormat {
+				return output, nil
+			}
+			r := render
+			r.Format = format
+			out, err := client.summarizeDocument(document, r)
+			if err != nil {
+				return "", err
+			}
+			return filterOutput(out), nil
+		}
+		if err := deliverOutputs(outputs, inputFile, renderAs, notify, writePolicy); err != nil {
+			exitWithError(fmt.Errorf("writing outputs: %w", err), errorFormat)
+		}
+	}
+}
+
+// prepareInput arma el texto que se envía al modelo: en los diálogos (ver
+// isDialogue) se conserva quién dijo qué, reescribiendo cada intervención en estilo indirecto
+// ("Alice said: ..."), y el resultado se recorta a maxInputLength conservando
+// los pasajes prioritarios
+func prepareInput(document, summaryType string, weight *regexp.Regexp) string {
+	content := document
+	if turns := parseTranscript(document); isDialogue(turns) && summaryType != "toc" && summaryType != "feedback" {
+		slog.Info("Transcript detected", "turns", len(turns), "speakers", len(transcriptSpeakers(turns)))
+		content = attributedText(turns)
+	}
+	content = markPriority(content, weight)
+	if len(content) > maxInputLength {
+		slog.Warn("Input truncated", "original_chars", len(content), "max_chars", maxInputLength)
+		content = fitPriority(content, maxInputLength)
+	}
+	return content
+}
+
+// priorityMarker antecede a los pasajes que siempre deben llegar al modelo
+const priorityMarker = "[IMPORTANT] "
+
+// priorityNote es la indicación que se agrega al prompt si hay pasajes prioritarios
+const priorityNote = "Passages marked [IMPORTANT] must be covered in the summary."
+
+var (
+	priorityOpenRe  = regexp.MustCompile(`(?i)<!--\s*summarize:must\s*-->`)
+	priorityCloseRe = regexp.MustCompile(`(?i)<!--\s*/summarize:must\s*-->`)
+)
+
+// markPriority quita los comentarios <!-- summarize:must --> y antepone
+// priorityMarker a cada párrafo marcado o que coincide con weight. Un
+// marcador con cierre <!-- /summarize:must --> abarca toda la región; sin
+// cierre, abarca el párrafo siguiente
+func markPriority(text string, weight *regexp.Regexp) string {
+	opens := priorityOpenRe.FindAllStringIndex(text, -1)
+	if len(opens) == 0 && weight == nil {
+		return text
+	}
+	closes := priorityCloseRe.FindAllStringIndex(text, -1)
+
+	var b strings.Builder
+	pos := 0
+	for len(opens) > 0 {
+		open := opens[0]
+		opens = opens[1:]
+		b.WriteString(text[pos:open[0]])
+		pos = open[1]
+		// La región termina en el primer cierre, salvo que antes se abra otra
+		end := -1
+		for len(closes) > 0 && closes[0][0] < pos {
+			closes = closes[1:]
+		}
+		if len(closes) > 0 && (len(opens) == 0 || closes[0][0] < opens[0][0]) {
+			end = closes[0][0]
+		}
+		if end >= 0 {
+			b.WriteString("\n\n" + markBlock(text[pos:end]) + "\n\n")
+			pos = closes[0][1]
+			closes = closes[1:]
+			continue
+		}
+		// Sin cierre: el párrafo que sigue al marcador
+		rest := strings.TrimLeft(text[pos:], " \t\r\n")
+		limit := len(rest)
+		if len(opens) > 0 {
+			limit = opens[0][0] - (len(text) - len(rest))
+		}
+		paragraph := rest[:limit]
+		if k := strings.Index(paragraph, "\n\n"); k >= 0 {
+			paragraph = paragraph[:k]
+		}
+		b.WriteString("\n\n" + markBlock(paragraph) + "\n\n")
+		pos = len(text) - len(rest) + len(paragraph)
+	}
+	b.WriteString(text[pos:])
+	text = priorityCloseRe.ReplaceAllString(b.String(), "")
+
+	if weight != nil {
+		parts := strings.Split(text, "\n\n")
+		for i, part := range parts {
+			if strings.TrimSpace(part) != "" && weight.MatchString(part) {
+				parts[i] = markParagraph(strings.TrimSpace(part))
+			}
+		}
+		text = strings.Join(parts, "\n\n")
+	}
+	return strings.TrimSpace(multiBlankRe.ReplaceAllString(text, "\n\n"))
+}
+
+// markBlock marca cada párrafo de un bloque
+func markBlock(block string) string {
+	var marked []string
+	for _, part := range strings.Split(strings.TrimSpace(block), "\n\n") {
+		if part = strings.TrimSpace(part); part != "" {
+			marked = append(marked, markParagraph(part))
+		}
+	}
+	return strings.Join(marked, "\n\n")
+}
+
+// multiBlankRe encuentra varias líneas en blanco seguidas
+var multiBlankRe = regexp.MustCompile(`\n{3,}`)
+
+// markParagraph antepone priorityMarker a un párrafo; en los encabezados
+// Markdown el marcador va después de la primera línea para no romperlos
+func markParagraph(paragraph string) string {
+	if strings.HasPrefix(paragraph, priorityMarker) || strings.Contains(paragraph, "\n"+priorityMarker) {
+		return paragraph
+	}
+	if strings.HasPrefix(paragraph, "#") {
+		if i := strings.Index(paragraph, "\n"); i >= 0 {
+			return paragraph[:i+1] + priorityMarker + paragraph[i+1:]
+		}
+		return paragraph
+	}
+	return priorityMarker + paragraph
+}
+
+// fitPriority recorta un texto a limit caracteres: primero entran los
+// párrafos prioritarios y después los demás, en el orden del documento.
+// Sin pasajes prioritarios se conserva el comienzo del texto
+func fitPriority(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	if !strings.Contains(text, priorityMarker) {
+		return text[:limit]
+	}
+	parts := strings.Split(text, "\n\n")
+	keep := make([]string, len(parts))
+	used := 0
+	take := func(i int) {
+		sep := 0
+		if used > 0 {
+			sep = 2
+		}
+		if room := limit - used - sep; room > 0 {
+			keep[i] = clipText(parts[i], room)
+			if keep[i] != "" {
+				used += sep + len(keep[i])
+			}
+		}
+	}
+	for pass := 0; pass < 2; pass++ {
+		for i, part := range parts {
+			if keep[i] == "" && strings.TrimSpace(part) != "" && (pass == 1 || strings.Contains(part, priorityMarker)) {
+				take(i)
+			}
+		}
+	}
+	var kept []string
+	for _, part := range keep {
+		if part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, "\n\n")
+}
+
+// renderOptions indica qué salida generar para un documento
+type renderOptions struct {
+	Type       string
+	Format     string
+	PerSpeaker bool
+	// WithSources agrega las oraciones del original que respaldan cada
+	// afirmación; SourceText es el archivo sin normalizar, para los números de línea
+	WithSources bool
+	SourceText  string
+	// Hierarchical arma un árbol de resúmenes de hasta Depth niveles (0 = todos)
+	Hierarchical bool
+	Depth        int
+	Quiet        bool
+	// Report es la plantilla de --report; cada sección es un resumen aparte
+	Report *reportTemplate
+	// Tasks son las tareas de --tasks, que comparten la lectura del documento
+	Tasks []string
+	// Weight marca como prioritarios los párrafos que coinciden (--weight-pattern)
+	Weight *regexp.Regexp
+}
+
+// section son las opciones de un resumen pedido por --report o --tasks: las
+// mismas del documento (p. ej. --weight-pattern), en texto y de un solo tipo
+func (opts renderOptions) section(summaryType string) renderOptions {
+	opts.Type, opts.Format = summaryType, "text"
+	opts.Report, opts.Tasks = nil, nil
+	return opts
+}
+
+// summarizeDocument genera la salida completa para un documento según el tipo
+// y el formato: extracción local, tabla de contenidos, resumen por hablante o
+// resumen del modelo en texto, JSON o salida estructurada
+func (c *APIClient) summarizeDocument(document string, opts renderOptions) (string, error) {
+	summaryType, outputFormat := opts.Type, opts.Format
+
+	// Informe: la plantilla pide cada resumen que necesita
+	if opts.Report != nil {
+		data := newReportData(document, summaryType, func(t string) (string, error) {
+			return c.summarizeDocument(document, opts.section(t))
+		})
+		return opts.Report.render(data)
+	}
+
+	// Varias tareas sobre el mismo documento, con un resultado combinado
+	if len(opts.Tasks) > 0 {
+		data := newReportData(document, summaryType, func(t string) (string, error) {
+			return c.summarizeDocument(document, opts.section(t))
+		})
+		results, err := runTasks(opts.Tasks, data)
+		if err != nil {
+			return "", err
+		}
+		if outputFormat == "json" {
+			combined := map[string]interface{}{"model": c.Target.ID}
+			for _, r := range results {
+				combined[r.Task] = r.Value
+			}
+			out, _ := json.MarshalIndent(combined, "", "  ")
+			return string(out), nil
+		}
+		return formatTaskResults(results), nil
+	}
+
+	// La cronología se resuelve localmente, sin llamar al modelo
+	if summaryType == "timeline" {
+		extracted, err := formatTimeline(document)
+		if err != nil {
+			return "", newCLIError(exitInput, "input", err)
+		}
+		return extracted, nil
+	}
+	// El glosario solo llama al modelo por las siglas que el texto no define
+	if summaryType == "glossary" {
+		return c.formatGlossary(document)
+	}
+
+	// Entradas demasiado cortas: no se llama al modelo
+	if output, skipped := c.ShortInput.check(document); skipped {
+		switch outputFormat {
+		case "structured":
+			// El schema no admite un aviso: los campos salen del propio documento
+			structured, err := buildStructuredSummary(strings.TrimSpace(document), document)
+			if err != nil {
+				return "", newCLIError(exitAPI, "api", fmt.Errorf("building structured summary: %w", err))
+			}
+			data, _ := json.MarshalIndent(structured, "", "  ")
+			return string(data), nil
+		case "json":
+			data, _ := json.MarshalIndent(map[string]interface{}{
+				"model":   c.Target.ID,
+				"type":    summaryType,
+				"summary": output,
+				"skipped": "input too short",
+			}, "", "  ")
+			return string(data), nil
+		}
+		return output, nil
+	}
+
+	// Documentos largos: árbol de resúmenes por sección, capítulo y documento
+	if opts.Hierarchical {
+		document = markPriority(document, opts.Weight)
+		run := &treeRun{client: c, summaryType: summaryType}
+		tree := planSummaryTree(document, opts.Depth)
+		if !c.Deadline.IsZero() {
+			run.budget = &treeBudget{deadline: c.Deadline, metrics: c.Metrics}
+			if tree, run.compact = run.budget.fit(document, opts.Depth); run.compact {
+				slog.Info("Not enough time for the full tree; using fewer, larger sections",
+					"levels", treeHeight(tree), "calls", countSummaryCalls(tree, true), "time_left", time.Until(c.Deadline).Round(time.Second))
+			}
+		}
+		run.progress = newProgressReporter("Summaries", countSummaryCalls(tree, run.compact), opts.Quiet)
+		err := run.summarizeTree(tree)
+		run.progress.Finish()
+		if errors.Is(err, errDeadlineReached) || errors.Is(err, context.DeadlineExceeded) {
+			// Mostrar lo que se alcanzó a resumir en lugar de no mostrar nada
+			slog.Warn("Deadline reached; the summary is partial", "error", err)
+			fillPartial(tree)
+		} else if err != nil {
+			return "", fmt.Errorf("hierarchical summary: %w", err)
+		}
+		return formatSummaryTree(tree, summaryType, outputFormat), nil
+	}
+
+	// Resumir por separado las intervenciones de cada participante
+	if opts.PerSpeaker {
+		turns := parseTranscript(document)
+		if turns == nil {
+			err := newCLIError(exitInput, "input", fmt.Errorf("--per-speaker needs a transcript with speaker labels"))
+			err.Hint = "Expected one turn per line, e.g. \"Alice: I think we should ship on Friday.\""
+			return "", err
+		}
+		summaries, err := c.summarizeSpeakers(turns, summaryType)
+		if err != nil {
+			return "", fmt.Errorf("summarizing speakers: %w", err)
+		}
+		if outputFormat == "json" {
+			data, _ := json.MarshalIndent(map[string]interface{}{
+				"model":    c.Target.ID,
+				"type":     summaryType,
+				"speakers": summaries,
+			}, "", "  ")
+			return string(data), nil
+		}
+		return formatSpeakerSummaries(summaries), nil
+	}
+
+	// La tabla de contenidos resume cada sección por separado
+	if summaryType == "toc" {
+		toc, err := c.tableOfContents(document)
+		if err != nil {
+			return "", fmt.Errorf("generating table of contents: %w", err)
+		}
+		return toc, nil
+	}
+
+	// Los modelos de chat reciben el schema y devuelven el objeto directamente;
+	// las heurísticas de buildStructuredSummary quedan para los de resumen
+	if outputFormat == "structured" && c.Target.Chat {
+		output, err := c.summarizeText(prepareInput(document, summaryType, opts.Weight), structuredType)
+		if err != nil {
+			return "", fmt.Errorf("generating summary: %w", err)
+		}
+		structured, err := parseStructuredSummary(output)
+		if err != nil {
+			return "", newCLIError(exitAPI, "api", fmt.Errorf("building structured summary: %w", err))
+		}
+		data, _ := json.MarshalIndent(structured, "", "  ")
+		return string(data), nil
+	}
+
+	// Generar resumen
+	summary, err := c.summarizeText(prepareInput(document, summaryType, opts.Weight), summaryType)
+	if err != nil {
+		return "", fmt.Errorf("generating summary: %w", err)
+	}
+
+	if outputFormat == "structured" {
+		structured, err := buildStructuredSummary(summary, document)
+		if err != nil {
+			return "", newCLIError(exitAPI, "api", fmt.Errorf("building structured summary: %w", err))
+		}
+		data, _ := json.MarshalIndent(structured, "", "  ")
+		return string(data), nil
+	}
+
+	rendered := renderSummary(summary, document, summaryType)
+	var sources []claimSources
+	if opts.WithSources {
+		sources = findSources(rendered, document, opts.SourceText)
+	}
+	if outputFormat == "json" {
+		result := map[string]interface{}{
+			"model":   c.Target.ID,
+			"type":    summaryType,
+			"summary": rendered,
+		}
+		if opts.WithSources {
+			result["sources"] = sources
+		}
+		data, _ := json.MarshalIndent(result, "", "  ")
+		return string(data), nil
+	}
+	if opts.WithSources {
+		return rendered + "\n\n" + formatSources(sources), nil
+	}
+	return rendered, nil
+}
+
+// sourceSpan es una oración del original que respalda una afirmación del resumen
+type sourceSpan struct {
+	StartLine int     `json:"start_line"`
+	EndLine   int     `json:"end_line"`
+	Text      string  `json:"text"`
+	Score     float64 `json:"score"`
+}
+
+// claimSources asocia una oración o viñeta del resumen con sus fuentes
+type claimSources struct {
+	Claim   string       `json:"claim"`
+	Sources []sourceSpan `json:"sources"`
+}
+
+// minSourceScore es la fracción mínima de palabras de la afirmación que deben
+// aparecer en una oración del original para considerarla fuente
+const minSourceScore = 0.3
+
+// stemSet devuelve las raíces de las palabras con contenido de un texto
+func stemSet(text string) map[string]bool {
+	stems := map[string]bool{}
+	for word := range contentWords(text) {
+		stems[stemWord(word)] = true
+	}
+	return stems
+}
+
+// summaryClaims separa el resumen en afirmaciones: una por viñeta o, en texto
+// corrido, una por oración. Los encabezados ("Pros:", "## Sección") se omiten
+func summaryClaims(summary string) []string {
+	var claims []string
+	for _, line := range strings.Split(summary, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasSuffix(line, ":") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if trimmed := strings.TrimLeft(line, "-*•0123456789.) "); trimmed != line {
+			claims = append(claims, trimmed)
+			continue
+		}
+		claims = append(claims, splitSentences(line)...)
+	}
+	return claims
+}
+
+// findSources busca, para cada afirmación del resumen, la oración del original
+// con mayor solapamiento léxico (y una segunda si está casi empatada). Las
+// afirmaciones sin fuente quedan con la lista vacía: son las que hay que revisar
+func findSources(summary, document, raw string) []claimSources {
+	var candidates []string
+	for _, paragraph := range paragraphs(document) {
+		for _, sentence := range splitSentences(paragraph) {
+			if len(strings.Fields(sentence)) >= 3 {
+				candidates = append(candidates, sentence)
+			}
+		}
+	}
+	candidateStems := make([]map[string]bool, len(candidates))
+	for i, candidate := range candidates {
+		candidateStems[i] = stemSet(candidate)
+	}
+
+	result := []claimSources{}
+	for _, claim := range summaryClaims(summary) {
+		claimStems := stemSet(claim)
+		if len(claimStems) == 0 {
+			continue
+		}
+		type scored struct {
+			index int
+			score float64
+		}
+		var ranked []scored
+		for i := range candidates {
+			if score := lexicalOverlap(claimStems, candidateStems[i]); score >= minSourceScore {
+				ranked = append(ranked, scored{i, score})
+			}
+		}
+		sort.SliceStable(ranked, func(a, b int) bool { return ranked[a].score > ranked[b].score })
+
+		entry := claimSources{Claim: claim, Sources: []sourceSpan{}}
+		for n, r := range ranked {
+			if n == 2 || (n == 1 && r.score < ranked[0].score*0.8) {
+				break
+			}
+			start, end := locateLines(raw, candidates[r.index])
+			entry.Sources = append(entry.Sources, sourceSpan{
+				StartLine: start,
+				EndLine:   end,
+				Text:      candidates[r.index],
+				Score:     math.Round(r.score*100) / 100,
+			})
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+// formatSources muestra las fuentes de cada afirmación con sus números de línea
+func formatSources(sources []claimSources) string {
+	var b strings.Builder
+	b.WriteString("Sources:\n")
+	for i, claim := range sources {
+		fmt.Fprintf(&b, "[%d] %s\n", i+1, claim.Claim)
+		if len(claim.Sources) == 0 {
+			b.WriteString("    (no supporting sentence found - verify this claim)\n")
+		}
+		for _, src := range claim.Sources {
+			lines := fmt.Sprintf("L%d", src.StartLine)
+			if src.EndLine > src.StartLine {
+				lines = fmt.Sprintf("L%d-%d", src.StartLine, src.EndLine)
+			}
+			if src.StartLine == 0 {
+				lines = "L?"
+			}
+			fmt.Fprintf(&b, "    %s: \"%s\" (%.0f%%)\n", lines, src.Text, src.Score*100)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// rawText lee el archivo sin normalizar los espacios, para que los números de
+// línea de --with-sources coincidan con el archivo del usuario
+func rawText(path string) string {
+	path, err := fsPath(path)
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	text, _ := decodeText(data)
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+}
+
+// locateLines busca una oración en el texto original sin importar cómo están
+// distribuidos los espacios y saltos de línea, y devuelve la primera y la
+// última línea (desde 1). Devuelve 0, 0 si no la encuentra
+func locateLines(raw, sentence string) (int, int) {
+	words := strings.Fields(sentence)
+	if raw == "" || len(words) == 0 {
+		return 0, 0
+	}
+	parts := make([]string, len(words))
+	for i, word := range words {
+		parts[i] = regexp.QuoteMeta(word)
+	}
+	re, err := regexp.Compile(strings.Join(parts, `[\s\p{Cf}]+`))
+	if err != nil {
+		return 0, 0
+	}
+	loc := re.FindStringIndex(raw)
+	if loc == nil {
+		return 0, 0
+	}
+	start := strings.Count(raw[:loc[0]], "\n") + 1
+	return start, start + strings.Count(raw[loc[0]:loc[1]], "\n")
+}
+
+// readDocument lee un documento de texto o un archivo de Word (.docx)
+func readDocument(path string) (string, error) {
+	if !strings.EqualFold(filepath.Ext(path), ".docx") {
+		return readFile(path)
+	}
+	text, err := extractDocxText(path)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(text) == "" {
+		return "", fmt.Errorf("file is empty")
+	}
+	return text, nil
+}
+
+// extractDocxText extrae el texto de un .docx (un zip con word/document.xml),
+// con un párrafo por línea
+func extractDocxText(path string) (string, error) {
+	path, err := fsPath(path)
+	if err != nil {
+		return "", err
+	}
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open .docx file: %w", err)
+	}
+	defer archive.Close()
+
+	for _, f := range archive.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", fmt.Errorf("failed to read .docx content: %w", err)
+		}
+		defer rc.Close()
+
+		var b strings.Builder
+		decoder := xml.NewDecoder(rc)
+		inText := false
+		for {
+			tok, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", fmt.Errorf("invalid .docx XML: %w", err)
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				switch t.Name.Local {
+				case "t":
+					inText = true
+				case "tab":
+					b.WriteString("\t")
+				case "br", "cr":
+					b.WriteString("\n")
+				}
+			case xml.EndElement:
+				switch t.Name.Local {
+				case "t":
+					inText = false
+				case "p":
+					b.WriteString("\n\n")
+				}
+			case xml.CharData:
+				if inText {
+					b.Write(t)
+				}
+			}
+		}
+		return strings.TrimSpace(b.String()), nil
+	}
+	return "", fmt.Errorf("not a valid .docx file: word/document.xml not found")
+}
+
+// revisionChange es un cambio entre dos versiones a nivel de párrafo
+type revisionChange struct {
+	Kind string `json:"kind"` // added, removed o modified
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+	// Redline muestra las diferencias palabra a palabra: [-eliminado-] {+agregado+}
+	Redline string `json:"redline,omitempty"`
+}
+
+// diffOp es una operación del diff: '=' igual, '-' eliminado, '+' agregado
+type diffOp struct {
+	Op   byte
+	Text string
+}
+
+// diffSequences calcula el diff entre dos secuencias usando la subsecuencia
+// común más larga (LCS)
+func diffSequences(a, b []string, equal func(x, y string) bool) []diffOp {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if equal(a[i], b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case equal(a[i], b[j]):
+			ops = append(ops, diffOp{'=', b[j]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// normalizeParagraph ignora diferencias de espacios al comparar párrafos
+func normalizeParagraph(p string) string {
+	return strings.Join(strings.Fields(p), " ")
+}
+
+// diffRevisions compara dos versiones párrafo a párrafo. Un bloque de párrafos
+// eliminados seguido de agregados se empareja como modificaciones cuando los
+// párrafos comparten suficientes palabras
+func diffRevisions(oldText, newText string) []revisionChange {
+	equal := func(x, y string) bool { return normalizeParagraph(x) == normalizeParagraph(y) }
+	ops := diffSequences(paragraphs(oldText), paragraphs(newText), equal)
+
+	var changes []revisionChange
+	for i := 0; i < len(ops); {
+		if ops[i].Op == '=' {
+			i++
+			continue
+		}
+		var removed, added []string
+		for ; i < len(ops) && ops[i].Op == '-'; i++ {
+			removed = append(removed, ops[i].Text)
+		}
+		for ; i < len(ops) && ops[i].Op == '+'; i++ {
+			added = append(added, ops[i].Text)
+		}
+		paired := len(removed)
+		if len(added) < paired {
+			paired = len(added)
+		}
+		for k := 0; k < paired; k++ {
+			if lexicalOverlap(contentWords(removed[k]), contentWords(added[k])) < 0.5 {
+				changes = append(changes, revisionChange{Kind: "removed", Old: removed[k]},
+					revisionChange{Kind: "added", New: added[k]})
+				continue
+			}
+			changes = append(changes, revisionChange{Kind: "modified", Old: removed[k], New: added[k],
+				Redline: redline(removed[k], added[k])})
+		}
+		for _, p := range removed[paired:] {
+			changes = append(changes, revisionChange{Kind: "removed", Old: p})
+		}
+		for _, p := range added[paired:] {
+			changes = append(changes, revisionChange{Kind: "added", New: p})
+		}
+	}
+	return changes
+}
+
+// redline marca las diferencias palabra a palabra entre dos párrafos
+func redline(oldText, newText string) string {
+	ops := diffSequences(strings.Fields(oldText), strings.Fields(newText), func(x, y string) bool { return x == y })
+	var parts []string
+	for i := 0; i < len(ops); {
+		op := ops[i].Op
+		var words []string
+		for ; i < len(ops) && ops[i].Op == op; i++ {
+			words = append(words, ops[i].Text)
+		}
+		text := strings.Join(words, " ")
+		switch op {
+		case '-':
+			parts = append(parts, "[-"+text+"-]")
+		case '+':
+			parts = append(parts, "{+"+text+"+}")
+		default:
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// describeChanges redacta los cambios como texto para que el modelo los resuma
+func describeChanges(changes []revisionChange) string {
+	var b strings.Builder
+	for _, c := range changes {
+		switch c.Kind {
+		case "modified":
+			fmt.Fprintf(&b, "The text \"%s\" was changed to \"%s\".\n", c.Old, c.New)
+		case "removed":
+			fmt.Fprintf(&b, "The text \"%s\" was removed.\n", c.Old)
+		case "added":
+			fmt.Fprintf(&b, "New text was added: \"%s\".\n", c.New)
+		}
+	}
+	return b.String()
+}
+
+// summarizeRevisions compara dos versiones de un documento y resume qué cambió
+// de forma sustancial, junto con el detalle de cada cambio (redline)
+func (c *APIClient) summarizeRevisions(oldPath, newPath, outputFormat string) (string, error) {
+	oldText, err := readDocument(oldPath)
+	if err != nil {
+		return "", newCLIError(exitInput, "input", fmt.Errorf("reading file '%s': %w", oldPath, err))
+	}
+	newText, err := readDocument(newPath)
+	if err != nil {
+		return "", newCLIError(exitInput, "input", fmt.Errorf("reading file '%s': %w", newPath, err))
+	}
+
+	changes := diffRevisions(oldText, newText)
+	counts := map[string]int{}
+	for _, change := range changes {
+		counts[change.Kind]++
+	}
+
+	summary := "No textual changes between the two versions."
+	if len(changes) > 0 {
+		description := describeChanges(changes)
+		if len(description) > maxInputLength {
+			slog.Warn("Change description truncated", "original_chars", len(description), "max_chars", maxInputLength)
+			description = description[:maxInputLength]
+		}
+		summary, err = c.summarizeText(description, "revisions")
+		if err != nil {
+			return "", fmt.Errorf("summarizing changes: %w", err)
+		}
+	}
+
+	if outputFormat == "json" || outputFormat == "structured" {
+		if changes == nil {
+			changes = []revisionChange{}
+		}
+		data, _ := json.MarshalIndent(map[string]interface{}{
+			"old":      oldPath,
+			"new":      newPath,
+			"added":    counts["added"],
+			"removed":  counts["removed"],
+			"modified": counts["modified"],
+			"summary":  summary,
+			"changes":  changes,
+		}, "", "  ")
+		return string(data), nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Changes: %d modified, %d added, %d removed\n\n", counts["modified"], counts["added"], counts["removed"])
+	fmt.Fprintf(&b, "Summary of changes:\n%s\n", summary)
+	if len(changes) > 0 {
+		b.WriteString("\nDetails:\n")
+		for _, change := range changes {
+			switch change.Kind {
+			case "modified":
+				fmt.Fprintf(&b, "~ %s\n", change.Redline)
+			case "removed":
+				fmt.Fprintf(&b, "- %s\n", change.Old)
+			case "added":
+				fmt.Fprintf(&b, "+ %s\n", change.New)
+			}
+		}
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// comparisonResult es el resultado de un modelo en --compare
+type comparisonResult struct {
+	Model     string `json:"model"`
+	ModelID   string `json:"model_id"`
+	Summary   string `json:"summary,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// compareModels resume la misma entrada con cada cliente de forma concurrente.
+// Los resultados conservan el orden en que se indicaron los modelos
+func compareModels(clients []*APIClient, content, document, summaryType string, warmup bool, warmupTimeout time.Duration) []comparisonResult {
+	results := make([]comparisonResult, len(clients))
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c *APIClient) {
+			defer wg.Done()
+			result := comparisonResult{Model: c.Target.Name, ModelID: c.Target.ID}
+			if warmup {
+				if err := c.warmUp(warmupTimeout); err != nil {
+					result.Error = err.Error()
+					results[i] = result
+					return
+				}
+			}
+			start := time.Now()
+			summary, err := c.summarizeText(content, summaryType)
+			result.LatencyMS = time.Since(start).Milliseconds()
+			if err != nil {
+				result.Error = err.Error()
+				slog.Warn("Model failed", "model", c.Target.Name, "error", err)
+			} else {
+				result.Summary = renderSummary(summary, document, summaryType)
+			}
+			results[i] = result
+		}(i, c)
+	}
+	wg.Wait()
+	return results
+}
+
+// terminalWidth devuelve el ancho de la terminal según $COLUMNS (120 por defecto)
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n >= 40 {
+		return n
+	}
+	return 120
+}
+
+// wrapText ajusta un texto a un ancho de columna, respetando los saltos de línea
+func wrapText(text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			for utf8.RuneCountInString(word) > width {
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				runes := []rune(word)
+				lines = append(lines, string(runes[:width]))
+				word = string(runes[width:])
+			}
+			switch {
+			case line == "":
+				line = word
+			case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// formatSideBySide muestra los resultados de --compare en columnas
+func formatSideBySide(results []comparisonResult, width int) string {
+	const gap = " | "
+	colWidth := (width - len(gap)*(len(results)-1)) / len(results)
+	if colWidth < 20 {
+		colWidth = 20
+	}
+
+	columns := make([][]string, len(results))
+	maxLines := 0
+	for i, r := range results {
+		header := fmt.Sprintf("%s (%dms)", r.Model, r.LatencyMS)
+		body := r.Summary
+		if r.Error != "" {
+			body = "ERROR: " + r.Error
+		}
+		columns[i] = append(wrapText(header, colWidth), strings.Repeat("-", colWidth))
+		columns[i] = append(columns[i], wrapText(body, colWidth)...)
+		if len(columns[i]) > maxLines {
+			maxLines = len(columns[i])
+		}
+	}
+
+	var b strings.Builder
+	for line := 0; line < maxLines; line++ {
+		cells := make([]string, len(columns))
+		for i, col := range columns {
+			cell := ""
+			if line < len(col) {
+				cell = col[line]
+			}
+			cells[i] = cell + strings.Repeat(" ", colWidth-utf8.RuneCountInString(cell))
+		}
+		b.WriteString(strings.TrimRight(strings.Join(cells, gap), " ") + "\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// renderSummary da formato final al resumen; algunos tipos necesitan además
+// el documento original para estructurar la salida
+func renderSummary(summary, document, summaryType string) string {
+	switch summaryType {
+	case "outline":
+		return formatOutline(summary, document)
+	case "proscons":
+		return formatProsCons(summary, document)
+	case "feedback":
+		return formatFeedback(summary, document)
+	default:
+		return formatOutput(summary, summaryType)
+	}
+}
+
+// outputPolicy decide qué hacer cuando un archivo de salida ya existe
+type outputPolicy struct {
+	NoClobber bool
+	Backup    bool
+}
+
+// check falla si el archivo existe y no se permite reemplazarlo
+func (p outputPolicy) check(path string) error {
+	if !p.NoClobber {
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		return clobberError(path)
+	}
+	return nil
+}
+
+func clobberError(path string) error {
+	return newCLIError(exitUsage, "usage", fmt.Errorf("output file '%s' already exists and --no-clobber is set", path))
+}
+
+// writeFileAtomic escribe un archivo completo en un temporal del mismo
+// directorio y lo renombra al destino, así una interrupción nunca deja una
+// salida truncada
+func writeFileAtomic(path string, data []byte, policy outputPolicy) error {
+	if err := policy.check(path); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing '%s': %w", path, err)
+	}
+	return commitFile(tmp.Name(), path, policy)
+}
+
+// commitFile reemplaza el destino por el archivo temporal con un rename, que es
+// atómico dentro del mismo sistema de archivos. Con --backup, la versión
+// anterior se conserva como <archivo>.bak: es un enlace (o una copia) y no un
+// rename, así el destino nunca desaparece aunque falle el reemplazo. Con
+// --no-clobber se publica con Link, que falla si el archivo apareció después
+// de la comprobación, en vez de pisarlo
+func commitFile(tmpPath, path string, policy outputPolicy) error {
+	if err := policy.check(path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if policy.NoClobber {
+		err := os.Link(tmpPath, path)
+		if err == nil || errors.Is(err, os.ErrExist) {
+			os.Remove(tmpPath)
+		}
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, os.ErrExist):
+			return clobberError(path)
+		}
+		// Sin enlaces en este sistema de archivos solo queda la comprobación previa
+		slog.Debug("Hard links not supported, replacing with rename", "file", path, "error", err)
+	}
+	if policy.Backup {
+		if err := backupFile(path); err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("backing up '%s': %w", path, err)
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("replacing '%s': %w", path, err)
+	}
+	return nil
+}
+
+// backupFile guarda una copia de path en <path>.bak, reemplazando la anterior.
+// No hace nada si path todavía no existe
+func backupFile(path string) error {
+	backup := path + ".bak"
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(path, backup); err != nil {
+		// Sin enlaces (p. ej. FAT o algunos discos de red) se copia el contenido
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := os.OpenFile(backup, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dst, src)
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(backup)
+			return err
+		}
+	}
+	slog.Debug("Previous output backed up", "file", backup)
+	return nil
+}
+
+// tableOptions configura el resumen fila por fila de un CSV o JSONL
+type tableOptions struct {
+	Input         string
+	Output        string
+	Format        string // csv o jsonl
+	Column        string
+	SummaryColumn string
+	Resume        bool
+	Policy        outputPolicy
+	LockTimeout   time.Duration
+}
+
+// tableRecord es una fila de la tabla: el texto a resumir y cómo escribir el
+// resultado en el archivo de salida
+type tableRecord struct {
+	Text  string
+	write func(summary string) error
+}
+
+// runTableMode resume la columna indicada de cada fila y escribe cada fila con
+// su resumen apenas termina en <salida>.partial, para que --resume pueda
+// continuar desde la última fila completada si la ejecución se interrumpe. Al
+// terminar, el archivo parcial reemplaza a la salida con un rename atómico
+func runTableMode(opts tableOptions, summarize func(text string) (string, error), dryRun, quiet bool) error {
+	var err error
+	if opts.Input, err = fsPath(opts.Input); err != nil {
+		return newCLIError(exitInput, "input", err)
+	}
+	if opts.Output, err = fsPath(opts.Output); err != nil {
+		return newCLIError(exitUsage, "usage", fmt.Errorf("invalid --output: %w", err))
+	}
+	data, err := os.ReadFile(opts.Input)
+	if err != nil {
+		return newCLIError(exitInput, "input", fmt.Errorf("reading file '%s': %w", opts.Input, err))
+	}
+	text, encoding := decodeText(data)
+	if encoding != "utf-8" {
+		slog.Info("Input converted to UTF-8", "file", opts.Input, "encoding", encoding)
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	partial := opts.Output + ".partial"
+	if !dryRun {
+		// Otra ejecución sobre la misma salida mezclaría filas en el archivo parcial
+		lock, err := acquireLock(partial, opts.LockTimeout)
+		if err != nil {
+			return err
+		}
+		defer lock.release()
+	}
+	done := 0
+	if opts.Resume {
+		if done, err = countCompletedRows(partial, opts.Format); err != nil {
+			return newCLIError(exitInput, "input", fmt.Errorf("resuming from '%s': %w", partial, err))
+		}
+	} else if _, err := os.Stat(partial); err == nil && !dryRun {
+		err := newCLIError(exitUsage, "usage", fmt.Errorf("an interrupted run left '%s'", partial))
+		err.Hint = "Use --resume to continue it, or delete the file to start over"
+		return err
+	}
+	if err := opts.Policy.check(opts.Output); err != nil {
+		return err
+	}
+
+	if dryRun {
+		rows, err := countTableRows(text, opts)
+		if err != nil {
+			return newCLIError(exitInput, "input", err)
+		}
+		fmt.Println("Dry run: no requests will be sent")
+		fmt.Printf("Input file:        %s (%s, column %q)\n", opts.Input, opts.Format, opts.Column)
+		fmt.Printf("Output file:       %s (column %q)\n", opts.Output, opts.SummaryColumn)
+		fmt.Printf("Rows:              %d (%d already done)\n", rows, done)
+		fmt.Printf("API calls:         up to %d (one per non-empty row)\n", rows-done)
+		return nil
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if opts.Resume {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	out, err := os.OpenFile(partial, flags, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening output file: %w", err)
+	}
+	defer out.Close()
+
+	// Al reanudar, el encabezado del CSV ya está en la salida si no está vacía
+	writeHeader := true
+	if info, err := out.Stat(); err == nil && opts.Resume && info.Size() > 0 {
+		writeHeader = false
+	}
+	records, err := tableRecords(text, opts, out, writeHeader)
+	if err != nil {
+		return newCLIError(exitInput, "input", err)
+	}
+	if done > len(records) {
+		return newCLIError(exitInput, "input", fmt.Errorf("output file has %d rows but the input only %d", done, len(records)))
+	}
+	if done > 0 {
+		slog.Info("Resuming", "completed_rows", done, "total_rows", len(records))
+	}
+
+	progress := newProgressReporter("Rows", len(records), quiet)
+	progress.Skip(done)
+	for i := done; i < len(records); i++ {
+		summary := ""
+		if strings.TrimSpace(records[i].Text) != "" {
+			summary, err = summarize(strings.TrimSpace(normalizeText(records[i].Text)))
+			if err != nil {
+				progress.Finish()
+				classified := classifyError(err)
+				cliErr := newCLIError(classified.Code, classified.Category, fmt.Errorf("row %d: %w", i+1, err))
+				cliErr.Hint = "Completed rows were saved; run again with --resume to continue"
+				return cliErr
+			}
+		}
+		if err := records[i].write(summary); err != nil {
+			progress.Finish()
+			return fmt.Errorf("writing row %d: %w", i+1, err)
+		}
+		progress.Advance(1)
+	}
+	progress.Finish()
+
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("writing output file: %w", err)
+	}
+	out.Close()
+	if err := commitFile(partial, opts.Output, opts.Policy); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Summarized %d rows into %s\n", len(records)-done, opts.Output)
+	return nil
+}
+
+// tableRecords lee las filas de la entrada. Si writeHeader es verdadero escribe
+// el encabezado del CSV de salida (en JSONL no hay encabezado)
+func tableRecords(text string, opts tableOptions, out io.Writer, writeHeader bool) ([]tableRecord, error) {
+	if opts.Format == "jsonl" {
+		return jsonlRecords(text, opts, out)
+	}
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("CSV file is empty")
+	}
+	header := rows[0]
+	textIdx, summaryIdx := -1, -1
+	for i, name := range header {
+		switch strings.TrimSpace(name) {
+		case opts.Column:
+			textIdx = i
+		case opts.SummaryColumn:
+			summaryIdx = i
+		}
+	}
+	if textIdx < 0 {
+		return nil, fmt.Errorf("column %q not found in CSV header (%s)", opts.Column, strings.Join(header, ", "))
+	}
+	if summaryIdx < 0 {
+		header = append(header, opts.SummaryColumn)
+		summaryIdx = len(header) - 1
+	}
+
+	writer := csv.NewWriter(out)
+	if writeHeader {
+		writer.Write(header)
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return nil, err
+		}
+	}
+	records := make([]tableRecord, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		row := row
+		value := ""
+		if textIdx < len(row) {
+			value = row[textIdx]
+		}
+		records = append(records, tableRecord{Text: value, write: func(summary string) error {
+			for len(row) <= summaryIdx {
+				row = append(row, "")
+			}
+			row[summaryIdx] = summary
+			writer.Write(row)
+			writer.Flush()
+			return writer.Error()
+		}})
+	}
+	return records, nil
+}
+
+// jsonlRecords lee un objeto JSON por línea. El resumen se agrega al final del
+// objeto para conservar el orden original de los campos
+func jsonlRecords(text string, opts tableOptions, out io.Writer) ([]tableRecord, error) {
+	var records []tableRecord
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			return nil, fmt.Errorf("line %d: invalid JSON object: %w", n+1, err)
+		}
+		value := ""
+		if raw, ok := fields[opts.Column]; ok {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				value = string(raw)
+			}
+		}
+		records = append(records, tableRecord{Text: value, write: func(summary string) error {
+			encoded, _ := json.Marshal(summary)
+			var result []byte
+			if start, end, exists := jsonValueSpan(line, opts.SummaryColumn); exists {
+				// Se reemplaza solo el valor, en su lugar, sin reordenar el resto
+				result = []byte(line[:start] + string(encoded) + line[end:])
+			} else {
+				key, _ := json.Marshal(opts.SummaryColumn)
+				body := strings.TrimSuffix(strings.TrimSpace(line), "}")
+				separator := ","
+				if strings.TrimSpace(strings.TrimPrefix(body, "{")) == "" {
+					separator = ""
+				}
+				result = []byte(body + separator + string(key) + ":" + string(encoded) + "}")
+			}
+			_, err := out.Write(append(result, '\n'))
+			return err
+		}})
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("JSONL file is empty")
+	}
+	return records, nil
+}
+
+// jsonValueSpan ubica el valor de una clave del primer nivel de un objeto JSON:
+// devuelve sus posiciones de inicio y fin en line. Con claves repetidas vale la
+// última, como al decodificar
+func jsonValueSpan(line, key string) (int, int, bool) {
+	dec := json.NewDecoder(strings.NewReader(line))
+	if _, err := dec.Token(); err != nil {
+		return 0, 0, false
+	}
+	start, end, found := 0, 0, false
+	for dec.More() {
+		name, err := dec.Token()
+		if err != nil {
+			return 0, 0, false
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return 0, 0, false
+		}
+		if name == key {
+			end = int(dec.InputOffset())
+			start, found = end-len(value), true
+		}
+	}
+	return start, end, found
+}
+
+// countTableRows cuenta las filas de datos de la entrada
+func countTableRows(text string, opts tableOptions) (int, error) {
+	records, err := tableRecords(text, opts, io.Discard, false)
+	return len(records), err
+}
+
+// countCompletedRows cuenta las filas ya escritas en el archivo parcial. Una
+// última línea incompleta de JSONL (ejecución interrumpida) se descarta
+func countCompletedRows(path, format string) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return 0, nil
+	}
+
+	if format == "jsonl" {
+		complete := bytes.LastIndexByte(data, '\n') + 1
+		if complete < len(data) {
+			slog.Warn("Discarding incomplete last line of output", "file", path)
+			if err := os.Truncate(path, int64(complete)); err != nil {
+				return 0, err
+			}
+		}
+		count := 0
+		for _, line := range bytes.Split(data[:complete], []byte("\n")) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				count++
+			}
+		}
+		return count, nil
+	}
+
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("invalid CSV output: %w", err)
+	}
+	return len(rows) - 1, nil
+}
+
+// progressReporter muestra el avance de una tarea larga en stderr: una barra
+// que se actualiza en la misma línea en una terminal, o un log cada 10% si
+// stderr se redirige a un archivo
+type progressReporter struct {
+	Label       string
+	Total       int
+	done        int
+	failed      int
+	started     time.Time
+	interactive bool
+	quiet       bool
+	lastLogged  int
+	// skipped son las unidades ya hechas antes de esta ejecución (--resume):
+	// cuentan para el avance pero no para el ritmo ni la ETA
+	skipped int
+}
+
+func newProgressReporter(label string, total int, quiet bool) *progressReporter {
+	interactive := false
+	if stat, err := os.Stderr.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		interactive = true
+	}
+	return &progressReporter{Label: label, Total: total, started: time.Now(), interactive: interactive, quiet: quiet}
+}
+
+// Advance suma n unidades completadas y actualiza la salida
+func (p *progressReporter) Advance(n int) {
+	p.done += n
+	p.render()
+}
+
+// Skip suma n unidades completadas en una ejecución anterior
+func (p *progressReporter) Skip(n int) {
+	p.skipped += n
+	p.Advance(n)
+}
+
+// Fail cuenta una unidad fallida y actualiza la salida
+func (p *progressReporter) Fail() {
+	p.failed++
+	p.render()
+}
+
+func (p *progressReporter) render() {
+	if p.quiet || p.Total == 0 {
+		return
+	}
+	processed := p.done + p.failed
+	remaining := p.Total - processed
+	percent := 100 * processed / p.Total
+	if !p.interactive {
+		if percent/10 > p.lastLogged/10 || processed == p.Total {
+			slog.Info("Progress", "label", p.Label, "done", p.done, "failed", p.failed, "remaining", remaining, "percent", percent)
+			p.lastLogged = percent
+		}
+		return
+	}
+	const width = 30
+	filled := width * processed / p.Total
+	eta := ""
+	if current := processed - p.skipped; current > 0 && remaining > 0 {
+		left := time.Since(p.started) / time.Duration(current) * time.Duration(remaining)
+		eta = " ETA " + left.Round(time.Second).String()
+	}
+	fmt.Fprintf(os.Stderr, "\r%s [%s%s] %d%% done %d, failed %d, remaining %d%s   ", p.Label,
+		strings.Repeat("#", filled), strings.Repeat("-", width-filled), percent, p.done, p.failed, remaining, eta)
+}
+
+// Finish termina la línea de progreso en la terminal
+func (p *progressReporter) Finish() {
+	if p.interactive && !p.quiet && p.Total > 0 {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// batchDashboard muestra el avance de un lote en un bloque de stderr que se
+// redibuja en el lugar: archivos en cola, en curso, terminados y fallidos,
+// ritmo, ETA y estado de la cuota. Mientras está activo los logs pasan por él:
+// los avisos quedan escritos encima del bloque y los mensajes informativos
+// (reintentos, esperas por cuota) se muestran en su última línea. Sin terminal
+// registra el avance cada 10%, como progressReporter. Es seguro para uso
+// concurrente
+type batchDashboard struct {
+	mu          sync.Mutex
+	total       int
+	skipped     int
+	done        int
+	failed      int
+	running     map[string]time.Time
+	started     time.Time
+	metrics     *RunMetrics
+	last        string
+	lines       int
+	width       int
+	interactive bool
+	quiet       bool
+	lastLogged  int
+	previousLog io.Writer
+	stop        chan struct{}
+	stopped     sync.WaitGroup
+}
+
+func newBatchDashboard(total, skipped int, metrics *RunMetrics, quiet bool) *batchDashboard {
+	d := &batchDashboard{total: total, skipped: skipped, running: map[string]time.Time{},
+		started: time.Now(), metrics: metrics, quiet: quiet}
+	if stat, err := os.Stderr.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		d.interactive = true
+	}
+	if d.quiet || d.total == 0 {
+		return d
+	}
+	if !d.interactive {
+		d.lastLogged = 100 * skipped / total
+		return d
+	}
+	d.width, _ = terminalSize()
+	d.previousLog = logOutput.redirect(d)
+	// El ritmo, la ETA y la cuota cambian aunque ningún archivo termine
+	d.stop = make(chan struct{})
+	d.stopped.Add(1)
+	go func() {
+		defer d.stopped.Done()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.mu.Lock()
+				d.draw()
+				d.mu.Unlock()
+			}
+		}
+	}()
+	d.mu.Lock()
+	d.draw()
+	d.mu.Unlock()
+	return d
+}
+
+// Start marca un archivo como en curso
+func (d *batchDashboard) Start(file string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running[file] = time.Now()
+	d.update()
+}
+
+// Finish marca un archivo en curso como terminado o fallido
+func (d *batchDashboard) Finish(file string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.running, file)
+	if err != nil {
+		d.failed++
+	} else {
+		d.done++
+	}
+	d.update()
+}
+
+// Close deja el último estado del tablero en la terminal y devuelve los logs a
+// stderr
+func (d *batchDashboard) Close() {
+	if d.stop == nil {
+		return
+	}
+	close(d.stop)
+	d.stopped.Wait()
+	d.stop = nil
+	d.mu.Lock()
+	d.draw()
+	d.lines = 0
+	d.mu.Unlock()
+	logOutput.redirect(d.previousLog)
+}
+
+// Write recibe los logs mientras el tablero está en la terminal
+func (d *batchDashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, message, ok := strings.Cut(strings.TrimSpace(string(p)), "level=INFO "); ok {
+		d.last = message
+	} else {
+		d.clear()
+		os.Stderr.Write(p)
+	}
+	d.draw()
+	return len(p), nil
+}
+
+// update redibuja el tablero o, sin terminal, registra el avance cada 10%.
+// Se llama con el mutex tomado
+func (d *batchDashboard) update() {
+	if d.quiet || d.total == 0 {
+		return
+	}
+	if d.interactive {
+		d.draw()
+		return
+	}
+	processed := d.skipped + d.done + d.failed
+	percent := 100 * processed / d.total
+	if percent/10 > d.lastLogged/10 || processed == d.total {
+		rate, eta := d.pace(time.Now())
+		slog.Info("Progress", "label", "Files", "done", d.done, "failed", d.failed, "running", len(d.running),
+			"queued", d.queued(), "percent", percent, "rate", rate, "eta", eta)
+		d.lastLogged = percent
+	}
+}
+
+func (d *batchDashboard) queued() int {
+	return d.total - d.skipped - d.done - d.failed - len(d.running)
+}
+
+// pace devuelve el ritmo de los archivos procesados en esta ejecución y el
+// tiempo estimado para los que faltan
+func (d *batchDashboard) pace(now time.Time) (string, string) {
+	processed := d.done + d.failed
+	elapsed := now.Sub(d.started)
+	if processed == 0 || elapsed <= 0 {
+		return "-", "-"
+	}
+	perMinute := float64(processed) / elapsed.Minutes()
+	rate := fmt.Sprintf("%.1f files/min", perMinute)
+	if perMinute >= 60 {
+		rate = fmt.Sprintf("%.1f files/s", perMinute/60)
+	}
+	left := d.total - d.skipped - processed
+	eta := (elapsed / time.Duration(processed) * time.Duration(left)).Round(time.Second)
+	return rate, eta.String()
+}
+
+// view arma las líneas del tablero
+func (d *batchDashboard) view(now time.Time) []string {
+	const width = 30
+	processed := d.skipped + d.done + d.failed
+	percent := 100 * processed / d.total
+	filled := width * processed / d.total
+	lines := []string{fmt.Sprintf("Files  [%s%s] %3d%%  %d/%d  done %d, failed %d, running %d, queued %d, skipped %d",
+		strings.Repeat("#", filled), strings.Repeat("-", width-filled), percent, processed, d.total,
+		d.done, d.failed, len(d.running), d.queued(), d.skipped)}
+
+	rate, eta := d.pace(now)
+	requests, retries := d.metrics.counts()
+	lines = append(lines, fmt.Sprintf("Rate   %s  ETA %s  elapsed %v  requests %d (%d retries)",
+		rate, eta, now.Sub(d.started).Round(time.Second), requests, retries))
+
+	quota := "no rate-limit headers received"
+	if q, ok := d.metrics.quota(); ok {
+		quota = q.describe(now)
+		if q.delay(now) > 0 {
+			quota += ", pacing files to stay within it"
+		}
+	}
+	lines = append(lines, "Quota  "+quota)
+
+	files := make([]string, 0, len(d.running))
+	for file, started := range d.running {
+		files = append(files, fmt.Sprintf("%s (%v)", file, now.Sub(started).Round(time.Second)))
+	}
+	sort.Strings(files)
+	if len(files) > 0 {
+		lines = append(lines, "Now    "+strings.Join(files, ", "))
+	}
+	if d.last != "" {
+		lines = append(lines, "Last   "+d.last)
+	}
+	return lines
+}
+
+// draw reemplaza el bloque anterior por el estado actual. Se llama con el
+// mutex tomado
+func (d *batchDashboard) draw() {
+	if d.quiet || d.total == 0 || !d.interactive {
+		return
+	}
+	var b strings.Builder
+	if d.lines > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", d.lines)
+	}
+	lines := d.view(time.Now())
+	for _, line := range lines {
+		b.WriteString("\r\x1b[2K" + fitWidth(line, d.width-1) + "\n")
+	}
+	// Si el bloque se achicó, borrar las líneas que sobran
+	b.WriteString("\x1b[J")
+	d.lines = len(lines)
+	os.Stderr.WriteString(b.String())
+}
+
+// clear borra el bloque para escribir encima de él. Se llama con el mutex tomado
+func (d *batchDashboard) clear() {
+	if d.lines > 0 {
+		fmt.Fprintf(os.Stderr, "\x1b[%dA\r\x1b[J", d.lines)
+		d.lines = 0
+	}
+}
+
+// Códigos de error de JSON-RPC 2.0 (https://www.jsonrpc.org/specification);
+// rpcServerError cubre los fallos al resumir, con el detalle en "data"
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+// rpcRequest es una solicitud de --protocol jsonrpc, una por línea. Sin id es
+// una notificación y no se responde
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// rpcSummarizeParams son los parámetros de "summarize": el texto, o la ruta de
+// un archivo, y opcionalmente el tipo, el formato y el modelo (por defecto los
+// de los flags)
+type rpcSummarizeParams struct {
+	Text   string `json:"text"`
+	File   string `json:"file"`
+	Type   string `json:"type"`
+	Format string `json:"format"`
+	Model  string `json:"model"`
+}
+
+// rpcSummary es el resultado de "summarize"
+type rpcSummary struct {
+	Summary string `json:"summary"`
+	Type    string `json:"type"`
+	Format  string `json:"format"`
+	Model   string `json:"model"`
+	Chars   int    `json:"chars"`
+}
+
+// rpcHandler atiende un método; los errores que no son *rpcError se informan
+// como rpcServerError con la categoría y el código de salida equivalentes
+type rpcHandler func(params json.RawMessage) (interface{}, error)
+
+// serveJSONRPC lee solicitudes JSON-RPC 2.0 (una por línea) de r y escribe cada
+// respuesta en una línea de w, hasta el fin de la entrada o el método
+// "shutdown". Las solicitudes se atienden en orden, de a una
+func serveJSONRPC(r io.Reader, w io.Writer, handlers map[string]rpcHandler) error {
+	reader := bufio.NewReader(r)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			response, stop := handleRPCLine(line, handlers)
+			if response != nil {
+				if err := encoder.Encode(response); err != nil {
+					return fmt.Errorf("writing response: %w", err)
+				}
+			}
+			if stop {
+				return nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading requests: %w", err)
+		}
+	}
+}
+
+// handleRPCLine atiende una línea. Devuelve la respuesta (nil para las
+// notificaciones) y si hay que terminar
+func handleRPCLine(line []byte, handlers map[string]rpcHandler) (*rpcResponse, bool) {
+	null := json.RawMessage("null")
+	var req rpcRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		return &rpcResponse{JSONRPC: "2.0", ID: null, Error: &rpcError{Code: rpcParseError, Message: "parse error: " + err.Error()}}, false
+	}
+	id := req.ID
+	if len(id) == 0 {
+		id = null
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return &rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: rpcInvalidRequest, Message: "invalid request: needs \"jsonrpc\": \"2.0\" and a method"}}, false
+	}
+
+	stop := req.Method == "shutdown"
+	var result interface{}
+	var err error
+	if handler, ok := handlers[req.Method]; ok {
+		result, err = handler(req.Params)
+	} else if !stop {
+		err = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("method not found: %s", req.Method)}
+	}
+	slog.Debug("JSON-RPC request", "method", req.Method, "id", string(id), "error", err)
+	if len(req.ID) == 0 {
+		return nil, stop
+	}
+
+	response := &rpcResponse{JSONRPC: "2.0", ID: id, Result: result}
+	if err != nil {
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) {
+			cliErr := classifyError(err)
+			data := map[string]interface{}{"category": cliErr.Category, "exit_code": cliErr.Code}
+			var apiErr *APIError
+			if errors.As(err, &apiErr) {
+				data["status_code"] = apiErr.StatusCode
+			}
+			if cliErr.Hint != "" {
+				data["hint"] = cliErr.Hint
+			}
+			rpcErr = &rpcError{Code: rpcServerError, Message: cliErr.Error(), Data: data}
+		}
+		response.Result, response.Error = nil, rpcErr
+	} else if result == nil {
+		response.Result = null
+	}
+	return response, stop
+}
+
+// decodeRPCParams decodifica los parámetros de un método; los campos
+// desconocidos son un error para detectar errores de tipeo del cliente
+func decodeRPCParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(params))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: "invalid params: " + err.Error()}
+	}
+	return nil
+}
+
+// tuiStatus es el estado de un archivo en --tui
+type tuiStatus int
+
+const (
+	tuiPending tuiStatus = iota
+	tuiQueued
+	tuiRunning
+	tuiDone
+	tuiFailed
+)
+
+// tuiItem es un archivo de la lista de --tui con su último resumen
+type tuiItem struct {
+	Path    string
+	Type    string
+	Model   string
+	Status  tuiStatus
+	Output  string
+	Err     error
+	Started time.Time
+	Elapsed time.Duration
+}
+
+// tuiOptions configura --tui: los tipos y modelos entre los que se alterna con
+// las teclas t y m, y los valores iniciales
+type tuiOptions struct {
+	Types  []string
+	Models []string
+	Type   string
+	Model  string
+}
+
+// tuiSpinner son los cuadros de la animación de un archivo en curso
+var tuiSpinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// tuiHelp resume las teclas en la línea inferior
+const tuiHelp = "↑/↓ select  r re-run  t type  m model  q quit"
+
+// tuiState es el estado compartido entre la interfaz y el worker
+type tuiState struct {
+	mu       sync.Mutex
+	items    []*tuiItem
+	selected int
+	opts     tuiOptions
+	// lastLog es el último aviso registrado, mostrado en la línea inferior
+	lastLog string
+	frame   int
+	// width y height se vuelven a consultar una vez por segundo
+	width, height int
+}
+
+// Write recibe los logs mientras la terminal está ocupada por la interfaz
+func (s *tuiState) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastLog = strings.TrimSpace(string(p))
+	return len(p), nil
+}
+
+// runTUI muestra la interfaz de --tui: la lista de archivos a la izquierda con
+// el progreso de cada uno y el resumen del seleccionado a la derecha. Todos los
+// archivos se resumen al empezar, uno a la vez; r vuelve a resumir el
+// seleccionado y t/m lo vuelven a resumir con el siguiente tipo o modelo
+func runTUI(inputs []string, opts tuiOptions, summarize func(path, summaryType, model string) (string, error)) error {
+	restore, err := enterRawMode()
+	if err != nil {
+		return newCLIError(exitUsage, "usage", fmt.Errorf("--tui needs an interactive terminal: %w", err))
+	}
+	state := &tuiState{opts: opts}
+	for _, path := range inputs {
+		state.items = append(state.items, &tuiItem{Path: path, Type: opts.Type, Model: opts.Model, Status: tuiQueued})
+	}
+
+	// Los logs irían a la misma terminal: se muestra solo el último aviso
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(state, &slog.HandlerOptions{
+		Level: slog.LevelWarn,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})))
+	fmt.Print("\x1b[?1049h\x1b[?25l\x1b[2J")
+	var once sync.Once
+	cleanup := func() {
+		once.Do(func() {
+			fmt.Print("\x1b[?25h\x1b[?1049l")
+			restore()
+			slog.SetDefault(previous)
+		})
+	}
+	defer cleanup()
+	addExitHook(cleanup)
+
+	// Ctrl+C también debe devolver la terminal a su estado original
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	// Un solo worker resume los archivos en orden de llegada
+	jobs := make(chan int, len(inputs)*4)
+	for i := range inputs {
+		jobs <- i
+	}
+	go func() {
+		for i := range jobs {
+			state.mu.Lock()
+			item := state.items[i]
+			item.Status, item.Started = tuiRunning, time.Now()
+			path, summaryType, model := item.Path, item.Type, item.Model
+			state.mu.Unlock()
+
+			output, err := summarize(path, summaryType, model)
+
+			state.mu.Lock()
+			item.Elapsed = time.Since(item.Started)
+			item.Output, item.Err, item.Status = output, err, tuiDone
+			if err != nil {
+				item.Status = tuiFailed
+			}
+			state.mu.Unlock()
+		}
+	}()
+
+	keys := make(chan string)
+	go readKeys(os.Stdin, keys)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	state.draw(os.Stdout)
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-ticker.C:
+			state.mu.Lock()
+			state.frame++
+			state.mu.Unlock()
+		case key, ok := <-keys:
+			if !ok || state.handleKey(key, jobs) {
+				return nil
+			}
+		}
+		state.draw(os.Stdout)
+	}
+}
+
+// handleKey aplica una tecla; devuelve true para salir
+func (s *tuiState) handleKey(key string, jobs chan<- int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := s.items[s.selected]
+	switch key {
+	case "q", "esc", "ctrl-c":
+		return true
+	case "up", "k":
+		s.selected = max(s.selected-1, 0)
+	case "down", "j":
+		s.selected = min(s.selected+1, len(s.items)-1)
+	case "t":
+		item.Type = nextChoice(s.opts.Types, item.Type)
+		s.queue(jobs)
+	case "m":
+		item.Model = nextChoice(s.opts.Models, item.Model)
+		s.queue(jobs)
+	case "r", "enter":
+		s.queue(jobs)
+	}
+	return false
+}
+
+// queue encola el archivo seleccionado si no está ya pendiente o en curso
+func (s *tuiState) queue(jobs chan<- int) {
+	item := s.items[s.selected]
+	if item.Status == tuiQueued || item.Status == tuiRunning {
+		return
+	}
+	select {
+	case jobs <- s.selected:
+		item.Status = tuiQueued
+	default:
+		s.lastLog = "too many pending runs; wait for the current ones to finish"
+	}
+}
+
+// nextChoice devuelve el valor siguiente a current en una lista circular
+func nextChoice(choices []string, current string) string {
+	for i, choice := range choices {
+		if choice == current {
+			return choices[(i+1)%len(choices)]
+		}
+	}
+	return choices[0]
+}
+
+// draw dibuja la interfaz completa: cabecera, lista, resumen y ayuda
+func (s *tuiState) draw(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.width == 0 || s.frame%10 == 0 {
+		s.width, s.height = terminalSize()
+	}
+	width, height := s.width, s.height
+	listWidth := min(max(width/3, 20), 40)
+	paneWidth := width - listWidth - 3
+	rows := height - 2
+
+	item := s.items[s.selected]
+	var pane []string
+	switch item.Status {
+	case tuiQueued:
+		pane = []string{"Waiting..."}
+	case tuiRunning:
+		pane = []string{fmt.Sprintf("%s Summarizing (%s)...", tuiSpinner[s.frame%len(tuiSpinner)], time.Since(item.Started).Round(time.Second))}
+	case tuiFailed:
+		pane = wrapText("\x1b[31mError:\x1b[0m "+item.Err.Error(), paneWidth)
+	default:
+		pane = wrapText(item.Output, paneWidth)
+	}
+	pane = append([]string{"\x1b[1m" + fitWidth(filepath.Base(item.Path), paneWidth) + "\x1b[0m",
+		fmt.Sprintf("type %s · model %s", item.Type, item.Model), ""}, pane...)
+
+	// La lista se desplaza para que el archivo seleccionado quede visible
+	offset := max(s.selected-rows+1, 0)
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	header := fmt.Sprintf(" %s · %d files · %s", commandName, len(s.items), s.progressLine())
+	b.WriteString("\x1b[7m" + padWidth(fitWidth(header, width), width) + "\x1b[0m\r\n")
+	for row := 0; row < rows; row++ {
+		line := ""
+		if i := offset + row; i < len(s.items) {
+			line = padWidth(fitWidth(s.statusIcon(s.items[i])+" "+filepath.Base(s.items[i].Path), listWidth), listWidth)
+			if i == s.selected {
+				line = "\x1b[7m" + line + "\x1b[0m"
+			}
+		} else {
+			line = strings.Repeat(" ", listWidth)
+		}
+		line += " │ "
+		if row < len(pane) {
+			line += pane[row]
+		}
+		b.WriteString(line + "\x1b[K\r\n")
+	}
+	footer := tuiHelp
+	if s.lastLog != "" {
+		footer += "  |  " + s.lastLog
+	}
+	b.WriteString("\x1b[2m" + fitWidth(footer, width) + "\x1b[0m\x1b[K")
+	io.WriteString(w, b.String())
+}
+
+// statusIcon representa el estado de un archivo en la lista
+func (s *tuiState) statusIcon(item *tuiItem) string {
+	switch item.Status {
+	case tuiQueued:
+		return "·"
+	case tuiRunning:
+		return tuiSpinner[s.frame%len(tuiSpinner)]
+	case tuiDone:
+		return "\x1b[32m✓\x1b[0m"
+	case tuiFailed:
+		return "\x1b[31m✗\x1b[0m"
+	}
+	return " "
+}
+
+// progressLine resume cuántos archivos terminaron
+func (s *tuiState) progressLine() string {
+	done, failed := 0, 0
+	for _, item := range s.items {
+		switch item.Status {
+		case tuiDone:
+			done++
+		case tuiFailed:
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d done, %d failed", done, failed)
+}
+
+// ansiRe encuentra las secuencias de color, que no ocupan columnas
+var ansiRe = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// fitWidth recorta un texto a width columnas (sin contar los colores)
+func fitWidth(text string, width int) string {
+	if utf8.RuneCountInString(ansiRe.ReplaceAllString(text, "")) <= width {
+		return text
+	}
+	runes := []rune(ansiRe.ReplaceAllString(text, ""))
+	if width <= 1 {
+		return string(runes[:max(width, 0)])
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// padWidth completa un texto con espacios hasta width columnas
+func padWidth(text string, width int) string {
+	n := utf8.RuneCountInString(ansiRe.ReplaceAllString(text, ""))
+	return text + strings.Repeat(" ", max(width-n, 0))
+}
+
+// readKeys traduce los bytes de la terminal a nombres de teclas
+func readKeys(r io.Reader, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 16)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		seq := string(buf[:n])
+		switch seq {
+		case "\x1b[A", "\x1bOA":
+			keys <- "up"
+		case "\x1b[B", "\x1bOB":
+			keys <- "down"
+		case "\x1b":
+			keys <- "esc"
+		case "\x03":
+			keys <- "ctrl-c"
+		case "\r", "\n":
+			keys <- "enter"
+		default:
+			for _, r := range seq {
+				keys <- string(r)
+			}
+		}
+	}
+}
+
+// enterRawMode pasa la terminal a lectura tecla por tecla sin eco (con stty,
+// como readSecret) y devuelve la función que la restaura
+func enterRawMode() (func(), error) {
+	for _, f := range []*os.File{os.Stdin, os.Stdout} {
+		if stat, err := f.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+			return nil, fmt.Errorf("stdin and stdout must be a terminal")
+		}
+	}
+	stty := func(args ...string) (string, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		out, err := cmd.Output()
+		return strings.TrimSpace(string(out)), err
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("stty is not available: %w", err)
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, fmt.Errorf("stty: %w", err)
+	}
+	return func() { stty(saved) }, nil
+}
+
+// terminalSize devuelve columnas y filas de la terminal (stty size, o las
+// variables COLUMNS/LINES)
+func terminalSize() (int, int) {
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	if out, err := cmd.Output(); err == nil {
+		var rows, cols int
+		if _, err := fmt.Sscan(string(out), &rows, &cols); err == nil && rows > 0 && cols > 0 {
+			return max(cols, 40), max(rows, 6)
+		}
+	}
+	rows, err := strconv.Atoi(os.Getenv("LINES"))
+	if err != nil || rows < 6 {
+		rows = 24
+	}
+	return terminalWidth(), rows
+}
+
+// walkExtensions son las extensiones que se resumen al recorrer un directorio
+var walkExtensions = map[string]bool{".txt": true, ".text": true, ".md": true, ".markdown": true, ".rst": true}
+
+// expandInputs reemplaza los directorios de la lista de entradas por los archivos
+// de texto que contienen, recorriéndolos recursivamente. Los archivos nombrados
+// explícitamente se usan tal cual; hasDir indica si había algún directorio
+func expandInputs(inputs []string, symlinks string) ([]string, bool, error) {
+	var files []string
+	hasDir := false
+	for _, input := range inputs {
+		info, err := os.Stat(input)
+		if err != nil || !info.IsDir() {
+			// Los errores (archivo inexistente, etc.) se informan al leer la entrada
+			files = append(files, input)
+			continue
+		}
+		hasDir = true
+		w := &dirWalker{symlinks: symlinks}
+		if err := w.walk(input, []os.FileInfo{info}); err != nil {
+			return nil, true, err
+		}
+		files = append(files, w.files...)
+	}
+	return files, hasDir, nil
+}
+
+// dirWalker recorre un directorio aplicando la política de enlaces simbólicos.
+// Los directorios ya presentes en la rama actual se saltean para no entrar en
+// ciclos, y los archivos alcanzados por más de un camino se incluyen una vez
+type dirWalker struct {
+	symlinks string // skip, follow o error
+	files    []string
+	seen     []os.FileInfo
+}
+
+func (w *dirWalker) walk(dir string, ancestors []os.FileInfo) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading directory '%s': %w", dir, err)
+	}
+	for _, entry := range entries {
+		// Los archivos y directorios ocultos (.git, .cache...) no se recorren
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Lstat(path)
+		if err != nil {
+			slog.Warn("Skipping unreadable entry", "path", path, "error", err)
+			continue
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch w.symlinks {
+			case "error":
+				return fmt.Errorf("symlink found at '%s' (use --symlinks skip or --follow-symlinks)", path)
+			case "skip":
+				slog.Debug("Skipping symlink", "path", path)
+				continue
+			}
+			if info, err = os.Stat(path); err != nil {
+				slog.Warn("Skipping broken symlink", "path", path, "error", err)
+				continue
+			}
+		}
+
+		switch {
+		case info.IsDir():
+			cycle := false
+			for _, ancestor := range ancestors {
+				if os.SameFile(ancestor, info) {
+					cycle = true
+					break
+				}
+			}
+			if cycle {
+				slog.Warn("Skipping symlink cycle", "path", path)
+				continue
+			}
+			if err := w.walk(path, append(ancestors[:len(ancestors):len(ancestors)], info)); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if !walkExtensions[strings.ToLower(filepath.Ext(path))] {
+				continue
+			}
+			duplicate := false
+			for _, seen := range w.seen {
+				if os.SameFile(seen, info) {
+					duplicate = true
+					break
+				}
+			}
+			if !duplicate {
+				w.seen = append(w.seen, info)
+				w.files = append(w.files, path)
+			}
+		default:
+			// FIFOs, sockets y dispositivos: leerlos puede bloquear indefinidamente
+			slog.Warn("Skipping special file", "path", path, "type", specialFileType(info.Mode()))
+		}
+	}
+	return nil
+}
+
+// specialFileType describe el tipo de un archivo que no es regular ni directorio
+func specialFileType(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "block device"
+	default:
+		return "irregular file"
+	}
+}
+
+// manifestName es el diario de una ejecución por lotes dentro de --output-dir
+const manifestName = "manifest.jsonl"
+
+// batchOptions configura una ejecución por lotes
+type batchOptions struct {
+	OutputDir   string
+	Extension   string
+	Resume      bool
+	NeedsModel  bool
+	Policy      outputPolicy
+	LockTimeout time.Duration
+	// MaxMemory limita los bytes de texto en memoria (0 = sin límite)
+	MaxMemory int64
+	// ReportPath es el archivo de --run-report; Metrics y Settings lo completan
+	ReportPath string
+	Metrics    *RunMetrics
+	Settings   runSettings
+	// Notify entrega cada resumen escrito (--notify-webhook, --slack-channel)
+	Notify *notifier
+	// Budget, si hay límites, se consulta antes de empezar con la estimación del lote
+	Budget *requestBudget
+}
+
+// runReport es el informe de --run-report: qué se procesó, cuánto tardó y con
+// qué configuración, para archivar y comparar ejecuciones
+type runReport struct {
+	StartedAt  string          `json:"started_at"`
+	FinishedAt string          `json:"finished_at"`
+	DurationMS int64           `json:"duration_ms"`
+	Settings   runSettings     `json:"settings"`
+	Totals     runTotals       `json:"totals"`
+	Files      []runReportFile `json:"files"`
+}
+
+// runSettings es la configuración efectiva de la ejecución
+type runSettings struct {
+	Model       string `json:"model"`
+	ModelID     string `json:"model_id"`
+	Provider    string `json:"provider"`
+	Endpoint    string `json:"endpoint"`
+	SummaryType string `json:"summary_type"`
+	Format      string `json:"format"`
+	OutputDir   string `json:"output_dir"`
+	Resume      bool   `json:"resume"`
+	MaxRetries  int    `json:"max_retries"`
+	// Flags son los flags indicados, sin credenciales (ver redactedFlags)
+	Flags map[string]string `json:"flags"`
+}
+
+type runTotals struct {
+	Files          int `json:"files"`
+	Done           int `json:"done"`
+	Skipped        int `json:"skipped"`
+	Failed         int `json:"failed"`
+	Requests       int `json:"requests"`
+	FailedRequests int `json:"failed_requests"`
+	Retries        int `json:"retries"`
+	InputChars     int `json:"input_chars"`
+	OutputChars    int `json:"output_chars"`
+}
+
+// runReportFile es el resultado de un archivo; Requests y Retries son las
+// solicitudes que hizo ese archivo
+type runReportFile struct {
+	Input      string `json:"input"`
+	Output     string `json:"output,omitempty"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	Requests   int    `json:"requests"`
+	Retries    int    `json:"retries"`
+}
+
+// manifestEntry es una línea del manifiesto: el resultado de un archivo. El
+// hash permite saber si la entrada cambió desde que se resumió
+type manifestEntry struct {
+	Input      string `json:"input"`
+	SHA256     string `json:"sha256"`
+	Output     string `json:"output,omitempty"`
+	Status     string `json:"status"` // done o failed
+	Error      string `json:"error,omitempty"`
+	FinishedAt string `json:"finished_at"`
+}
+
+// readManifest devuelve la última entrada de cada archivo del manifiesto. Las
+// líneas ilegibles (por ejemplo, una escritura interrumpida) se ignoran
+func readManifest(path string) (map[string]manifestEntry, error) {
+	entries := map[string]manifestEntry{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		var entry manifestEntry
+		if strings.TrimSpace(line) == "" || json.Unmarshal([]byte(line), &entry) != nil {
+			continue
+		}
+		entries[entry.Input] = entry
+	}
+	return entries, nil
+}
+
+// batchOutputNames asigna un archivo de salida a cada entrada. Si dos entradas
+// tienen el mismo nombre en directorios distintos, se agrega un sufijo con el
+// hash de la ruta para que no se pisen
+func batchOutputNames(inputs []string, dir, ext string) []string {
+	names := make([]string, len(inputs))
+	used := map[string]bool{}
+	for i, input := range inputs {
+		base := strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+		name := base + ".summary" + ext
+		if used[strings.ToLower(name)] {
+			name = base + "-" + hashInput(input)[:8] + ".summary" + ext
+		}
+		used[strings.ToLower(name)] = true
+		names[i] = filepath.Join(dir, name)
+	}
+	return names
+}
+
+// stopsBatch indica si un error hará fallar también a los archivos siguientes
+// (token inválido o límite de solicitudes), en cuyo caso conviene detenerse
+func stopsBatch(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	code := classifyError(err).Code
+	return code == exitAuth || code == exitBudget
+}
+
+// runBatch resume cada archivo y escribe su salida en el directorio indicado.
+// Cada resultado se agrega al manifiesto apenas termina; con --resume se saltan
+// los archivos ya resumidos cuyo contenido no cambió (mismo hash)
+func runBatch(inputs []string, opts batchOptions, process func(path, document string) (string, error), dryRun, quiet bool) error {
+	dir, err := fsPath(opts.OutputDir)
+	if err != nil {
+		return newCLIError(exitUsage, "usage", fmt.Errorf("invalid --output-dir: %w", err))
+	}
+	manifestPath := filepath.Join(dir, manifestName)
+	if !dryRun {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("creating output directory: %w", err)
+		}
+		// El manifiesto se lee y se escribe durante toda la ejecución
+		lock, err := acquireLock(manifestPath, opts.LockTimeout)
+		if err != nil {
+			return err
+		}
+		defer lock.release()
+	}
+	previous := map[string]manifestEntry{}
+	if opts.Resume {
+		if previous, err = readManifest(manifestPath); err != nil {
+			return newCLIError(exitInput, "input", fmt.Errorf("reading manifest: %w", err))
+		}
+	}
+
+	started := time.Now()
+	var reportFiles []runReportFile
+
+	// Leer todas las entradas primero: el hash decide qué archivos saltear
+	type batchItem struct {
+		Input, Key, Output, Document, Hash string
+		ReadErr                            error
+		// Spooled es el archivo temporal con el texto cuando no entró en memoria
+		Spooled string
+	}
+	spool := &documentSpool{limit: opts.MaxMemory}
+	estimatedChars := 0
+	defer spool.close()
+	addExitHook(spool.close)
+	outputs := batchOutputNames(inputs, dir, opts.Extension)
+	var pending []batchItem
+	skipped := 0
+	for i, input := range inputs {
+		item := batchItem{Input: input, Key: input, Output: outputs[i]}
+		if abs, err := filepath.Abs(input); err == nil {
+			item.Key = abs
+		}
+		// Un archivo que no entra en --max-memory se copia al disco sin leerlo
+		// entero, salvo que --resume necesite su hash para compararlo; el hash
+		// de la copia se calcula al cargarla
+		prev, done := previous[item.Key]
+		done = done && prev.Status == "done"
+		if info, err := os.Stat(input); err == nil && !dryRun && !done && !spool.fits(info.Size()) {
+			item.Spooled, item.ReadErr = spool.copyFile(input)
+		} else if item.Document, item.ReadErr = readFile(input); item.ReadErr == nil {
+			item.Hash = hashInput(item.Document)
+		}
+		if item.ReadErr == nil && done && prev.SHA256 == item.Hash {
+			if _, err := os.Stat(prev.Output); err == nil {
+				slog.Debug("Skipping file already summarized", "file", input)
+				skipped++
+				reportFiles = append(reportFiles, runReportFile{Input: item.Key, Output: prev.Output, Status: "skipped"})
+				continue
+			}
+		}
+		if opts.Policy.NoClobber {
+			if _, err := os.Stat(item.Output); err == nil {
+				slog.Info("Skipping file: output exists and --no-clobber is set", "file", input, "output", item.Output)
+				skipped++
+				reportFiles = append(reportFiles, runReportFile{Input: item.Key, Output: item.Output, Status: "skipped"})
+				continue
+			}
+		}
+		if item.ReadErr == nil {
+			// Del archivo copiado sin leer se estima el máximo que se envía
+			chars := maxInputLength
+			if item.Spooled == "" {
+				chars = min(len(item.Document), maxInputLength)
+			}
+			estimatedChars += chars
+		}
+		if item.ReadErr == nil && !dryRun && item.Spooled == "" {
+			if item.Spooled, err = spool.store(item.Document); err != nil {
+				return fmt.Errorf("spooling '%s': %w", input, err)
+			}
+			if item.Spooled != "" {
+				item.Document = ""
+			}
+		}
+		pending = append(pending, item)
+	}
+
+	if dryRun {
+		calls := 0
+		if opts.NeedsModel {
+			calls = len(pending)
+		}
+		fmt.Println("Dry run: no requests will be sent")
+		fmt.Printf("Files:             %d (%d already done, %d to summarize)\n", len(inputs), skipped, len(pending))
+		fmt.Printf("Output directory:  %s\n", dir)
+		fmt.Printf("API calls:         ~%d (one per file; more for toc and --per-speaker)\n", calls)
+		return nil
+	}
+
+	// Consultar los límites con la estimación del lote antes de la primera solicitud
+	if opts.Budget != nil && opts.NeedsModel {
+		if err := opts.Budget.check(len(pending), estimatedChars); err != nil {
+			return err
+		}
+	}
+
+	manifestFlags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if opts.Resume {
+		manifestFlags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	manifest, err := os.OpenFile(manifestPath, manifestFlags, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening manifest: %w", err)
+	}
+	defer manifest.Close()
+	journal := func(entry manifestEntry) {
+		entry.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+		data, _ := json.Marshal(entry)
+		if _, err := manifest.Write(append(data, '\n')); err != nil {
+			slog.Warn("Failed to update manifest", "error", err)
+		}
+	}
+
+	if skipped > 0 {
+		slog.Info("Resuming batch", "already_done", skipped, "remaining", len(pending))
+	}
+	failed := 0
+	if opts.ReportPath != "" {
+		// El informe se escribe también si la ejecución se detiene antes de terminar
+		defer func() {
+			report := buildRunReport(started, opts, reportFiles, len(inputs))
+			data, _ := json.MarshalIndent(report, "", "  ")
+			if err := writeFileAtomic(opts.ReportPath, append(data, '\n'), outputPolicy{}); err != nil {
+				slog.Warn("Failed to write run report", "file", opts.ReportPath, "error", err)
+				return
+			}
+			slog.Info("Run report written", "file", opts.ReportPath)
+		}()
+	}
+	// El tablero se abre después de los avisos de la lectura y se cierra antes
+	// del informe final
+	dashboard := newBatchDashboard(len(inputs), skipped, opts.Metrics, quiet)
+	defer dashboard.Close()
+	addExitHook(dashboard.Close)
+	for i, item := range pending {
+		dashboard.Start(item.Input)
+		entry := manifestEntry{Input: item.Key, SHA256: item.Hash, Status: "failed"}
+		fileStart := time.Now()
+		requestsBefore, retriesBefore := opts.Metrics.counts()
+		record := func(entry manifestEntry) {
+			requests, retries := opts.Metrics.counts()
+			reportFiles = append(reportFiles, runReportFile{
+				Input:      entry.Input,
+				Output:     entry.Output,
+				Status:     entry.Status,
+				Error:      entry.Error,
+				DurationMS: time.Since(fileStart).Milliseconds(),
+				Requests:   requests - requestsBefore,
+				Retries:    retries - retriesBefore,
+			})
+		}
+		err := item.ReadErr
+		document := item.Document
+		if err == nil && item.Spooled != "" {
+			if document, err = spool.load(item.Spooled); err == nil && entry.SHA256 == "" {
+				entry.SHA256 = hashInput(document)
+			}
+		}
+		// El texto ya no hace falta en la lista una vez procesado, y deja de
+		// contar para --max-memory
+		pending[i].Document = ""
+		spool.release(item.Document)
+		var output string
+		if err != nil {
+			err = newCLIError(exitInput, "input", fmt.Errorf("reading file '%s': %w", item.Input, err))
+		} else {
+			if output, err = process(item.Input, document); err == nil {
+				err = writeFileAtomic(item.Output, []byte(output+"\n"), opts.Policy)
+			}
+		}
+		if err != nil {
+			failed++
+			entry.Error = err.Error()
+			journal(entry)
+			record(entry)
+			dashboard.Finish(item.Input, err)
+			slog.Warn("File failed", "file", item.Input, "error", err)
+			if stopsBatch(err) {
+				dashboard.Close()
+				cliErr := newCLIError(classifyError(err).Code, classifyError(err).Category,
+					fmt.Errorf("batch stopped after %d of %d files: %w", skipped+i+1, len(inputs), err))
+				cliErr.Hint = "Completed files are recorded in " + manifestPath + "; run again with --resume to continue"
+				return cliErr
+			}
+			continue
+		}
+		entry.Status, entry.Output = "done", item.Output
+		journal(entry)
+		record(entry)
+		opts.Notify.send(item.Input, item.Output, output)
+		dashboard.Finish(item.Input, nil)
+	}
+	dashboard.Close()
+	if spool.spooled > 0 {
+		slog.Info("Documents spooled to disk to stay under --max-memory", "files", spool.spooled)
+	}
+
+	fmt.Fprintf(os.Stderr, "Summarized %d files into %s (%d skipped, %d failed)\n", len(pending)-failed, dir, skipped, failed)
+	if failed > 0 {
+		err := newCLIError(exitGeneric, "batch", fmt.Errorf("%d of %d files failed", failed, len(inputs)))
+		err.Hint = "See " + manifestPath + " for the errors; run again with --resume to retry only the failed files"
+		return err
+	}
+	return nil
+}
+
+// notifier entrega los resúmenes terminados a un webhook propio (JSON) y/o a un
+// canal de Slack. Los fallos de entrega solo se advierten: el resumen ya está
+// escrito y no debe perderse la ejecución por una notificación
+type notifier struct {
+	Webhook      string
+	SlackChannel string
+	SlackToken   string
+	HTTP         *http.Client
+	Retry        RetryPolicy
+	Model        string
+	SummaryType  string
+}
+
+// slackPostURL es la API de Slack para publicar mensajes con un token de bot
+var slackPostURL = "https://slack.com/api/chat.postMessage"
+
+// slackMaxText es el largo máximo del resumen en un mensaje de Slack
+const slackMaxText = 3000
+
+// summaryEvent es el cuerpo que recibe --notify-webhook
+type summaryEvent struct {
+	Event       string `json:"event"`
+	Input       string `json:"input"`
+	Output      string `json:"output_file,omitempty"`
+	Model       string `json:"model"`
+	SummaryType string `json:"summary_type"`
+	// Format solo se informa en los destinos de la sección "outputs"; con
+	// json o structured el resumen va como objeto en Result
+	Format      string          `json:"format,omitempty"`
+	Summary     string          `json:"summary,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	CompletedAt string          `json:"completed_at"`
+}
+
+// send entrega un resumen a los destinos configurados
+func (n *notifier) send(input, output, summary string) {
+	if n == nil || (n.Webhook == "" && n.SlackChannel == "") {
+		return
+	}
+	if abs, err := filepath.Abs(input); err == nil {
+		input = abs
+	}
+	if n.Webhook != "" {
+		n.postWebhook(n.Webhook, input, output, summary, "")
+	}
+	if n.SlackChannel != "" {
+		n.postSlack(n.SlackChannel, input, summary)
+	}
+}
+
+// postWebhook envía el evento de un resumen a un webhook. format solo se
+// indica para los destinos de la sección "outputs"
+func (n *notifier) postWebhook(webhook, input, output, summary, format string) {
+	event := summaryEvent{
+		Event:       "summary.completed",
+		Input:       input,
+		Output:      output,
+		Model:       n.Model,
+		SummaryType: n.SummaryType,
+		Format:      format,
+		Summary:     summary,
+		CompletedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if (format == "json" || format == "structured") && json.Valid([]byte(summary)) {
+		event.Summary, event.Result = "", json.RawMessage(summary)
+	}
+	// La ruta del webhook suele llevar el secreto: en los logs va solo el host
+	if err := n.post(webhook, "", event); err != nil {
+		slog.Warn("Webhook notification failed", "host", redactURL(webhook, false), "error", err)
+	} else {
+		slog.Debug("Webhook notified", "host", redactURL(webhook, false), "input", input)
+	}
+}
+
+// postSlack publica un resumen en un canal de Slack
+func (n *notifier) postSlack(channel, input, summary string) {
+	text := summary
+	if len(text) > slackMaxText {
+		text = clipText(text, slackMaxText) + " …"
+	}
+	message := map[string]interface{}{
+		"channel": channel,
+		"text":    fmt.Sprintf("*Summary of `%s`* (%s, %s)\n>>> %s", filepath.Base(input), n.SummaryType, n.Model, text),
+	}
+	if err := n.post(slackPostURL, n.SlackToken, message); err != nil {
+		slog.Warn("Slack notification failed", "channel", channel, "error", err)
+	} else {
+		slog.Debug("Slack notified", "channel", channel, "input", input)
+	}
+}
+
+// post envía un JSON con reintentos ante errores de red y respuestas 429/5xx.
+// Con token, la respuesta se interpreta como la de la API de Slack
+func (n *notifier) post(target, token string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for attempt := 0; attempt <= n.Retry.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(n.Retry.Delay(attempt))
+		}
+		req, err := http.NewRequest("POST", target, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := n.HTTP.Do(req)
+		if err != nil {
+			// El error de red incluye la URL completa
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				urlErr.URL = redactURL(urlErr.URL, false)
+			}
+			lastErr = err
+			continue
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("status %d", resp.StatusCode)
+			continue
+		}
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+		if token != "" {
+			var result struct {
+				OK    bool   `json:"ok"`
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(body, &result); err != nil || !result.OK {
+				return fmt.Errorf("slack error: %s", result.Error)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("failed after %d attempts: %w", n.Retry.MaxRetries+1, lastErr)
+}
+
+// requestBudget aplica los límites de uso: por ejecución (--max-requests,
+// --max-input-chars) y por día, con un contador compartido en disco por todas
+// las ejecuciones de la máquina. Al superar un límite se pregunta si seguir
+// cuando hay una terminal; si no, se rechaza la solicitud
+type requestBudget struct {
+	MaxRequests     int
+	MaxInputChars   int
+	DailyRequests   int
+	DailyInputChars int
+	UsagePath       string
+	LockTimeout     time.Duration
+
+	mu         sync.Mutex
+	requests   int
+	inputChars int
+	// approved indica que el usuario aceptó superar los límites en esta ejecución
+	approved bool
+	// noPrompt rechaza sin preguntar aunque haya terminal (p. ej. en --tui)
+	noPrompt bool
+}
+
+// dailyUsage es el contenido del archivo del contador diario
+type dailyUsage struct {
+	Date       string `json:"date"`
+	Requests   int    `json:"requests"`
+	InputChars int    `json:"input_chars"`
+}
+
+func newRequestBudget(cfg BudgetConfig, lockTimeout time.Duration) *requestBudget {
+	b := &requestBudget{
+		MaxRequests:     cfg.MaxRequests,
+		MaxInputChars:   cfg.MaxInputChars,
+		DailyRequests:   cfg.DailyRequests,
+		DailyInputChars: cfg.DailyInputChars,
+		UsagePath:       cfg.UsagePath,
+		LockTimeout:     lockTimeout,
+	}
+	if b.UsagePath == "" {
+		b.UsagePath = filepath.Join(filepath.Dir(defaultConfigPath()), "usage.json")
+	}
+	return b
+}
+
+// enabled indica si hay algún límite configurado
+func (b *requestBudget) enabled() bool {
+	return b.MaxRequests > 0 || b.MaxInputChars > 0 || b.daily()
+}
+
+func (b *requestBudget) daily() bool {
+	return b.DailyRequests > 0 || b.DailyInputChars > 0
+}
+
+// check consulta los límites con una estimación (p. ej. de un lote entero)
+// sin registrar nada
+func (b *requestBudget) check(requests, chars int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var usage dailyUsage
+	if b.daily() {
+		var err error
+		if usage, err = readDailyUsage(b.UsagePath); err != nil {
+			return err
+		}
+	}
+	exceeded := b.exceeded(usage, requests, chars)
+	if exceeded == "" {
+		return nil
+	}
+	return b.confirm(fmt.Sprintf("this run needs ~%d requests and ~%d input characters; %s", requests, chars, exceeded))
+}
+
+// reserve registra una solicitud de chars caracteres si entra en los límites
+func (b *requestBudget) reserve(chars int) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.daily() {
+		if exceeded := b.exceeded(dailyUsage{}, 1, chars); exceeded != "" {
+			if err := b.confirm(exceeded); err != nil {
+				return err
+			}
+		}
+		b.requests++
+		b.inputChars += chars
+		return nil
+	}
+
+	// El contador diario se lee y se actualiza bajo el lock del archivo
+	lock, err := acquireLock(b.UsagePath, b.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	usage, err := readDailyUsage(b.UsagePath)
+	if err != nil {
+		return err
+	}
+	if exceeded := b.exceeded(usage, 1, chars); exceeded != "" {
+		if err := b.confirm(exceeded); err != nil {
+			return err
+		}
+	}
+	b.requests++
+	b.inputChars += chars
+	usage.Requests++
+	usage.InputChars += chars
+	data, _ := json.MarshalIndent(usage, "", "  ")
+	if err := writeFileAtomic(b.UsagePath, append(data, '\n'), outputPolicy{}); err != nil {
+		slog.Warn("Failed to update the daily usage counter", "file", b.UsagePath, "error", err)
+	}
+	return nil
+}
+
+// exceeded describe el primer límite que se superaría con requests solicitudes
+// y chars caracteres más, o devuelve "" si entran
+func (b *requestBudget) exceeded(usage dailyUsage, requests, chars int) string {
+	switch {
+	case b.MaxRequests > 0 && b.requests+requests > b.MaxRequests:
+		return fmt.Sprintf("the limit is %d requests per run (%d sent)", b.MaxRequests, b.requests)
+	case b.MaxInputChars > 0 && b.inputChars+chars > b.MaxInputChars:
+		return fmt.Sprintf("the limit is %d input characters per run (%d sent)", b.MaxInputChars, b.inputChars)
+	case b.DailyRequests > 0 && usage.Requests+requests > b.DailyRequests:
+		return fmt.Sprintf("the daily limit is %d requests (%d used today)", b.DailyRequests, usage.Requests)
+	case b.DailyInputChars > 0 && usage.InputChars+chars > b.DailyInputChars:
+		return fmt.Sprintf("the daily limit is %d input characters (%d used today)", b.DailyInputChars, usage.InputChars)
+	}
+	return ""
+}
+
+// confirm pregunta si superar el límite cuando stdin es una terminal; la
+// respuesta vale para el resto de la ejecución
+func (b *requestBudget) confirm(reason string) error {
+	if b.approved {
+		return nil
+	}
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 && !b.noPrompt {
+		fmt.Fprintf(os.Stderr, "Budget exceeded: %s.\nContinue anyway? [y/N] ", reason)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.EqualFold(strings.TrimSpace(answer), "y") || strings.EqualFold(strings.TrimSpace(answer), "yes") {
+			b.approved = true
+			return nil
+		}
+	}
+	err := newCLIError(exitBudget, "budget", fmt.Errorf("request budget exceeded: %s", reason))
+	err.Hint = "Raise --max-requests/--max-input-chars or the \"budget\" section of the config file, or wait until tomorrow for the daily limits"
+	return err
+}
+
+// readDailyUsage lee el contador del día; un archivo inexistente o de otro día
+// cuenta como cero
+func readDailyUsage(path string) (dailyUsage, error) {
+	today := time.Now().Format("2006-01-02")
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return dailyUsage{Date: today}, nil
+	}
+	if err != nil {
+		return dailyUsage{}, fmt.Errorf("reading usage counter: %w", err)
+	}
+	var usage dailyUsage
+	if err := json.Unmarshal(data, &usage); err != nil {
+		slog.Warn("Usage counter is corrupt; starting from zero", "file", path, "error", err)
+		return dailyUsage{Date: today}, nil
+	}
+	if usage.Date != today {
+		return dailyUsage{Date: today}, nil
+	}
+	return usage, nil
+}
+
+// buildRunReport arma el informe de --run-report con los resultados por
+// archivo y las métricas de la ejecución
+func buildRunReport(started time.Time, opts batchOptions, files []runReportFile, total int) runReport {
+	report := runReport{
+		StartedAt:  started.UTC().Format(time.RFC3339),
+		FinishedAt: time.Now().UTC().Format(time.RFC3339),
+		DurationMS: time.Since(started).Milliseconds(),
+		Settings:   opts.Settings,
+		Files:      files,
+		Totals:     runTotals{Files: total},
+	}
+	if report.Files == nil {
+		report.Files = []runReportFile{}
+	}
+	for _, f := range files {
+		switch f.Status {
+		case "done":
+			report.Totals.Done++
+		case "skipped":
+			report.Totals.Skipped++
+		default:
+			report.Totals.Failed++
+		}
+	}
+	if m := opts.Metrics; m != nil {
+		m.mu.Lock()
+		report.Totals.Requests, report.Totals.FailedRequests, report.Totals.Retries = m.Requests, m.Failed, m.Retries
+		report.Totals.InputChars, report.Totals.OutputChars = m.InputChars, m.OutputChars
+		m.mu.Unlock()
+	}
+	return report
+}
+
+// documentSpool mantiene en memoria el texto de los documentos mientras no
+// supere limit bytes; los que no entran se guardan en archivos temporales y
+// se vuelven a leer al procesarlos
+type documentSpool struct {
+	limit   int64
+	used    int64
+	dir     string
+	spooled int
+	// raw son los archivos copiados tal cual por copyFile, que al cargarlos
+	// todavía hay que decodificar
+	raw map[string]bool
+}
+
+// fits indica si size bytes más entran en memoria
+func (s *documentSpool) fits(size int64) bool {
+	return s.limit <= 0 || s.used+size <= s.limit
+}
+
+// release descuenta de la memoria usada un documento ya procesado
+func (s *documentSpool) release(document string) {
+	s.used -= int64(len(document))
+}
+
+// create abre un archivo temporal nuevo en el directorio del spool
+func (s *documentSpool) create() (*os.File, error) {
+	if s.dir == "" {
+		dir, err := os.MkdirTemp("", "summarizer-spool-")
+		if err != nil {
+			return nil, err
+		}
+		s.dir = dir
+	}
+	return os.CreateTemp(s.dir, "doc-*.txt")
+}
+
+// store devuelve el archivo temporal donde se guardó document, o "" si el
+// documento entra en memoria
+func (s *documentSpool) store(document string) (string, error) {
+	size := int64(len(document))
+	if s.fits(size) {
+		s.used += size
+		return "", nil
+	}
+	f, err := s.create()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(document); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	s.spooled++
+	return f.Name(), nil
+}
+
+// copyFile copia un archivo de entrada al spool sin cargarlo en memoria. La
+// copia es una instantánea del archivo tal como estaba al leer el lote
+func (s *documentSpool) copyFile(input string) (string, error) {
+	path, err := fsPath(input)
+	if err != nil {
+		return "", err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	defer src.Close()
+	f, err := s.create()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, src); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	if s.raw == nil {
+		s.raw = map[string]bool{}
+	}
+	s.raw[f.Name()] = true
+	s.spooled++
+	return f.Name(), nil
+}
+
+// load lee un documento guardado por store o copyFile y borra el archivo
+// temporal. Las copias de copyFile pasan por readFile (decodificación y
+// normalización) al cargarlas
+func (s *documentSpool) load(path string) (string, error) {
+	defer os.Remove(path)
+	if s.raw[path] {
+		delete(s.raw, path)
+		return readFile(path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// close borra los archivos temporales que queden
+func (s *documentSpool) close() {
+	if s.dir != "" {
+		os.RemoveAll(s.dir)
+		s.dir = ""
+	}
+}
+
+// parseByteSize interpreta tamaños como "512MB", "1GiB" o "1048576" (bytes).
+// La cadena vacía y "0" significan sin límite
+func parseByteSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+		{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"B", 1},
+	}
+	number, factor := strings.ToUpper(value), int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, factor = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix)), unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("'%s' is not a size (use e.g. 512MB or 1GiB)", value)
+	}
+	return int64(n * float64(factor)), nil
+}
+
+// estimateTokens aproxima la cantidad de tokens de un texto
+// (en promedio un token equivale a unos 4 caracteres en inglés)
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// printDryRun muestra qué haría la herramienta con la entrada sin realizar
+// ninguna solicitud de red
+func printDryRun(inputFile, document string, render renderOptions, client *APIClient, warmup bool) {
+	summaryType := render.Type
+	chars := len(document)
+	// El recorte es el mismo que el del envío real: con --weight-pattern o
+	// regiones summarize:must entran primero los párrafos prioritarios
+	sent := markPriority(document, render.Weight)
+	chunkNote := ""
+	if len(sent) > maxInputLength {
+		chunkNote = fmt.Sprintf(" (input truncated to %d of %d characters)", maxInputLength, len(sent))
+		sent = fitPriority(sent, maxInputLength)
+	}
+
+	params := requestParameters(summaryType, client.Length, sent)
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var paramList []string
+	for _, k := range keys {
+		paramList = append(paramList, fmt.Sprintf("%s=%v", k, params[k]))
+	}
+
+	modelCalls := 0
+	if needsModel(summaryType) {
+		modelCalls = 1
+	}
+	if summaryType == "toc" {
+		sections := detectSections(document)
+		modelCalls = 0
+		for _, section := range sections {
+			if sectionNeedsModel(section) {
+				modelCalls++
+			}
+		}
+		chunkNote = fmt.Sprintf(" per section (%d sections, %d long enough to summarize)", len(sections), modelCalls)
+	}
+	if summaryType == "glossary" {
+		terms, _ := collectGlossary(document)
+		modelCalls = 0
+		for _, entry := range terms {
+			if entry.Definition == "" && modelCalls < maxGlossaryQueries {
+				modelCalls++
+			}
+		}
+		chunkNote = fmt.Sprintf(" per undefined term (%d terms, %d without a definition in the text)", len(terms), modelCalls)
+	}
+	if render.Report != nil || len(render.Tasks) > 0 {
+		// Ejecutar la plantilla o las tareas sin red para contar los resúmenes que piden
+		calls := map[string]bool{}
+		data := newReportData(document, summaryType, func(t string) (string, error) {
+			if needsModel(t) {
+				calls[t] = true
+			}
+			return "", nil
+		})
+		var err error
+		if render.Report != nil {
+			_, err = render.Report.render(data)
+		} else {
+			_, err = runTasks(render.Tasks, data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		types := make([]string, 0, len(calls))
+		for t := range calls {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		modelCalls = len(types)
+		chunkNote = fmt.Sprintf(" shared by the requested sections (%d summaries: %s)", modelCalls, strings.Join(types, ", "))
+	}
+	if render.Hierarchical {
+		tree := planSummaryTree(document, render.Depth)
+		modelCalls = countSummaryCalls(tree, false)
+		chunkNote = fmt.Sprintf(" hierarchical (%d nodes in %d levels, %d summaries)", countNodes(tree), treeHeight(tree), modelCalls)
+	}
+	if turns := parseTranscript(document); render.PerSpeaker && turns != nil {
+		speakers := transcriptSpeakers(turns)
+		modelCalls = 0
+		for _, speaker := range speakers {
+			if len(speakerText(turns, speaker)) >= minSectionSummaryLength {
+				modelCalls++
+			}
+		}
+		chunkNote = fmt.Sprintf(" per speaker (%d speakers, %d long enough to summarize)", len(speakers), modelCalls)
+	}
+	if words, short := client.ShortInput.short(document); short && needsModel(summaryType) {
+		modelCalls = 0
+		chunkNote = fmt.Sprintf(" (%d words, below --min-input-words %d: %s without calling the model)", words, client.ShortInput.MinWords, client.ShortInput.Mode)
+	}
+	calls := modelCalls
+	if warmup && modelCalls > 0 {
+		calls++
+	}
+	maxCalls := calls + modelCalls*client.Retry.MaxRetries
+
+	endpointKind := "router"
+	if client.Target.Dedicated {
+		endpointKind = "dedicated endpoint"
+	} else if client.Target.Gradio {
+		endpointKind = "Gradio app, api " + client.Target.APIName
+	}
+
+	fmt.Println("Dry run: no requests will be sent")
+	fmt.Printf("Input file:        %s\n", inputFile)
+	fmt.Printf("Characters:        %d\n", chars)
+	fmt.Printf("Estimated tokens:  ~%d (~%d sent)\n", estimateTokens(document), estimateTokens(sent))
+	fmt.Printf("Chunks:            1%s\n", chunkNote)
+	fmt.Printf("Model:             %s (provider %s)\n", client.Target.ID, client.Target.Provider)
+	fmt.Printf("Endpoint:          %s (%s)\n", client.Target.URL, endpointKind)
+	fmt.Printf("Summary type:      %s\n", summaryType)
+	fmt.Printf("Parameters:        %s\n", strings.Join(paramList, " "))
+	fmt.Printf("API calls:         %d (up to %d with retries)\n", calls, maxCalls)
+}
+
+// Identificadores con los que se guarda el token en el llavero del sistema
+const (
+	keyringService = "summarizer"
+	keyringAccount = "huggingface"
+
+	// whoamiURL valida un token y devuelve el usuario al que pertenece
+	whoamiURL = "https://huggingface.co/api/whoami-v2"
+)
+
+// lookupAPIToken busca el token de API. La variable de entorno tiene prioridad
+// sobre el llavero del sistema. Devuelve también de dónde se obtuvo
+func lookupAPIToken() (string, string) {
+	if token := os.Getenv("HUGGINGFACE_API_TOKEN"); token != "" {
+		return token, "env"
+	}
+	token, err := keyringGet()
+	if err != nil {
+		slog.Debug("No token in system keyring", "error", err)
+		return "", "none"
+	}
+	return token, "keyring"
+}
+
+// tokenLookup busca el token de API la primera vez que se necesita, para no
+// ejecutar la herramienta del llavero en las ejecuciones que no llaman al
+// modelo. Lo comparten todos los clientes de una ejecución
+type tokenLookup struct {
+	once  sync.Once
+	token string
+}
+
+func (t *tokenLookup) get() string {
+	t.once.Do(func() {
+		var source string
+		t.token, source = lookupAPIToken()
+		slog.Debug("API token lookup", "source", source)
+	})
+	return t.token
+}
+
+// errMissingToken es el error de autenticación cuando no hay token de API
+func errMissingToken() error {
+	err := newCLIError(exitAuth, "auth", fmt.Errorf("HUGGINGFACE_API_TOKEN is not set"))
+	err.Hint = tokenHelp
+	return err
+}
+
+// errKeyringUnsupported indica que no hay un llavero utilizable en este sistema
+var errKeyringUnsupported = errors.New("no supported system keyring found (macOS Keychain, Linux Secret Service via secret-tool, or Windows DPAPI via PowerShell)")
+
+// windowsTokenPath es donde se guarda en Windows el token cifrado con DPAPI
+// (ligado a la cuenta del usuario, igual que el Administrador de credenciales)
+func windowsTokenPath() string {
+	return filepath.Join(filepath.Dir(defaultConfigPath()), "token.dpapi")
+}
+
+// runKeyringTool ejecuta la herramienta del llavero del sistema, pasando la
+// entrada por stdin para que el secreto no aparezca en la línea de comandos
+func runKeyringTool(stdin string, name string, args ...string) (string, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return "", errKeyringUnsupported
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %v %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// keyringSet guarda el token en el llavero del sistema
+func keyringSet(token string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		// Con -i, security lee el comando por stdin: el token no queda en los
+		// argumentos del proceso (visibles en ps)
+		command := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+			securityQuote(keyringService), securityQuote(keyringAccount), securityQuote(token))
+		if _, err := runKeyringTool(command, "security", "-i"); err != nil {
+			return err
+		}
+		// En modo interactivo security no siempre falla con código de salida:
+		// se comprueba leyendo el token guardado
+		if stored, err := keyringGet(); err != nil || stored != token {
+			return fmt.Errorf("security did not store the token in the keychain")
+		}
+		return nil
+	case "windows":
+		script := "[Console]::In.ReadToEnd().Trim() | ConvertTo-SecureString -AsPlainText -Force | ConvertFrom-SecureString"
+		blob, err := runKeyringTool(token, "powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+		if err != nil {
+			return err
+		}
+		path := windowsTokenPath()
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return err
+		}
+		return os.WriteFile(path, []byte(blob), 0o600)
+	default:
+		_, err := runKeyringTool(token, "secret-tool", "store", "--label=Summarizer HuggingFace token",
+			"service", keyringService, "account", keyringAccount)
+		return err
+	}
+}
+
+// securityQuote cita un argumento para el modo interactivo de security
+func securityQuote(arg string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// keyringGet lee el token del llavero del sistema
+func keyringGet() (string, error) {
+	var token string
+	var err error
+	switch runtime.GOOS {
+	case "darwin":
+		token, err = runKeyringTool("", "security", "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w")
+	case "windows":
+		blob, readErr := os.ReadFile(windowsTokenPath())
+		if readErr != nil {
+			return "", readErr
+		}
+		script := "$s = [Console]::In.ReadToEnd().Trim() | ConvertTo-SecureString; " +
+			"[Runtime.InteropServices.Marshal]::PtrToStringBSTR([Runtime.InteropServices.Marshal]::SecureStringToBSTR($s))"
+		token, err = runKeyringTool(string(blob), "powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	default:
+		token, err = runKeyringTool("", "secret-tool", "lookup", "service", keyringService, "account", keyringAccount)
+	}
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", fmt.Errorf("no token stored")
+	}
+	return token, nil
+}
+
+// keyringDelete elimina el token del llavero del sistema
+func keyringDelete() error {
+	switch runtime.GOOS {
+	case "darwin":
+		_, err := runKeyringTool("", "security", "delete-generic-password", "-s", keyringService, "-a", keyringAccount)
+		return err
+	case "windows":
+		err := os.Remove(windowsTokenPath())
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no token stored")
+		}
+		return err
+	default:
+		_, err := runKeyringTool("", "secret-tool", "clear", "service", keyringService, "account", keyringAccount)
+		return err
+	}
+}
+
+// whoami valida el token contra la API de HuggingFace y devuelve el nombre de usuario
+func whoami(httpClient *http.Client, token string) (string, error) {
+	req, err := http.NewRequest("GET", whoamiURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	var info struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return info.Name, nil
+}
+
+// readSecret lee una línea de stdin sin mostrarla cuando es una terminal
+// (en sistemas Unix, desactivando el eco con stty)
+func readSecret(prompt string) (string, error) {
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, prompt)
+		if runtime.GOOS != "windows" {
+			stty := func(arg string) {
+				cmd := exec.Command("stty", arg)
+				cmd.Stdin = os.Stdin
+				_ = cmd.Run()
+			}
+			stty("-echo")
+			defer func() {
+				stty("echo")
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read token: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// runAuthCommand implementa "auth login", "auth logout" y "auth status"
+func runAuthCommand(args []string) {
+	fs := flag.NewFlagSet("auth", flag.ExitOnError)
+	errorFormat := fs.String("error-format", "text", "Error output format on stderr: text or json")
+	configPath := fs.String("config", "", "Path to a JSON config file (used for HTTP proxy/CA settings)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go run solution_summarizer.go auth <login|logout|status> [flags]")
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	subcommand := args[0]
+	fs.Parse(args[1:])
+	setupLogging(0, false)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		exitWithError(newCLIError(exitUsage, "usage", err), *errorFormat)
+	}
+	httpClient, err := buildHTTPClient(HTTPOptions{
+		Proxy:              cfg.HTTP.Proxy,
+		CABundle:           cfg.HTTP.CABundle,
+		InsecureSkipVerify: cfg.HTTP.InsecureSkipVerify,
+		Timeout:            cfg.HTTP.Timeout.orDefault(defaultRequestTimeout),
+		ConnectTimeout:     cfg.HTTP.ConnectTimeout.orDefault(defaultConnectTimeout),
+	})
+	if err != nil {
+		exitWithError(newCLIError(exitUsage, "usage", err), *errorFormat)
+	}
+
+	switch subcommand {
+	case "login":
+		token, err := readSecret("Paste your HuggingFace token (input is hidden): ")
+		if err != nil {
+			exitWithError(newCLIError(exitInput, "input", err), *errorFormat)
+		}
+		if token == "" {
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("empty token")), *errorFormat)
+		}
+		user, err := whoami(httpClient, token)
+		if err != nil {
+			exitWithError(fmt.Errorf("validating token: %w", err), *errorFormat)
+		}
+		if err := keyringSet(token); err != nil {
+			exitWithError(newCLIError(exitAuth, "auth", fmt.Errorf("storing token in keyring: %w", err)), *errorFormat)
+		}
+		fmt.Printf("Logged in as %s. Token stored in the system keyring.\n", user)
+		if os.Getenv("HUGGINGFACE_API_TOKEN") != "" {
+			fmt.Println("Note: HUGGINGFACE_API_TOKEN is set and takes precedence over the keyring.")
+		}
+
+	case "logout":
+		if err := keyringDelete(); err != nil {
+			exitWithError(newCLIError(exitAuth, "auth", fmt.Errorf("removing token from keyring: %w", err)), *errorFormat)
+		}
+		fmt.Println("Token removed from the system keyring.")
+
+	case "status":
+		token, source := lookupAPIToken()
+		if token == "" {
+			err := newCLIError(exitAuth, "auth", fmt.Errorf("not logged in: no token in HUGGINGFACE_API_TOKEN or the system keyring"))
+			err.Hint = "Run: go run solution_summarizer.go auth login"
+			exitWithError(err, *errorFormat)
+		}
+		sourceName := "HUGGINGFACE_API_TOKEN environment variable"
+		if source == "keyring" {
+			sourceName = "system keyring"
+		}
+		user, err := whoami(httpClient, token)
+		if err != nil {
+			exitWithError(fmt.Errorf("token from %s is not valid: %w", sourceName, err), *errorFormat)
+		}
+		fmt.Printf("Logged in as %s (token %s from %s)\n", user, sanitizeToken(token), sourceName)
+
+	default:
+		fs.Usage()
+		exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("unknown auth subcommand '%s'", subcommand)), *errorFormat)
+	}
+}
+
+// defaultLockTimeout es cuánto se espera a que otra ejecución libere un archivo compartido
+const defaultLockTimeout = 10 * time.Second
+
+// staleLockAge es la antigüedad a partir de la cual un bloqueo de otra máquina
+// (por ejemplo, en un disco de red) se considera abandonado
+const staleLockAge = 24 * time.Hour
+
+// fileLock es un bloqueo consultivo entre procesos: el archivo <ruta>.lock,
+// creado con O_EXCL para que solo un proceso lo obtenga. Funciona igual en
+// todos los sistemas y en discos de red, a diferencia de flock/LockFileEx
+type fileLock struct {
+	path string
+	once sync.Once
+}
+
+// lockOwner es el contenido del archivo de bloqueo: quién lo tiene y desde cuándo
+type lockOwner struct {
+	PID     int    `json:"pid"`
+	Host    string `json:"host"`
+	Created string `json:"created"`
+}
+
+// acquireLock obtiene el bloqueo de un archivo compartido, esperando hasta
+// timeout si lo tiene otra ejecución. Los bloqueos de procesos que ya no
+// existen se eliminan. El bloqueo se libera también si el proceso termina con error
+func acquireLock(target string, timeout time.Duration) (*fileLock, error) {
+	path := target + ".lock"
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating lock directory: %w", err)
+	}
+	host, _ := os.Hostname()
+	deadline := time.Now().Add(timeout)
+	delay := 50 * time.Millisecond
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			data, _ := json.Marshal(lockOwner{PID: os.Getpid(), Host: host, Created: time.Now().UTC().Format(time.RFC3339)})
+			f.Write(data)
+			f.Close()
+			lock := &fileLock{path: path}
+			exitHooksMu.Lock()
+			heldLocks[path] = lock
+			exitHooksMu.Unlock()
+			slog.Log(context.Background(), LevelTrace, "Lock acquired", "file", path)
+			return lock, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("creating lock file: %w", err)
+		}
+
+		owner, stale := lockIsStale(path, host)
+		if stale && claimStaleLock(path, host) {
+			slog.Warn("Removed stale lock", "file", path, "owner", owner)
+			continue
+		}
+		if time.Now().After(deadline) {
+			err := newCLIError(exitGeneric, "lock", fmt.Errorf("'%s' is in use by another run (%s)", target, owner))
+			err.Hint = "Wait for the other run to finish, raise --lock-timeout, or delete " + path + " if no other run is active"
+			return nil, err
+		}
+		time.Sleep(delay)
+		if delay < time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// claimStaleLock retira un bloqueo abandonado sin carrera entre ejecuciones:
+// primero lo renombra a un nombre único, así que si dos lo detectan a la vez
+// solo una lo consigue. Si entre la comprobación y el renombre otra ejecución
+// ya había tomado el bloqueo, lo renombrado no está abandonado y se devuelve a
+// su lugar. Indica si se retiró el bloqueo y conviene reintentar enseguida
+func claimStaleLock(path, host string) bool {
+	claimed := fmt.Sprintf("%s.stale-%d-%d", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, claimed); err != nil {
+		return false
+	}
+	if _, stale := lockIsStale(claimed, host); stale {
+		os.Remove(claimed)
+		return true
+	}
+	// Link no reemplaza un bloqueo que se haya creado mientras tanto; solo si
+	// funciona sobra la copia renombrada. Si falla (otra ejecución ya creó path
+	// o el disco no admite enlaces) se devuelve con un renombre: borrar la
+	// copia dejaría a su dueño sin bloqueo
+	err := os.Link(claimed, path)
+	if err == nil {
+		os.Remove(claimed)
+		return false
+	}
+	slog.Warn("Failed to restore a live lock, renaming it back", "file", path, "error", err)
+	if err := os.Rename(claimed, path); err != nil {
+		slog.Warn("Failed to restore a live lock", "file", path, "error", err)
+	}
+	return false
+}
+
+// release elimina el archivo de bloqueo; se puede llamar más de una vez
+func (l *fileLock) release() {
+	l.once.Do(func() {
+		exitHooksMu.Lock()
+		if heldLocks[l.path] == l {
+			delete(heldLocks, l.path)
+		}
+		exitHooksMu.Unlock()
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to remove lock file", "file", l.path, "error", err)
+		}
+	})
+}
+
+// lockIsStale indica si el dueño del bloqueo ya no existe: un proceso de esta
+// máquina que terminó (por ejemplo, con Ctrl+C) o un bloqueo muy antiguo
+func lockIsStale(path, host string) (string, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "released", false
+	}
+	data, _ := os.ReadFile(path)
+	var owner lockOwner
+	if json.Unmarshal(data, &owner) != nil {
+		// Un archivo vacío o a medio escribir solo es abandonado si no es reciente
+		return "unknown owner", time.Since(info.ModTime()) > time.Minute
+	}
+	description := fmt.Sprintf("pid %d on %s since %s", owner.PID, owner.Host, owner.Created)
+	if owner.Host == host && !processAlive(owner.PID) {
+		return description, true
+	}
+	return description, time.Since(info.ModTime()) > staleLockAge
+}
+
+// processAlive indica si existe un proceso con ese PID en esta máquina
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// En Windows FindProcess falla si el proceso no existe
+		process.Release()
+		return true
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// historyEntry es una ejecución guardada en el historial
+type historyEntry struct {
+	ID        int64  `json:"id"`
+	CreatedAt string `json:"created_at"`
+	InputHash string `json:"input_hash"`
+	FileName  string `json:"file_name"`
+	Model     string `json:"model"`
+	Type      string `json:"summary_type"`
+	Summary   string `json:"summary"`
+}
+
+// historySchema crea la tabla del historial si todavía no existe
+const historySchema = `CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at TEXT NOT NULL,
+	input_hash TEXT NOT NULL,
+	file_name TEXT NOT NULL,
+	model TEXT NOT NULL,
+	summary_type TEXT NOT NULL,
+	summary TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS runs_input_hash ON runs(input_hash);
+`
+
+// errSQLiteUnavailable indica que no está instalado el cliente sqlite3
+var errSQLiteUnavailable = errors.New("sqlite3 command not found; install SQLite to use the summary history")
+
+// defaultHistoryPath devuelve la ubicación estándar de la base de datos del historial
+func defaultHistoryPath() string {
+	return filepath.Join(filepath.Dir(defaultConfigPath()), "history.db")
+}
+
+// hashInput identifica el contenido de la entrada, independientemente del nombre del archivo
+func hashInput(document string) string {
+	sum := sha256.Sum256([]byte(canonicalText(document)))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalText normaliza un documento antes de calcular su hash, para que el
+// mismo texto guardado en Windows o en Linux (o por editores distintos) cuente
+// como la misma entrada en el manifiesto de --resume y en el historial: quita
+// el BOM, unifica los saltos de línea (CRLF y CR pasan a LF), elimina los
+// espacios al final de cada línea y las líneas en blanco finales, y compone
+// las letras acentuadas escritas con marcas combinantes (forma NFC)
+func canonicalText(text string) string {
+	text = strings.TrimPrefix(text, "\uFEFF")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return composeLatin(strings.TrimRight(strings.Join(lines, "\n"), "\n"))
+}
+
+// latinCompositions son las letras latinas precompuestas (base, marca
+// combinante, resultado). La biblioteca estándar no incluye las tablas de
+// normalización Unicode (están en golang.org/x/text/unicode/norm), así que se
+// cubren los acentos de los idiomas de Europa occidental y central: grave,
+// agudo, circunflejo, tilde, diéresis, anillo, cedilla y caron
+const latinCompositions = "A\u0300ÀA\u0301ÁA\u0302ÂA\u0303ÃA\u0308ÄA\u030AÅa\u0300àa\u0301á" +
+	"a\u0302âa\u0303ãa\u0308äa\u030AåE\u0300ÈE\u0301ÉE\u0302ÊE\u0308Ë" +
+	"E\u030CĚe\u0300èe\u0301ée\u0302êe\u0308ëe\u030CěI\u0300ÌI\u0301Í" +
+	"I\u0302ÎI\u0303ĨI\u0308Ïi\u0300ìi\u0301íi\u0302îi\u0303ĩi\u0308ï" +
+	"O\u0300ÒO\u0301ÓO\u0302ÔO\u0303ÕO\u0308Öo\u0300òo\u0301óo\u0302ô" +
+	"o\u0303õo\u0308öU\u0300ÙU\u0301ÚU\u0302ÛU\u0303ŨU\u0308ÜU\u030AŮ" +
+	"u\u0300ùu\u0301úu\u0302ûu\u0303ũu\u0308üu\u030AůY\u0301ÝY\u0302Ŷ" +
+	"Y\u0308Ÿy\u0301ýy\u0302ŷy\u0308ÿN\u0301ŃN\u0303ÑN\u0327ŅN\u030CŇ" +
+	"n\u0301ńn\u0303ñn\u0327ņn\u030CňC\u0301ĆC\u0302ĈC\u0327ÇC\u030CČ" +
+	"c\u0301ćc\u0302ĉc\u0327çc\u030CčS\u0301ŚS\u0302ŜS\u0327ŞS\u030CŠ" +
+	"s\u0301śs\u0302ŝs\u0327şs\u030CšZ\u0301ŹZ\u030CŽz\u0301źz\u030Cž"
+
+var latinComposed = func() map[[2]rune]rune {
+	table := map[[2]rune]rune{}
+	runes := []rune(latinCompositions)
+	for i := 0; i+2 < len(runes); i += 3 {
+		table[[2]rune{runes[i], runes[i+1]}] = runes[i+2]
+	}
+	return table
+}()
+
+// composeLatin reemplaza cada letra seguida de una marca combinante por su
+// forma precompuesta, si está en latinCompositions
+func composeLatin(text string) string {
+	if !strings.ContainsFunc(text, func(r rune) bool { return r >= 0x300 && r <= 0x36F }) {
+		return text
+	}
+	runes := []rune(text)
+	out := make([]rune, 0, len(runes))
+	for _, r := range runes {
+		if n := len(out); n > 0 {
+			if composed, ok := latinComposed[[2]rune{out[n-1], r}]; ok {
+				out[n-1] = composed
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+// sqlQuote escapa un valor como literal de texto de SQL
+func sqlQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// runSQLite ejecuta un script SQL con el cliente sqlite3 (así la herramienta
+// sigue sin dependencias) y decodifica las filas que devuelve en modo -json
+func runSQLite(dbPath, script string) ([]historyEntry, error) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return nil, errSQLiteUnavailable
+	}
+	if err := os.MkdirAll(filepath.Dir(dbPath), 0o700); err != nil {
+		return nil, fmt.Errorf("creating history directory: %w", err)
+	}
+	cmd := exec.Command("sqlite3", "-json", "-bail", dbPath)
+	cmd.Stdin = strings.NewReader(historySchema + script)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sqlite3 failed: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	entries := []historyEntry{}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return entries, nil
+	}
+	if err := json.Unmarshal(out, &entries); err != nil {
+		return nil, fmt.Errorf("invalid sqlite3 output: %w", err)
+	}
+	return entries, nil
+}
+
+// recordHistory guarda una ejecución y devuelve su identificador
+func recordHistory(dbPath string, entry historyEntry) (int64, error) {
+	script := fmt.Sprintf("INSERT INTO runs (created_at, input_hash, file_name, model, summary_type, summary) VALUES (%s, %s, %s, %s, %s, %s);\nSELECT last_insert_rowid() AS id;\n",
+		sqlQuote(time.Now().UTC().Format(time.RFC3339)), sqlQuote(entry.InputHash), sqlQuote(entry.FileName),
+		sqlQuote(entry.Model), sqlQuote(entry.Type), sqlQuote(entry.Summary))
+	rows, err := runSQLite(dbPath, script)
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, fmt.Errorf("sqlite3 did not return the new row id")
+	}
+	return rows[0].ID, nil
+}
+
+// formatHistoryList muestra una línea por ejecución: id, fecha, tipo, modelo, archivo y el inicio del resumen
+func formatHistoryList(entries []historyEntry) string {
+	if len(entries) == 0 {
+		return "No summaries in history"
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		preview := strings.Join(strings.Fields(e.Summary), " ")
+		if utf8.RuneCountInString(preview) > 60 {
+			preview = string([]rune(preview)[:57]) + "..."
+		}
+		fmt.Fprintf(&b, "%4d  %s  %-9s %-28s %s\n     %s\n", e.ID, e.CreatedAt, e.Type, e.Model, filepath.Base(e.FileName), preview)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// runHistoryCommand implementa "history list", "history show <id>" y "history search <term>"
+func runHistoryCommand(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	errorFormat := fs.String("error-format", "text", "Error output format on stderr: text or json")
+	configPath := fs.String("config", "", "Path to a JSON config file (used for the history database path)")
+	dbPath := fs.String("db", "", "SQLite history database (default: <user config dir>/summarizer/history.db)")
+	limit := fs.Int("limit", 20, "Maximum number of entries listed by list and search")
+	outputFormat := fs.String("format", "text", "Output format: text or json")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go run solution_summarizer.go history <list|show <id>|search <term>> [flags]")
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	subcommand := args[0]
+	fs.Parse(args[1:])
+	setupLogging(0, false)
+
+	if *dbPath == "" {
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			exitWithError(newCLIError(exitUsage, "usage", err), *errorFormat)
+		}
+		*dbPath = cfg.History.Path
+	}
+	if *dbPath == "" {
+		*dbPath = defaultHistoryPath()
+	}
+	if *limit <= 0 {
+		exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("--limit must be positive")), *errorFormat)
+	}
+
+	const columns = "SELECT id, created_at, input_hash, file_name, model, summary_type, summary FROM runs"
+	var script string
+	switch subcommand {
+	case "list":
+		script = fmt.Sprintf("%s ORDER BY id DESC LIMIT %d;\n", columns, *limit)
+	case "show":
+		id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+		if fs.NArg() != 1 || err != nil {
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("history show needs a numeric entry id")), *errorFormat)
+		}
+		script = fmt.Sprintf("%s WHERE id = %d;\n", columns, id)
+	case "search":
+		if fs.NArg() == 0 {
+			exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("history search needs a search term")), *errorFormat)
+		}
+		// Escapar los comodines de LIKE para buscar el término literal
+		term := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.Join(fs.Args(), " "))
+		pattern := sqlQuote("%" + term + "%")
+		script = fmt.Sprintf("%s WHERE summary LIKE %s ESCAPE '\\' OR file_name LIKE %s ESCAPE '\\' ORDER BY id DESC LIMIT %d;\n",
+			columns, pattern, pattern, *limit)
+	default:
+		fs.Usage()
+		exitWithError(newCLIError(exitUsage, "usage", fmt.Errorf("unknown history subcommand '%s'", subcommand)), *errorFormat)
+	}
+
+	entries, err := runSQLite(*dbPath, script)
+	if err != nil {
+		exitWithError(newCLIError(exitGeneric, "history", err), *errorFormat)
+	}
+
+	if subcommand == "show" {
+		if len(entries) == 0 {
+			exitWithError(newCLIError(exitInput, "input", fmt.Errorf("no history entry with id %s", fs.Arg(0))), *errorFormat)
+		}
+		e := entries[0]
+		if *outputFormat == "json" {
+			data, _ := json.MarshalIndent(e, "", "  ")
+			fmt.Println(string(data))
+			return
+		}
+		fmt.Printf("ID:     %d\nDate:   %s\nFile:   %s\nModel:  %s\nType:   %s\nSHA256: %s\n\n%s\n",
+			e.ID, e.CreatedAt, e.FileName, e.Model, e.Type, e.InputHash, e.Summary)
+		return
+	}
+	if *outputFormat == "json" {
+		data, _ := json.MarshalIndent(entries, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Println(formatHistoryList(entries))
+}
+
+// commandName es el nombre con el que se registran las completions y el man
+// page (el binario compilado, p. ej. "go build -o summarizer")
+const commandName = "summarizer"
+
+// subcommands son los subcomandos que se completan en la primera posición
+var subcommands = []string{"auth", "history", "config", "revisions", "completion", "docs"}
+
+// completionShells son los shells soportados por "completion"
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// flagCompletion describe cómo se completa el valor de un flag: una lista fija
+// de valores, archivos, directorios o una lista dinámica (modelos o presets)
+// que se consulta al completar con "completion __<Dynamic>", para incluir lo
+// definido en la configuración
+type flagCompletion struct {
+	Values  []string
+	Files   bool
+	Dirs    bool
+	Dynamic string
+}
+
+// flagCompletions asocia los flags con valores conocidos a su completion; el
+// resto de los flags que llevan valor acepta texto libre
+var flagCompletions = map[string]flagCompletion{
+	"type":           {Values: summaryTypes},
+	"t":              {Values: summaryTypes},
+	"format":         {Values: []string{"text", "json", "structured", "markdown"}},
+	"error-format":   {Values: []string{"text", "json"}},
+	"provider":       {Values: []string{defaultProvider, spacesProvider}},
+	"content-filter": {Values: []string{"off", "mask", "flag", "block"}},
+	"symlinks":       {Values: []string{"skip", "follow", "error"}},
+	"protocol":       {Values: []string{"jsonrpc"}},
+	"short-input":    {Values: []string{"passthrough", "notice"}},
+	"model":          {Dynamic: "models"},
+	"preset":         {Dynamic: "presets"},
+	"input":          {Files: true},
+	"output":         {Files: true},
+	"config":         {Files: true},
+	"filter-terms":   {Files: true},
+	"report":         {Files: true},
+	"run-report":     {Files: true},
+	"ca-bundle":      {Files: true},
+	"history-db":     {Files: true},
+	"output-dir":     {Dirs: true},
+}
+
+// cliFlag es un flag del comando principal tal como lo ven las completions
+type cliFlag struct {
+	Name     string
+	Usage    string
+	ValueArg string
+	Bool     bool
+}
+
+// collectFlags lista los flags definidos ordenados por nombre
+func collectFlags(fs *flag.FlagSet) []cliFlag {
+	var flags []cliFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		valueArg, usage := flag.UnquoteUsage(f)
+		isBool := false
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok {
+			isBool = b.IsBoolFlag()
+		}
+		if isBool {
+			valueArg = ""
+		} else if valueArg == "" {
+			valueArg = "value"
+		}
+		flags = append(flags, cliFlag{Name: f.Name, Usage: usage, ValueArg: valueArg, Bool: isBool})
+	})
+	return flags
+}
+
+// shortUsage recorta la ayuda de un flag a su primera frase para las
+// descripciones de las completions
+func shortUsage(usage string) string {
+	for _, sep := range []string{"; ", ". ", " (e.g."} {
+		if i := strings.Index(usage, sep); i > 0 {
+			usage = usage[:i]
+		}
+	}
+	return usage
+}
+
+// modelNames devuelve los alias del registro incluido y de la configuración
+func modelNames(cfg *Config) []string {
+	names := []string{defaultModel}
+	for name := range builtinModels {
+		names = append(names, name)
+	}
+	for name := range cfg.Models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return slices.Compact(names)
+}
+
+// runDocsCommand atiende "completion <shell>" y "docs man" a partir de los
+// flags ya definidos del comando principal, para que ambos sigan a la CLI
+func runDocsCommand(command string, args []string, fs *flag.FlagSet) {
+	setupLogging(0, false)
+	flags := collectFlags(fs)
+	topic := ""
+	if len(args) > 0 {
+		topic = args[0]
+	}
+
+	if command == "docs" {
+		if topic != "man" {
+			err := newCLIError(exitUsage, "usage", fmt.Errorf("unknown docs format '%s'", topic))
+			err.Hint = "Usage: go run solution_summarizer.go docs man > summarizer.1"
+			exitWithError(err, "text")
+		}
+		writeManPage(os.Stdout, flags)
+		return
+	}
+
+	switch topic {
+	case "bash":
+		writeBashCompletion(os.Stdout, flags)
+	case "zsh":
+		writeZshCompletion(os.Stdout, flags)
+	case "fish":
+		writeFishCompletion(os.Stdout, flags)
+	case "powershell":
+		writePowerShellCompletion(os.Stdout, flags)
+	// Usados por los scripts generados para completar --model y --preset
+	case "__models", "__presets":
+		configPath := ""
+		if len(args) > 1 {
+			configPath = args[1]
+		}
+		cfg, err := loadConfig(configPath)
+		if err != nil {
+			cfg = &Config{}
+		}
+		names := modelNames(cfg)
+		if topic == "__presets" {
+			names = names[:0]
+			for name := range cfg.Presets {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	default:
+		err := newCLIError(exitUsage, "usage", fmt.Errorf("unknown shell '%s'", topic))
+		err.Hint = "Usage: go run solution_summarizer.go completion <" + strings.Join(completionShells, "|") + ">"
+		exitWithError(err, "text")
+	}
+}
+
+// writeBashCompletion genera el script de completion para bash
+func writeBashCompletion(w io.Writer, flags []cliFlag) {
+	var names, valued []string
+	for _, f := range flags {
+		names = append(names, "--"+f.Name)
+		if !f.Bool {
+			valued = append(valued, "-"+f.Name+"|--"+f.Name)
+		}
+	}
+
+	fmt.Fprintf(w, "# bash completion for %s\n", commandName)
+	fmt.Fprintf(w, "# Install: %s completion bash > /etc/bash_completion.d/%s\n\n", commandName, commandName)
+	fmt.Fprintf(w, "_%s() {\n", commandName)
+	fmt.Fprintln(w, `    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+	fmt.Fprintln(w, `    case "$prev" in`)
+	for _, f := range flags {
+		c, ok := flagCompletions[f.Name]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "        -%s|--%s)\n", f.Name, f.Name)
+		switch {
+		case c.Dynamic != "":
+			fmt.Fprintf(w, "            COMPREPLY=($(compgen -W \"$(\"${COMP_WORDS[0]}\" completion __%s 2>/dev/null)\" -- \"$cur\"))\n", c.Dynamic)
+		case c.Dirs:
+			fmt.Fprintln(w, `            COMPREPLY=($(compgen -d -- "$cur"))`)
+		case c.Files:
+			fmt.Fprintln(w, `            COMPREPLY=($(compgen -f -- "$cur"))`)
+		default:
+			fmt.Fprintf(w, "            COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(c.Values, " "))
+		}
+		fmt.Fprintln(w, "            return ;;")
+	}
+	fmt.Fprintf(w, "        %s)\n            return ;;\n", strings.Join(valued, "|"))
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, `    if [[ $cur == -* ]]; then`)
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintln(w, "        return")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, `    if [[ $COMP_CWORD -eq 1 ]]; then`)
+	fmt.Fprintf(w, "        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(subcommands, " "))
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, `    COMPREPLY+=($(compgen -f -- "$cur"))`)
+	fmt.Fprintln(w, "}")
+	fmt.Fprintf(w, "complete -o filenames -F _%s %s\n", commandName, commandName)
+}
+
+// zshQuote escapa un texto para una especificación de _arguments entre
+// comillas simples
+func zshQuote(text string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(text)
+}
+
+// writeZshCompletion genera el script de completion para zsh
+func writeZshCompletion(w io.Writer, flags []cliFlag) {
+	fmt.Fprintf(w, "#compdef %s\n", commandName)
+	fmt.Fprintf(w, "# Install: %s completion zsh > \"${fpath[1]}/_%s\"\n\n", commandName, commandName)
+	fmt.Fprintf(w, "_%s_dynamic() {\n", commandName)
+	fmt.Fprintln(w, `    local -a items`)
+	fmt.Fprintln(w, `    items=(${(f)"$("${words[1]}" completion __$1 2>/dev/null)"})`)
+	fmt.Fprintln(w, `    _describe -t $1 $1 items`)
+	fmt.Fprint(w, "}\n\n")
+	fmt.Fprintf(w, "_%s_args() {\n", commandName)
+	fmt.Fprintln(w, "    if (( CURRENT == 2 )); then")
+	fmt.Fprintf(w, "        _alternative 'commands:command:(%s)' 'files:file:_files'\n", strings.Join(subcommands, " "))
+	fmt.Fprintln(w, "    else")
+	fmt.Fprintln(w, "        _files")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprint(w, "}\n\n")
+	fmt.Fprintf(w, "_%s() {\n", commandName)
+	fmt.Fprintln(w, "    _arguments \\")
+	for _, f := range flags {
+		spec := "--" + f.Name + "[" + zshQuote(shortUsage(f.Usage)) + "]"
+		if !f.Bool {
+			action := ""
+			c := flagCompletions[f.Name]
+			switch {
+			case c.Dynamic != "":
+				action = "_" + commandName + "_dynamic " + c.Dynamic
+			case c.Dirs:
+				action = "_files -/"
+			case c.Files:
+				action = "_files"
+			case len(c.Values) > 0:
+				action = "(" + strings.Join(c.Values, " ") + ")"
+			}
+			spec += ":" + zshQuote(f.ValueArg) + ":" + action
+		}
+		fmt.Fprintf(w, "        '%s' \\\n", spec)
+	}
+	fmt.Fprintf(w, "        '*: :_%s_args'\n", commandName)
+	fmt.Fprint(w, "}\n\n")
+	fmt.Fprintf(w, "_%s \"$@\"\n", commandName)
+}
+
+// writeFishCompletion genera el script de completion para fish
+func writeFishCompletion(w io.Writer, flags []cliFlag) {
+	quote := func(text string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(text) + "'"
+	}
+	fmt.Fprintf(w, "# fish completion for %s\n", commandName)
+	fmt.Fprintf(w, "# Install: %s completion fish > ~/.config/fish/completions/%s.fish\n\n", commandName, commandName)
+	fmt.Fprintf(w, "complete -c %s -n __fish_use_subcommand -a %s\n", commandName, quote(strings.Join(subcommands, " ")))
+	for _, f := range flags {
+		line := "complete -c " + commandName
+		if len(f.Name) == 1 {
+			line += " -s " + f.Name
+		} else {
+			line += " -l " + f.Name
+		}
+		if !f.Bool {
+			c := flagCompletions[f.Name]
+			switch {
+			case c.Dynamic != "":
+				line += " -x -a " + quote("(eval (commandline -opc)[1] completion __"+c.Dynamic+" 2>/dev/null)")
+			case c.Dirs:
+				line += " -x -a " + quote("(__fish_complete_directories)")
+			case c.Files:
+				line += " -r -F"
+			case len(c.Values) > 0:
+				line += " -x -a " + quote(strings.Join(c.Values, " "))
+			default:
+				line += " -x"
+			}
+		}
+		fmt.Fprintln(w, line+" -d "+quote(shortUsage(f.Usage)))
+	}
+}
+
+// writePowerShellCompletion genera el script de completion para PowerShell.
+// Si el bloque no devuelve candidatos, PowerShell completa rutas de archivos
+func writePowerShellCompletion(w io.Writer, flags []cliFlag) {
+	list := func(values []string) string {
+		quoted := make([]string, len(values))
+		for i, v := range values {
+			quoted[i] = "'" + strings.ReplaceAll(v, "'", "''") + "'"
+		}
+		return "@(" + strings.Join(quoted, ", ") + ")"
+	}
+	var names []string
+	for _, f := range flags {
+		names = append(names, "--"+f.Name)
+	}
+
+	fmt.Fprintf(w, "# PowerShell completion for %s\n", commandName)
+	fmt.Fprintf(w, "# Install: %s completion powershell | Out-String | Invoke-Expression (e.g. in $PROFILE)\n\n", commandName)
+	fmt.Fprintf(w, "Register-ArgumentCompleter -Native -CommandName %s -ScriptBlock {\n", commandName)
+	fmt.Fprintln(w, "    param($wordToComplete, $commandAst, $cursorPosition)")
+	fmt.Fprintf(w, "    $commands = %s\n", list(subcommands))
+	fmt.Fprintf(w, "    $flags = %s\n", list(names))
+	fmt.Fprintln(w, "    $values = @{")
+	for _, f := range flags {
+		if c := flagCompletions[f.Name]; len(c.Values) > 0 {
+			fmt.Fprintf(w, "        '--%s' = %s\n", f.Name, list(c.Values))
+		}
+	}
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "    $dynamic = @{")
+	for _, f := range flags {
+		if c := flagCompletions[f.Name]; c.Dynamic != "" {
+			fmt.Fprintf(w, "        '--%s' = '__%s'\n", f.Name, c.Dynamic)
+		}
+	}
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "    $elements = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })")
+	fmt.Fprintln(w, "    $prev = if ($wordToComplete) { $elements[-2] } else { $elements[-1] }")
+	fmt.Fprintln(w, "    $prev = $prev -replace '^--?', '--'")
+	fmt.Fprintln(w, "    $candidates = if ($dynamic.ContainsKey($prev)) {")
+	fmt.Fprintln(w, "        & $elements[0] completion $dynamic[$prev] 2>$null")
+	fmt.Fprintln(w, "    } elseif ($values.ContainsKey($prev)) {")
+	fmt.Fprintln(w, "        $values[$prev]")
+	fmt.Fprintln(w, "    } elseif ($wordToComplete -like '-*') {")
+	fmt.Fprintln(w, "        $flags")
+	fmt.Fprintln(w, "    } elseif ($elements.Count -le 2) {")
+	fmt.Fprintln(w, "        $commands")
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "    $candidates | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {")
+	fmt.Fprintln(w, "        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)")
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "}")
+}
+
+// roffEscape escapa un texto para una página de manual en formato roff
+func roffEscape(text string) string {
+	text = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(text)
+	if strings.HasPrefix(text, ".") || strings.HasPrefix(text, "'") {
+		text = `\&` + text
+	}
+	return text
+}
+
+// writeManPage genera la página de manual (sección 1) a partir de los flags
+func writeManPage(w io.Writer, flags []cliFlag) {
+	upper := strings.ToUpper(commandName)
+	fmt.Fprintf(w, ".TH %s 1 \"\" \"%s\" \"User Commands\"\n", upper, commandName)
+	fmt.Fprintln(w, ".SH NAME")
+	fmt.Fprintf(w, "%s \\- summarize text files with Hugging Face models\n", commandName)
+
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	synopsis := []string{
+		`[\fIflags\fR] \fIfile\fR`,
+		`[\fIflags\fR] \fB\-\-output\-dir\fR \fIdir\fR \fIfile\fR...`,
+		`\fBrevisions\fR [\fIflags\fR] \fIold\fR \fInew\fR`,
+		`\fBauth\fR \fBlogin\fR|\fBlogout\fR|\fBstatus\fR`,
+		`\fBhistory\fR \fBlist\fR|\fBshow\fR \fIid\fR|\fBsearch\fR \fIterm\fR [\fIflags\fR]`,
+		`\fBconfig\fR \fBimport\fR \fIurl\fR|\fIpath\fR [\fB\-\-force\fR]`,
+		`\fBcompletion\fR ` + strings.Join(completionShells, "|"),
+		`\fBdocs\fR \fBman\fR`,
+	}
+	for i, line := range synopsis {
+		if i > 0 {
+			fmt.Fprintln(w, ".br")
+		}
+		fmt.Fprintf(w, ".B %s\n%s\n", commandName, line)
+	}
+
+	fmt.Fprintln(w, ".SH DESCRIPTION")
+	fmt.Fprintf(w, "%s\n", roffEscape("Reads a text document (or a directory of documents, CSV or JSON Lines rows) "+
+		"and prints a summary generated by a Hugging Face model through the Inference Providers router, "+
+		"a dedicated Inference Endpoint or a Gradio app on a Space. Summary types: "+strings.Join(summaryTypes, ", ")+"."))
+
+	fmt.Fprintln(w, ".SH OPTIONS")
+	fmt.Fprintln(w, "Flags may be written with one or two dashes.")
+	for _, f := range flags {
+		fmt.Fprintln(w, ".TP")
+		if f.Bool {
+			fmt.Fprintf(w, "\\fB\\-\\-%s\\fR\n", roffEscape(f.Name))
+		} else {
+			fmt.Fprintf(w, "\\fB\\-\\-%s\\fR \\fI%s\\fR\n", roffEscape(f.Name), roffEscape(f.ValueArg))
+		}
+		fmt.Fprintln(w, roffEscape(f.Usage))
+	}
+
+	fmt.Fprintln(w, ".SH EXIT STATUS")
+	for _, status := range []struct {
+		code int
+		text string
+	}{
+		{0, "Success."},
+		{exitGeneric, "Unexpected error."},
+		{exitUsage, "Invalid flags or arguments."},
+		{exitAuth, "Missing or invalid API token."},
+		{exitInput, "The input could not be read."},
+		{exitAPI, "The API returned an error."},
+		{exitTimeout, "A request or the --deadline timed out."},
+		{exitContent, "The content filter blocked the output."},
+		{exitBudget, "The run would exceed a request budget."},
+	} {
+		fmt.Fprintf(w, ".TP\n.B %d\n%s\n", status.code, roffEscape(status.text))
+	}
+
+	fmt.Fprintln(w, ".SH ENVIRONMENT")
+	for _, env := range [][2]string{
+		{"HUGGINGFACE_API_TOKEN", "API token; takes precedence over the token stored with \"auth login\"."},
+		{"SLACK_BOT_TOKEN", "Bot token used by --slack-channel."},
+		{"HTTPS_PROXY, HTTP_PROXY", "Proxy used when --proxy is not given."},
+	} {
+		fmt.Fprintf(w, ".TP\n.B %s\n%s\n", env[0], roffEscape(env[1]))
+	}
+
+	fmt.Fprintln(w, ".SH FILES")
+	for _, file := range [][2]string{
+		{"<user config dir>/summarizer/config.json", "Configuration file (models, presets, retry, http, filter, budget and history sections)."},
+		{"<user config dir>/summarizer/templates/", "Report templates installed by \"config import\"; --report accepts their names."},
+		{"<user config dir>/summarizer/history.db", "SQLite history of the summaries."},
+		{"<user config dir>/summarizer/usage.json", "Daily usage counters of the request budget."},
+	} {
+		fmt.Fprintf(w, ".TP\n.I %s\n%s\n", roffEscape(file[0]), roffEscape(file[1]))
+	}
+}
+
+// tokenHelp son las instrucciones mostradas cuando falta el token de API
+const tokenHelp = `No se encontró el token de HuggingFace API
+
+Para usar esta herramienta, necesitas un token gratuito de HuggingFace:
+1. Ve a: https://huggingface.co/settings/tokens
+2. Crea un nuevo token (cuenta gratuita)
+3. Configura la variable de entorno:
+
+   PowerShell (sin comillas internas):
+   $env:HUGGINGFACE_API_TOKEN = "tu_token_aqui"
+
+   PowerShell (con comillas simples):
+   $env:HUGGINGFACE_API_TOKEN = 'tu_token_aqui'
+
+   CMD:
+   set HUGGINGFACE_API_TOKEN=tu_token_aqui
+
+   Linux/Mac:
+   export HUGGINGFACE_API_TOKEN=tu_token_aqui
+
+4. Verifica con: echo $env:HUGGINGFACE_API_TOKEN
+
+Para no dejar el token en el historial del shell, guárdalo en el llavero del sistema:
+   go run solution_summarizer.go auth login`
+
+// Códigos de salida del proceso, para que los scripts puedan distinguir la causa del fallo
+const (
+	exitGeneric = 1
+	exitUsage   = 2
+	exitAuth    = 3
+	exitInput   = 4
+	exitAPI     = 5
+	exitTimeout = 6
+	// exitContent indica que el filtro de contenido bloqueó la salida
+	exitContent = 7
+	// exitBudget indica que la ejecución superaría un límite de uso
+	exitBudget = 8
+)
+
+// CLIError asocia un error con su categoría y el código de salida correspondiente
+type CLIError struct {
+	Code     int
+	Category string
+	Err      error
+	// Hint es ayuda adicional que solo se muestra en el formato de texto
+	Hint string
+}
+
+func newCLIError(code int, category string, err error) *CLIError {
+	return &CLIError{Code: code, Category: category, Err: err}
+}
+
+func (e *CLIError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CLIError) Unwrap() error {
+	return e.Err
+}
+
+// classifyError determina la categoría y el código de salida de un error.
+// Los errores ya clasificados se respetan; el resto se deduce de su causa
+func classifyError(err error) *CLIError {
+	var cliErr *CLIError
+	if errors.As(err, &cliErr) {
+		return cliErr
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return newCLIError(exitTimeout, "timeout", err)
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden {
+			return newCLIError(exitAuth, "auth", err)
+		}
+		return newCLIError(exitAPI, "api", err)
+	}
+
+	if errors.As(err, &netErr) {
+		return newCLIError(exitAPI, "api", err)
+	}
+
+	return newCLIError(exitGeneric, "internal", err)
+}
+
+// exitHooks se ejecutan antes de terminar el proceso, también cuando la
+// ejecución termina con error (por ejemplo, para mostrar --stats). heldLocks
+// son los bloqueos tomados y aún no liberados, por ruta: cada bloqueo se quita
+// al liberarse, así que --tui o --protocol jsonrpc no acumulan uno por petición.
+// exitHooksMu los protege porque --compare y el worker de --tui toman bloqueos
+// desde otras goroutines
+var (
+	exitHooksMu sync.Mutex
+	exitHooks   []func()
+	heldLocks   = map[string]*fileLock{}
+)
+
+// addExitHook registra una función para ejecutar antes de terminar el proceso
+func addExitHook(hook func()) {
+	exitHooksMu.Lock()
+	exitHooks = append(exitHooks, hook)
+	exitHooksMu.Unlock()
+}
+
+func runExitHooks() {
+	exitHooksMu.Lock()
+	hooks := exitHooks
+	exitHooks = nil
+	locks := make([]*fileLock, 0, len(heldLocks))
+	for _, lock := range heldLocks {
+		locks = append(locks, lock)
+	}
+	exitHooksMu.Unlock()
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+	for _, lock := range locks {
+		lock.release()
+	}
+}
+
+// exitWithError informa el error en stderr con el formato pedido y termina el
+// proceso con el código de salida de su categoría
+func exitWithError(err error, format string) {
+	cliErr := classifyError(err)
+
+	if format == "json" {
+		payload := map[string]interface{}{
+			"category":  cliErr.Category,
+			"exit_code": cliErr.Code,
+			"message":   cliErr.Error(),
+		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			payload["status_code"] = apiErr.StatusCode
+		}
+		data, _ := json.Marshal(map[string]interface{}{"error": payload})
+		fmt.Fprintln(os.Stderr, string(data))
+	} else {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", cliErr)
+		if cliErr.Hint != "" {
+			fmt.Fprintln(os.Stderr, "")
+			fmt.Fprintln(os.Stderr, cliErr.Hint)
+		}
+	}
+
+	runExitHooks()
+	os.Exit(cliErr.Code)
+}
+
+// Config representa el archivo de configuración opcional en formato JSON.
+// Los campos ausentes conservan los valores por defecto
+type Config struct {
+	Retry RetryConfig `json:"retry"`
+	// Model es el modelo (ID o alias) usado cuando no se indica --model
+	Model string `json:"model,omitempty"`
+	// Models define alias de modelos, opcionalmente con su propio endpoint
+	Models map[string]ModelConfig `json:"models,omitempty"`
+	HTTP   HTTPConfig             `json:"http"`
+	// History configura el historial de resúmenes en SQLite
+	History HistoryConfig `json:"history"`
+	// Filter configura el filtro de contenido de la salida
+	Filter FilterConfig `json:"filter"`
+	// Budget limita las solicitudes por ejecución y por día
+	Budget BudgetConfig `json:"budget"`
+	// Presets son tipos de resumen con su propia instrucción (--preset)
+	Presets map[string]PresetConfig `json:"presets,omitempty"`
+	// Outputs son destinos adicionales para el resumen de un solo documento
+	Outputs []OutputConfig `json:"outputs,omitempty"`
+	// Input configura las entradas muy cortas (--min-input-words, --short-input)
+	Input InputConfig `json:"input"`
+}
+
+// InputConfig es la sección "input" del archivo de configuración
+type InputConfig struct {
+	MinWords   int    `json:"min_words"`
+	ShortInput string `json:"short_input"`
+}
+
+// OutputConfig es un destino de la sección "outputs": exactamente uno de
+// File, Webhook o SlackChannel, con su propio formato
+type OutputConfig struct {
+	File         string `json:"file,omitempty"`
+	Webhook      string `json:"webhook,omitempty"`
+	SlackChannel string `json:"slack_channel,omitempty"`
+	// Format es text, json, structured o markdown (por defecto, el de --format)
+	Format string `json:"format,omitempty"`
+}
+
+// String describe el destino en los mensajes
+func (o OutputConfig) String() string {
+	switch {
+	case o.File != "":
+		return "file " + o.File
+	case o.Webhook != "":
+		return "webhook " + o.Webhook
+	default:
+		return "Slack channel " + o.SlackChannel
+	}
+}
+
+// validate comprueba el destino número i y completa su formato. Los formatos
+// tienen las mismas restricciones que --format con las opciones de la ejecución
+func (o *OutputConfig) validate(i int, render renderOptions) error {
+	set := 0
+	for _, target := range []string{o.File, o.Webhook, o.SlackChannel} {
+		if target != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("outputs[%d]: set exactly one of \"file\", \"webhook\" or \"slack_channel\"", i)
+	}
+	if o.Webhook != "" {
+		if u, err := url.Parse(o.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("outputs[%d]: invalid webhook '%s': must be an http(s) URL", i, o.Webhook)
+		}
+	}
+	o.Format = strings.ToLower(o.Format)
+	if o.Format == "" {
+		o.Format = render.Format
+	}
+	switch {
+	case o.Format != "text" && o.Format != "json" && o.Format != "structured" && o.Format != "markdown":
+		return fmt.Errorf("outputs[%d]: invalid format '%s'. Must be: text, json, structured or markdown", i, o.Format)
+	case render.Report != nil && o.Format != "text":
+		return fmt.Errorf("outputs[%d]: --report writes the template as is; use format text", i)
+	case len(render.Tasks) > 0 && o.Format != "text" && o.Format != "json":
+		return fmt.Errorf("outputs[%d]: --tasks supports format text or json", i)
+	case o.Format == "markdown" && !render.Hierarchical:
+		return fmt.Errorf("outputs[%d]: format markdown requires --hierarchical", i)
+	case o.Format == "structured" && render.Hierarchical:
+		return fmt.Errorf("outputs[%d]: --hierarchical supports format text, json or markdown", i)
+	}
+	return nil
+}
+
+// deliverOutputs entrega el resumen de input a cada destino de la sección
+// "outputs". render da al resumen el formato de cada destino; con la memoria
+// de resúmenes del cliente no repite solicitudes al modelo. Los errores de
+// escritura de archivos se devuelven; los de webhooks y Slack solo se
+// advierten, igual que en --notify-webhook
+func deliverOutputs(outputs []OutputConfig, input string, render func(format string) (string, error), notify *notifier, policy outputPolicy) error {
+	if abs, err := filepath.Abs(input); err == nil {
+		input = abs
+	}
+	rendered := map[string]string{}
+	var errs []error
+	for _, out := range outputs {
+		text, ok := rendered[out.Format]
+		if !ok {
+			var err error
+			if text, err = render(out.Format); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", out, err))
+				continue
+			}
+			rendered[out.Format] = text
+		}
+		switch {
+		case out.File != "":
+			path, err := fsPath(out.File)
+			if err == nil {
+				err = writeFileAtomic(path, []byte(text+"\n"), policy)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", out, err))
+				continue
+			}
+			slog.Info("Summary written", "file", out.File, "format", out.Format)
+		case out.Webhook != "":
+			notify.postWebhook(out.Webhook, input, "", text, out.Format)
+		default:
+			notify.postSlack(out.SlackChannel, input, text)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PresetConfig es un preset de la sección "presets": un tipo de resumen base
+// con una instrucción propia para el modelo y, opcionalmente, su longitud y la
+// plantilla de --report
+type PresetConfig struct {
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type"`
+	// Prompt reemplaza la instrucción de buildPrompt para Type; el texto del
+	// documento se agrega a continuación
+	Prompt   string `json:"prompt,omitempty"`
+	MaxWords int    `json:"max_words,omitempty"`
+	MinWords int    `json:"min_words,omitempty"`
+	// Template es una plantilla de --report (ruta o nombre de una instalada)
+	Template string `json:"template,omitempty"`
+}
+
+func (p PresetConfig) validate(name string) error {
+	if !isValidSummaryType(p.Type) {
+		return fmt.Errorf("preset '%s' has invalid type '%s'. Must be one of: %s", name, p.Type, strings.Join(summaryTypes, ", "))
+	}
+	if p.MaxWords < 0 || p.MinWords < 0 || (p.MaxWords > 0 && p.MinWords > p.MaxWords) {
+		return fmt.Errorf("preset '%s' has invalid max_words/min_words", name)
+	}
+	return nil
+}
+
+// BudgetConfig es la sección "budget" del archivo de configuración. Los
+// límites en cero no se aplican
+type BudgetConfig struct {
+	MaxRequests     int `json:"max_requests"`
+	MaxInputChars   int `json:"max_input_chars"`
+	DailyRequests   int `json:"daily_requests"`
+	DailyInputChars int `json:"daily_input_chars"`
+	// UsagePath es el archivo con el contador diario (por defecto usage.json
+	// junto al archivo de configuración)
+	UsagePath string `json:"usage_path"`
+}
+
+// FilterConfig es la sección "filter" del archivo de configuración
+type FilterConfig struct {
+	Mode string `json:"mode"`
+	// Terms se agregan a la lista incorporada de términos filtrados
+	Terms     []string `json:"terms"`
+	TermsFile string   `json:"terms_file"`
+}
+
+// HistoryConfig es la sección "history" del archivo de configuración
+type HistoryConfig struct {
+	Path     string `json:"path"`
+	Disabled bool   `json:"disabled"`
+}
+
+// HTTPConfig es la sección "http" del archivo de configuración
+type HTTPConfig struct {
+	Proxy              string           `json:"proxy"`
+	CABundle           string           `json:"ca_bundle"`
+	InsecureSkipVerify bool             `json:"insecure_skip_verify"`
+	Timeout            optionalDuration `json:"timeout"`
+	ConnectTimeout     optionalDuration `json:"connect_timeout"`
+}
+
+// ModelConfig describe un modelo del registro: su ID en el Hub y, opcionalmente,
+// el proveedor del router o la URL de un Inference Endpoint dedicado
+type ModelConfig struct {
+	ID       string `json:"id"`
+	Provider string `json:"provider,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+	// APIName es el endpoint de la app Gradio con el proveedor "spaces"
+	APIName string `json:"api_name,omitempty"`
+	// Chat marca un modelo instruido (text-generation) en hf-inference o en un
+	// endpoint dedicado; los demás proveedores del router ya se tratan así
+	Chat bool `json:"chat,omitempty"`
+}
+
+// RetryConfig es la sección "retry" del archivo de configuración
+type RetryConfig struct {
+	MaxRetries optionalInt      `json:"max_retries"`
+	BaseDelay  optionalDuration `json:"base_delay"`
+	MaxDelay   optionalDuration `json:"max_delay"`
+	Jitter     optionalFloat    `json:"jitter"`
+}
+
+// optionalInt distingue un entero ausente en la configuración de un cero explícito
+type optionalInt struct {
+	Value int
+	Set   bool
+}
+
+func (o *optionalInt) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	return json.Unmarshal(data, &o.Value)
+}
+
+func (o optionalInt) orDefault(def int) int {
+	if o.Set {
+		return o.Value
+	}
+	return def
+}
+
+// optionalFloat distingue un número ausente en la configuración de un cero explícito
+type optionalFloat struct {
+	Value float64
+	Set   bool
+}
+
+func (o *optionalFloat) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	return json.Unmarshal(data, &o.Value)
+}
+
+func (o optionalFloat) orDefault(def float64) float64 {
+	if o.Set {
+		return o.Value
+	}
+	return def
+}
+
+// optionalDuration acepta duraciones con la sintaxis de Go ("1.5s", "500ms")
+type optionalDuration struct {
+	Value time.Duration
+	Set   bool
+}
+
+func (o *optionalDuration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be a string like \"2s\": %w", err)
+	}
+	d, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	o.Value, o.Set = d, true
+	return nil
+}
+
+func (o optionalDuration) orDefault(def time.Duration) time.Duration {
+	if o.Set {
+		return o.Value
+	}
+	return def
+}
+
+// defaultConfigPath devuelve la ubicación estándar del archivo de configuración
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "summarizer", "config.json")
+}
+
+// loadConfig lee el archivo de configuración. Si no se indicó una ruta y el
+// archivo por defecto no existe, se devuelve una configuración vacía
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+		if path == "" {
+			return cfg, nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file '%s': %w", path, err)
+	}
+	slog.Debug("Loaded config file", "path", path)
+	return cfg, nil
+}
+
+// configBundle es un paquete compartible de configuración ("legal team pack"):
+// alias de modelos, presets de prompts y plantillas de --report
+type configBundle struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description,omitempty"`
+	Models      map[string]json.RawMessage `json:"models,omitempty"`
+	Presets     map[string]json.RawMessage `json:"presets,omitempty"`
+	// Templates asocia el nombre del archivo con el contenido de la plantilla
+	Templates map[string]string `json:"templates,omitempty"`
+}
+
+// maxBundleSize limita el tamaño de un paquete descargado
+const maxBundleSize = 5 << 20
+
+// templatesDir es el directorio de las plantillas instaladas, junto al archivo
+// de configuración
+func templatesDir(configPath string) string {
+	if configPath == "" {
+		configPath = defaultConfigPath()
+	}
+	return filepath.Join(filepath.Dir(configPath), "templates")
+}
+
+// resolveReportPath busca una plantilla de --report: la ruta indicada o, si es
+// solo un nombre que no existe, una plantilla instalada con "config import"
+func resolveReportPath(path, configPath string) string {
+	if _, err := os.Stat(path); err == nil || strings.ContainsAny(path, `/\`) {
+		return path
+	}
+	installed := filepath.Join(templatesDir(configPath), path)
+	if _, err := os.Stat(installed); err == nil {
+		return installed
+	}
+	return path
+}
+
+// runConfigCommand atiende "config import <url|ruta>": instala un paquete de
+// configuración en el archivo de configuración y sus plantillas al lado
+func runConfigCommand(args []string) {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	errorFormat := fs.String("error-format", "text", "Error output format on stderr: text or json")
+	configPath := fs.String("config", "", "Config file to install the bundle into (default: <user config dir>/summarizer/config.json)")
+	force := fs.Bool("force", false, "Replace existing models, presets and templates with the bundle's")
+	allowEndpoints := fs.Bool("allow-endpoints", false, "Accept models with a custom endpoint from a downloaded bundle (the HuggingFace token is sent to them)")
+	lockTimeout := fs.Duration("lock-timeout", defaultLockTimeout, "How long to wait for another process writing the config file")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: go run solution_summarizer.go config import <url|path> [flags]")
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 || args[0] != "import" {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	fs.Parse(args[1:])
+	setupLogging(0, false)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	source := fs.Arg(0)
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		exitWithError(newCLIError(exitUsage, "usage", err), *errorFormat)
+	}
+	path := *configPath
+	if path == "" {
+		if path = defaultConfigPath(); path == "" {
+			exitWithError(newCLIError(exitGeneric, "config", fmt.Errorf("cannot determine the user config directory; use --config")), *errorFormat)
+		}
+	}
+
+	data, err := readBundle(source, cfg)
+	if err != nil {
+		exitWithError(newCLIError(exitInput, "input", err), *errorFormat)
+	}
+	var bundle configBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		exitWithError(newCLIError(exitInput, "input", fmt.Errorf("invalid bundle '%s': %w", source, err)), *errorFormat)
+	}
+	if err := bundle.validate(); err != nil {
+		exitWithError(newCLIError(exitInput, "input", fmt.Errorf("invalid bundle '%s': %w", source, err)), *errorFormat)
+	}
+	// Un paquete descargado no puede desviar el token a otro host sin que el
+	// usuario lo acepte explícitamente y vea a qué hosts se enviará
+	if endpoints := bundle.endpoints(); len(endpoints) > 0 && isRemoteBundle(source) {
+		if !*allowEndpoints {
+			err := newCLIError(exitInput, "input", fmt.Errorf("bundle '%s' sets custom endpoints that would receive your HuggingFace token: %s", source, strings.Join(endpoints, ", ")))
+			err.Hint = "Review the hosts and re-run with --allow-endpoints to accept them"
+			exitWithError(err, *errorFormat)
+		}
+		fmt.Fprintf(os.Stderr, "The bundle adds models with custom endpoints; your HuggingFace token will be sent to: %s\n", strings.Join(endpoints, ", "))
+	}
+
+	lock, err := acquireLock(path, *lockTimeout)
+	if err != nil {
+		exitWithError(err, *errorFormat)
+	}
+	defer lock.release()
+	summary, err := bundle.install(path, *force)
+	if err != nil {
+		lock.release()
+		exitWithError(err, *errorFormat)
+	}
+	name := bundle.Name
+	if name == "" {
+		name = filepath.Base(source)
+	}
+	fmt.Printf("Imported %s into %s: %s\n", name, path, summary)
+}
+
+// readBundle lee el paquete de un archivo local o lo descarga por HTTP(S). El
+// token de HuggingFace no se envía: el paquete puede venir de cualquier sitio
+func readBundle(source string, cfg *Config) ([]byte, error) {
+	if !isRemoteBundle(source) {
+		data, err := readFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("reading bundle '%s': %w", source, err)
+		}
+		return []byte(data), nil
+	}
+	httpClient, err := buildHTTPClient(HTTPOptions{
+		Proxy:              cfg.HTTP.Proxy,
+		CABundle:           cfg.HTTP.CABundle,
+		InsecureSkipVerify: cfg.HTTP.InsecureSkipVerify,
+		Timeout:            cfg.HTTP.Timeout.orDefault(defaultRequestTimeout),
+		ConnectTimeout:     cfg.HTTP.ConnectTimeout.orDefault(defaultConnectTimeout),
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Get(source)
+	if err != nil {
+		return nil, fmt.Errorf("downloading bundle: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading bundle: %s returned %s", source, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBundleSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("downloading bundle: %w", err)
+	}
+	if len(data) > maxBundleSize {
+		return nil, fmt.Errorf("bundle is larger than %d bytes", maxBundleSize)
+	}
+	return data, nil
+}
+
+// isRemoteBundle indica si el paquete se descarga por HTTP(S)
+func isRemoteBundle(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// endpoints lista, ordenados, los hosts de los modelos del paquete con
+// endpoint propio ("host (alias)"): a ellos se enviaría el token
+func (b *configBundle) endpoints() []string {
+	var hosts []string
+	for name, raw := range b.Models {
+		var model ModelConfig
+		if err := json.Unmarshal(raw, &model); err != nil || model.Endpoint == "" {
+			continue
+		}
+		host := model.Endpoint
+		if u, err := url.Parse(model.Endpoint); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		hosts = append(hosts, fmt.Sprintf("%s (%s)", host, name))
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// validate comprueba el paquete antes de tocar la configuración: modelos con
+// ID, presets con un tipo válido y plantillas con nombre de archivo simple que
+// se puedan compilar
+func (b *configBundle) validate() error {
+	if len(b.Models) == 0 && len(b.Presets) == 0 && len(b.Templates) == 0 {
+		return fmt.Errorf("no models, presets or templates")
+	}
+	for name, raw := range b.Models {
+		var model ModelConfig
+		if err := json.Unmarshal(raw, &model); err != nil || model.ID == "" {
+			return fmt.Errorf("model '%s' needs an id", name)
+		}
+		if model.Endpoint != "" {
+			if u, err := url.Parse(model.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("model '%s' has an invalid endpoint URL '%s'", name, model.Endpoint)
+			}
+		}
+	}
+	for name, raw := range b.Presets {
+		var preset PresetConfig
+		if err := json.Unmarshal(raw, &preset); err != nil {
+			return fmt.Errorf("preset '%s': %w", name, err)
+		}
+		if err := preset.validate(name); err != nil {
+			return err
+		}
+		if preset.Template != "" {
+			if _, ok := b.Templates[preset.Template]; !ok {
+				slog.Warn("Preset template is not in the bundle", "preset", name, "template", preset.Template)
+			}
+		}
+	}
+	for name, text := range b.Templates {
+		if name == "" || name != filepath.Base(name) || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid template name '%s'", name)
+		}
+		if _, err := parseReportTemplate(name, text); err != nil {
+			return fmt.Errorf("template '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+// install agrega el paquete al archivo de configuración (conservando el resto
+// de sus secciones) y escribe las plantillas. Las entradas existentes distintas
+// solo se reemplazan con force. Devuelve un resumen de lo instalado
+func (b *configBundle) install(path string, force bool) (string, error) {
+	raw := map[string]json.RawMessage{}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return "", fmt.Errorf("invalid config file '%s': %w", path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return "", fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var notes []string
+	for _, section := range []struct {
+		name    string
+		entries map[string]json.RawMessage
+	}{{"models", b.Models}, {"presets", b.Presets}} {
+		added, kept, err := mergeSection(raw, section.name, section.entries, force)
+		if err != nil {
+			return "", err
+		}
+		if added > 0 || kept > 0 {
+			notes = append(notes, fmt.Sprintf("%d %s", added, section.name))
+		}
+		if kept > 0 {
+			notes[len(notes)-1] += fmt.Sprintf(" (%d existing kept, use --force to replace)", kept)
+		}
+	}
+
+	if len(b.Templates) > 0 {
+		dir := templatesDir(path)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return "", fmt.Errorf("creating templates directory: %w", err)
+		}
+		names := make([]string, 0, len(b.Templates))
+		for name := range b.Templates {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		added, kept := 0, 0
+		for _, name := range names {
+			target := filepath.Join(dir, name)
+			if existing, err := os.ReadFile(target); err == nil && !force {
+				if string(existing) != b.Templates[name] {
+					slog.Warn("Keeping existing template", "file", target)
+					kept++
+				}
+				continue
+			}
+			if err := writeFileAtomic(target, []byte(b.Templates[name]), outputPolicy{}); err != nil {
+				return "", fmt.Errorf("writing template: %w", err)
+			}
+			added++
+		}
+		if added > 0 || kept > 0 {
+			note := fmt.Sprintf("%d templates in %s", added, dir)
+			if kept > 0 {
+				note += fmt.Sprintf(" (%d existing kept, use --force to replace)", kept)
+			}
+			notes = append(notes, note)
+		}
+	}
+	if len(notes) == 0 {
+		return "already installed, nothing changed", nil
+	}
+
+	out, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("encoding config file: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("creating config directory: %w", err)
+	}
+	if err := writeFileAtomic(path, append(out, '\n'), outputPolicy{}); err != nil {
+		return "", fmt.Errorf("writing config file: %w", err)
+	}
+	return strings.Join(notes, ", "), nil
+}
+
+// mergeSection agrega las entradas de un paquete a una sección (un objeto JSON)
+// de la configuración. Devuelve cuántas se agregaron o reemplazaron y cuántas
+// distintas se conservaron por no indicar force
+func mergeSection(raw map[string]json.RawMessage, section string, entries map[string]json.RawMessage, force bool) (int, int, error) {
+	if len(entries) == 0 {
+		return 0, 0, nil
+	}
+	current := map[string]json.RawMessage{}
+	if data, ok := raw[section]; ok && string(data) != "null" {
+		if err := json.Unmarshal(data, &current); err != nil {
+			return 0, 0, fmt.Errorf("invalid \"%s\" section in the config file: %w", section, err)
+		}
+	}
+	added, kept := 0, 0
+	for name, entry := range entries {
+		if existing, ok := current[name]; ok && !force {
+			if !jsonEqual(existing, entry) {
+				slog.Warn("Keeping existing entry", "section", section, "name", name)
+				kept++
+			}
+			continue
+		}
+		current[name] = entry
+		added++
+	}
+	data, err := json.Marshal(current)
+	if err != nil {
+		return 0, 0, err
+	}
+	raw[section] = data
+	return added, kept, nil
+}
+
+// jsonEqual compara dos valores JSON sin tener en cuenta el formato
+func jsonEqual(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	// json.Marshal ordena las claves de los objetos
+	ca, _ := json.Marshal(va)
+	cb, _ := json.Marshal(vb)
+	return bytes.Equal(ca, cb)
+}
+
+// RetryPolicy define cuántas veces y con qué espera se reintentan las solicitudes
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Jitter     float64
+}
+
+func (p RetryPolicy) validate() error {
+	if p.MaxRetries < 0 {
+		return fmt.Errorf("max retries must be zero or positive, got %d", p.MaxRetries)
+	}
+	if p.BaseDelay <= 0 || p.MaxDelay <= 0 {
+		return fmt.Errorf("retry delays must be positive")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("retry jitter must be between 0 and 1, got %g", p.Jitter)
+	}
+	return nil
+}
+
+// Delay calcula la espera antes del reintento número retry (empezando en 1):
+// backoff exponencial a partir de BaseDelay, acotado por MaxDelay y con jitter
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	delay := p.MaxDelay
+	if shift := retry - 1; shift < 32 {
+		if d := p.BaseDelay * time.Duration(1<<uint(shift)); d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+	if p.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + p.Jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
+
+// readFile lee todo el contenido de un archivo de texto
+func readFile(filePath string) (string, error) {
+	filePath, err := fsPath(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	// Verificar si el archivo existe
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return "", fmt.Errorf("file does not exist: %s", filePath)
+	}
+
+	// Leer contenido del archivo
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	// Convertir a UTF-8 y normalizar saltos de línea, espacios y caracteres invisibles
+	text, encoding := decodeText(data)
+	if encoding != "utf-8" {
+		slog.Info("Input converted to UTF-8", "file", filePath, "encoding", encoding)
+	}
+
+	// Asegurar que el archivo no esté vacío
+	content := strings.TrimSpace(normalizeText(text))
+	if content == "" {
+		return "", fmt.Errorf("file is empty")
+	}
+
+	return content, nil
+}
+
+// windowsReservedNames son nombres de dispositivo que Windows no permite como
+// nombre de archivo, con cualquier extensión ("nul.txt" también es NUL)
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM0": true, "COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT0": true, "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+	"COM¹": true, "COM²": true, "COM³": true, "LPT¹": true, "LPT²": true, "LPT³": true,
+}
+
+// windowsMaxPath es la longitud desde la que Windows exige el prefijo \\?\
+// (MAX_PATH de 260 menos los 12 caracteres reservados para un nombre 8.3)
+const windowsMaxPath = 248
+
+// fsPath prepara una ruta de entrada o salida para el sistema de archivos. En
+// Windows rechaza los nombres reservados (CON, NUL, COM1...) y los componentes
+// terminados en punto o espacio, que Windows recorta en silencio, y convierte
+// las rutas largas a la forma extendida: \\?\C:\... o \\?\UNC\servidor\recurso\...
+// El resto de los sistemas no tiene estas restricciones
+func fsPath(path string) (string, error) {
+	if runtime.GOOS != "windows" {
+		return path, nil
+	}
+	return windowsPath(path)
+}
+
+// windowsPath implementa fsPath para las reglas de nombres de Windows
+func windowsPath(path string) (string, error) {
+	// Las rutas extendidas (\\?\) y de dispositivo (\\.\) se usan tal cual
+	if strings.HasPrefix(path, `\\?\`) || strings.HasPrefix(path, `\\.\`) {
+		return path, nil
+	}
+
+	components := strings.FieldsFunc(path, func(r rune) bool { return r == '\\' || r == '/' })
+	for i, name := range components {
+		if i == 0 && len(name) >= 2 && name[1] == ':' {
+			name = name[2:] // letra de unidad
+		}
+		if name == "" || name == "." || name == ".." {
+			continue
+		}
+		if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+			return "", fmt.Errorf("invalid Windows file name %q: names cannot end with a dot or a space", name)
+		}
+		if strings.ContainsAny(name, `<>:"|?*`) {
+			return "", fmt.Errorf("invalid Windows file name %q: names cannot contain any of <>:\"|?*", name)
+		}
+		stem := strings.ToUpper(strings.TrimRight(strings.SplitN(name, ".", 2)[0], " "))
+		if windowsReservedNames[stem] {
+			return "", fmt.Errorf("invalid Windows file name %q: %s is a reserved device name", name, stem)
+		}
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil || len(abs) < windowsMaxPath {
+		return path, nil
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:], nil
+	}
+	return `\\?\` + abs, nil
+}
+
+// windows1252 son los caracteres de 0x80-0x9F en Windows-1252; el resto de
+// los bytes coincide con Latin-1 y con los primeros 256 puntos de código Unicode
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// decodeText detecta la codificación (BOM, UTF-16 sin BOM, UTF-8 o, si no es
+// UTF-8 válido, Windows-1252/Latin-1) y devuelve el texto en UTF-8
+func decodeText(data []byte) (string, string) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		data = data[3:]
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return decodeUTF16(data[2:], false), "utf-16le"
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return decodeUTF16(data[2:], true), "utf-16be"
+	}
+
+	// Sin BOM, el texto UTF-16 en alfabeto latino tiene un byte nulo en casi
+	// todas las posiciones pares (big endian) o impares (little endian)
+	if len(data) >= 4 {
+		evenNulls, oddNulls := 0, 0
+		for i, c := range data {
+			if c == 0 {
+				if i%2 == 0 {
+					evenNulls++
+				} else {
+					oddNulls++
+				}
+			}
+		}
+		half := len(data) / 2
+		switch {
+		case oddNulls > half*3/4 && evenNulls < half/10:
+			return decodeUTF16(data, false), "utf-16le"
+		case evenNulls > half*3/4 && oddNulls < half/10:
+			return decodeUTF16(data, true), "utf-16be"
+		}
+	}
+
+	if utf8.Valid(data) {
+		return string(data), "utf-8"
+	}
+	runes := make([]rune, len(data))
+	for i, c := range data {
+		if c >= 0x80 && c <= 0x9F {
+			runes[i] = windows1252[c-0x80]
+		} else {
+			runes[i] = rune(c)
+		}
+	}
+	return string(runes), "windows-1252"
+}
+
+// decodeUTF16 convierte UTF-16 a texto UTF-8 (un byte final impar se descarta)
+func decodeUTF16(data []byte, bigEndian bool) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// normalizeText unifica los saltos de línea (CRLF y CR), elimina caracteres
+// invisibles (de ancho cero, guiones suaves, controles), convierte los espacios
+// especiales en espacios comunes y colapsa espacios y líneas en blanco repetidos.
+// La sangría inicial de cada línea se conserva
+func normalizeText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	var b strings.Builder
+	b.Grow(len(text))
+	blankLines := 0
+	for _, line := range strings.Split(text, "\n") {
+		var l strings.Builder
+		indent, pendingSpace := true, false
+		for _, r := range line {
+			switch {
+			case r == '\u200B' || r == '\u200C' || r == '\u200D' || r == '\u2060' || r == '\uFEFF' || r == '\u00AD':
+				continue
+			case r == '\t' && indent:
+				l.WriteRune(r)
+			case unicode.IsSpace(r):
+				if indent {
+					l.WriteRune(' ')
+				} else {
+					pendingSpace = true
+				}
+			case unicode.IsControl(r) || r == utf8.RuneError:
+				continue
+			default:
+				if pendingSpace {
+					l.WriteRune(' ')
+					pendingSpace = false
+				}
+				indent = false
+				l.WriteRune(r)
+			}
+		}
+		normalized := strings.TrimRight(l.String(), " \t")
+		if normalized == "" {
+			blankLines++
+			if blankLines > 1 {
+				continue
+			}
+		} else {
+			blankLines = 0
+		}
+		b.WriteString(normalized)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// APIClient agrupa lo necesario para llamar al modelo: token, destino,
+// política de reintentos y cliente HTTP
+type APIClient struct {
+	Token   *tokenLookup
+	Target  ModelTarget
+	Retry   RetryPolicy
+	HTTP    *http.Client
+	Metrics *RunMetrics
+	Length  LengthOptions
+	// Deadline, si no es cero, limita todas las solicitudes (--deadline)
+	Deadline time.Time
+	// Budget, si no es nil, se consulta antes de cada solicitud
+	Budget *requestBudget
+	// Prompts reemplaza la instrucción de buildPrompt por tipo (--preset)
+	Prompts map[string]string
+	// Memo, si no es nil, reutiliza los resúmenes ya generados (sección "outputs")
+	Memo *summaryMemo
+	// ShortInput evita llamar al modelo con entradas muy cortas (--min-input-words)
+	ShortInput shortInputGuard
+}
+
+// shortInputGuard decide qué hacer con las entradas demasiado cortas para que
+// un resumen tenga sentido: devolverlas tal cual (passthrough) o un aviso
+// (notice). Con MinWords en cero no interviene
+type shortInputGuard struct {
+	MinWords int
+	Mode     string
+}
+
+// short indica si el texto tiene menos palabras que el mínimo, y cuántas tiene
+func (g shortInputGuard) short(text string) (int, bool) {
+	if g.MinWords <= 0 {
+		return 0, false
+	}
+	words := len(strings.Fields(text))
+	return words, words < g.MinWords
+}
+
+// check devuelve la salida que reemplaza al resumen si el texto es demasiado corto
+func (g shortInputGuard) check(text string) (string, bool) {
+	words, short := g.short(text)
+	if !short {
+		return "", false
+	}
+	slog.Info("Input too short to summarize; the model is not called", "words", words, "min_words", g.MinWords, "short_input", g.Mode)
+	if g.Mode == "notice" {
+		return fmt.Sprintf("[Input too short to summarize: %d words, minimum %d]", words, g.MinWords), true
+	}
+	return strings.TrimSpace(text), true
+}
+
+// summaryMemo recuerda los resúmenes generados en la ejecución por tipo y
+// texto, para dar otro formato al mismo documento sin repetir solicitudes
+type summaryMemo struct {
+	mu        sync.Mutex
+	summaries map[string]string
+}
+
+func newSummaryMemo() *summaryMemo {
+	return &summaryMemo{summaries: map[string]string{}}
+}
+
+func (m *summaryMemo) get(text, summaryType string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	summary, ok := m.summaries[summaryType+"\x00"+text]
+	return summary, ok
+}
+
+func (m *summaryMemo) put(text, summaryType, summary string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.summaries[summaryType+"\x00"+text] = summary
+}
+
+func newAPIClient(token *tokenLookup, target ModelTarget, retry RetryPolicy, httpClient *http.Client) *APIClient {
+	return &APIClient{
+		Token:   token,
+		Target:  target,
+		Retry:   retry,
+		HTTP:    httpClient,
+		Metrics: &RunMetrics{},
+	}
+}
+
+// HTTPOptions configura el cliente HTTP: proxy, CAs adicionales y timeouts
+type HTTPOptions struct {
+	Proxy              string
+	CABundle           string
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+	ConnectTimeout     time.Duration
+}
+
+// buildHTTPClient construye el cliente HTTP a partir de la configuración.
+// Sin proxy explícito se respetan las variables HTTPS_PROXY/HTTP_PROXY/NO_PROXY
+func buildHTTPClient(opts HTTPOptions) (*http.Client, error) {
+	if opts.Timeout <= 0 || opts.ConnectTimeout <= 0 {
+		return nil, fmt.Errorf("HTTP timeouts must be positive")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		proxy, err := url.Parse(opts.Proxy)
+		if err != nil || proxy.Scheme == "" || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL '%s'", opts.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	dialer := &net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = opts.ConnectTimeout
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.CABundle != "" {
+		pem, err := os.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid PEM certificates found in CA bundle '%s'", opts.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if opts.InsecureSkipVerify {
+		// Se escribe directamente en stderr para que la advertencia se vea incluso con --quiet
+		fmt.Fprintln(os.Stderr, "WARNING: TLS certificate verification is DISABLED (--insecure-skip-verify).")
+		fmt.Fprintln(os.Stderr, "WARNING: Your API token and documents can be intercepted. Use --ca-bundle instead.")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	slog.Debug("HTTP client configured", "proxy", redactURL(opts.Proxy, true), "ca_bundle", opts.CABundle,
+		"insecure_skip_verify", opts.InsecureSkipVerify, "timeout", opts.Timeout, "connect_timeout", opts.ConnectTimeout)
+
+	return &http.Client{Timeout: opts.Timeout, Transport: transport}, nil
+}
+
+// chaosEnv es la variable de entorno que habilita el flag oculto --chaos
+const chaosEnv = "SUMMARIZER_CHAOS"
+
+// chaosFaults son las fallas que --chaos sabe simular, en el orden en que se
+// sortean
+var chaosFaults = []string{"429", "503", "truncate", "timeout"}
+
+// chaosConfig es la probabilidad de cada falla por solicitud y la semilla del
+// sorteo, para poder repetir una ejecución
+type chaosConfig struct {
+	Rates map[string]float64
+	Seed  int64
+}
+
+// parseChaosSpec interpreta --chaos, p. ej. "429=0.2,503=0.1,seed=7". Sin
+// semilla se usa la hora, que se informa en la advertencia
+func parseChaosSpec(spec string) (chaosConfig, error) {
+	cfg := chaosConfig{Rates: map[string]float64{}, Seed: time.Now().UnixNano()}
+	total := 0.0
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return chaosConfig{}, fmt.Errorf("invalid --chaos entry '%s': expected fault=probability", part)
+		}
+		if key == "seed" {
+			seed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return chaosConfig{}, fmt.Errorf("invalid --chaos seed '%s'", value)
+			}
+			cfg.Seed = seed
+			continue
+		}
+		if !slices.Contains(chaosFaults, key) {
+			return chaosConfig{}, fmt.Errorf("unknown --chaos fault '%s'. Must be: %s", key, strings.Join(chaosFaults, ", "))
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return chaosConfig{}, fmt.Errorf("invalid --chaos probability '%s' for %s: must be between 0 and 1", value, key)
+		}
+		cfg.Rates[key] = rate
+		total += rate
+	}
+	if total > 1 {
+		return chaosConfig{}, fmt.Errorf("--chaos probabilities add up to %.2f; they must not exceed 1", total)
+	}
+	return cfg, nil
+}
+
+// chaosTransport envuelve el transporte del cliente HTTP e inyecta fallas
+// simuladas antes de llegar al proveedor, de modo que reintentos, esperas y
+// clasificación de errores se ejercitan de punta a punta
+type chaosTransport struct {
+	base   http.RoundTripper
+	config chaosConfig
+	mu     sync.Mutex
+	rng    *rand.Rand
+}
+
+func newChaosTransport(base http.RoundTripper, config chaosConfig) *chaosTransport {
+	return &chaosTransport{base: base, config: config, rng: rand.New(rand.NewSource(config.Seed))}
+}
+
+// pick sortea la falla de una solicitud ("" si no corresponde ninguna)
+func (t *chaosTransport) pick() string {
+	t.mu.Lock()
+	r := t.rng.Float64()
+	t.mu.Unlock()
+	for _, fault := range chaosFaults {
+		if r < t.config.Rates[fault] {
+			return fault
+		}
+		r -= t.config.Rates[fault]
+	}
+	return ""
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fault := t.pick()
+	if fault == "" {
+		return t.base.RoundTrip(req)
+	}
+	slog.Info("Chaos: injecting fault", "fault", fault, "method", req.Method, "url", req.URL.Redacted())
+
+	switch fault {
+	case "429":
+		resp := chaosResponse(req, http.StatusTooManyRequests, `{"error":"Rate limit reached (simulated by --chaos)"}`)
+		resp.Header.Set("Retry-After", "1")
+		return resp, nil
+	case "503":
+		return chaosResponse(req, http.StatusServiceUnavailable, `{"error":"Model is currently loading (simulated by --chaos)","estimated_time":2.0}`), nil
+	case "timeout":
+		// La solicitud nunca responde: el contexto vence por --timeout o --deadline
+		closeRequestBody(req)
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+
+	// truncate: la solicitud llega al proveedor, pero el cuerpo se corta a la mitad
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body = body[:len(body)/2]
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+// chaosResponse arma una respuesta JSON simulada para la solicitud
+func chaosResponse(req *http.Request, status int, body string) *http.Response {
+	closeRequestBody(req)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// closeRequestBody cierra el cuerpo de una solicitud que no se envía, como
+// exige el contrato de http.RoundTripper
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// post envía un payload JSON al endpoint del modelo y devuelve la respuesta
+// junto con su cuerpo ya leído
+func (c *APIClient) post(payload interface{}) (*http.Response, []byte, error) {
+	return c.postJSON(c.Target.URL, payload)
+}
+
+// postJSON envía un payload JSON a la URL indicada
+func (c *APIClient) postJSON(target string, payload interface{}) (*http.Response, []byte, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Crear solicitud HTTP
+	req, err := http.NewRequest("POST", target, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	authorization := "none"
+	if c.sendsToken(req.URL) {
+		authorization = "Bearer " + sanitizeToken(c.Token.get())
+	}
+	slog.Debug("Sending request", "method", req.Method, "url", target,
+		"authorization", authorization, "payload_bytes", len(jsonData))
+	slog.Log(context.Background(), LevelTrace, "Request payload", "body", string(jsonData))
+
+	return c.do(req)
+}
+
+// do ejecuta una solicitud autenticada y lee el cuerpo completo de la respuesta
+func (c *APIClient) do(req *http.Request) (*http.Response, []byte, error) {
+	if c.sendsToken(req.URL) {
+		token := c.Token.get()
+		if token == "" {
+			return nil, nil, errMissingToken()
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	if !c.Deadline.IsZero() {
+		ctx, cancel := context.WithDeadline(req.Context(), c.Deadline)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	// Ejecutar solicitud
+	start := time.Now()
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		c.Metrics.recordRequest(time.Since(start), false)
+		return nil, nil, fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Leer cuerpo de la respuesta
+	body, err := io.ReadAll(resp.Body)
+	c.Metrics.recordRequest(time.Since(start), err == nil && resp.StatusCode == http.StatusOK)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	slog.Debug("Received response", "status", resp.StatusCode, "body_bytes", len(body))
+	if q, ok := parseQuotaHeaders(resp.Header, time.Now()); ok {
+		c.Metrics.recordQuota(q)
+		slog.Debug("Quota", "remaining", q.Remaining, "limit", q.Limit, "reset", q.Reset.Format(time.RFC3339))
+	}
+	slog.Log(context.Background(), LevelTrace, "Response payload", "body", string(body))
+	return resp, body, nil
+}
+
+// sendsToken indica si la solicitud lleva el token de HuggingFace. Una app
+// Gradio autoalojada (--endpoint con el proveedor "spaces") no lo recibe: solo
+// los Spaces (*.hf.space) y huggingface.co
+func (c *APIClient) sendsToken(u *url.URL) bool {
+	if !c.Target.Gradio {
+		return true
+	}
+	host := strings.ToLower(u.Hostname())
+	return strings.HasSuffix(host, ".hf.space") || host == "huggingface.co" || strings.HasSuffix(host, ".huggingface.co")
+}
+
+// RunMetrics acumula métricas de la ejecución para --stats.
+// Es seguro para uso concurrente
+type RunMetrics struct {
+	mu          sync.Mutex
+	Started     time.Time
+	Requests    int
+	Failed      int
+	Retries     int
+	Latencies   []time.Duration
+	InputChars  int
+	OutputChars int
+	// Quota es la cuota informada por la última respuesta que la incluyó
+	Quota *quotaInfo
+}
+
+func (m *RunMetrics) recordRequest(latency time.Duration, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Requests++
+	if !ok {
+		m.Failed++
+	}
+	m.Latencies = append(m.Latencies, latency)
+}
+
+// counts devuelve las solicitudes y los reintentos registrados hasta ahora
+func (m *RunMetrics) counts() (requests, retries int) {
+	if m == nil {
+		return 0, 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Requests, m.Retries
+}
+
+func (m *RunMetrics) recordRetry() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Retries++
+}
+
+func (m *RunMetrics) recordSizes(inputChars, outputChars int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.InputChars += inputChars
+	m.OutputChars += outputChars
+}
+
+// percentile devuelve el percentil p (0-100) de las latencias registradas
+func (m *RunMetrics) percentile(p float64) time.Duration {
+	if len(m.Latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), m.Latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+// writeFooter escribe el resumen de métricas de la ejecución
+func (m *RunMetrics) writeFooter(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	round := func(d time.Duration) time.Duration { return d.Round(time.Millisecond) }
+
+	fmt.Fprintln(w, "--- stats ---")
+	fmt.Fprintf(w, "Requests:      %d (%d failed, %d retries)\n", m.Requests, m.Failed, m.Retries)
+	if len(m.Latencies) > 0 {
+		fmt.Fprintf(w, "Latency:       p50=%v p90=%v p99=%v max=%v\n", round(m.percentile(50)),
+			round(m.percentile(90)), round(m.percentile(99)), round(m.percentile(100)))
+	}
+	fmt.Fprintf(w, "Input chars:   %d\n", m.InputChars)
+	fmt.Fprintf(w, "Output chars:  %d\n", m.OutputChars)
+	if m.Quota != nil {
+		fmt.Fprintf(w, "Quota:         %s\n", m.Quota.describe(time.Now()))
+	}
+	if !m.Started.IsZero() {
+		fmt.Fprintf(w, "Total time:    %v\n", round(time.Since(m.Started)))
+	}
+}
+
+func (m *RunMetrics) recordQuota(q quotaInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Quota = &q
+}
+
+func (m *RunMetrics) quota() (quotaInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Quota == nil {
+		return quotaInfo{}, false
+	}
+	return *m.Quota, true
+}
+
+// quotaInfo es la cuota de solicitudes según las cabeceras de límite de tasa
+// de una respuesta. Limit es -1 y Reset es cero si la API no los informa
+type quotaInfo struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+const (
+	// quotaLowWater es el mínimo de solicitudes restantes a partir del cual un
+	// lote empieza a espaciar los archivos (o el 10% del límite, si es mayor)
+	quotaLowWater = 5
+	// quotaUnknownReset es la espera con la cuota agotada si la API no informa
+	// cuándo se renueva
+	quotaUnknownReset = 30 * time.Second
+	// quotaMaxWait acota cada espera por cuota
+	quotaMaxWait = 5 * time.Minute
+)
+
+// parseQuotaHeaders lee las cabeceras X-RateLimit-* y RateLimit-* (y la forma
+// combinada "RateLimit: limit=100, remaining=42, reset=30" o
+// "RateLimit: \"default\";r=42;t=30" del borrador IETF). Reset puede venir en
+// segundos hasta la renovación o como fecha Unix
+func parseQuotaHeaders(h http.Header, now time.Time) (quotaInfo, bool) {
+	q := quotaInfo{Limit: -1, Remaining: -1}
+	reset := -1
+	number := func(value string) int {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 0 {
+			return -1
+		}
+		return n
+	}
+	for _, prefix := range []string{"X-RateLimit-", "RateLimit-"} {
+		if q.Remaining < 0 {
+			q.Remaining = number(h.Get(prefix + "Remaining"))
+		}
+		if q.Limit < 0 {
+			q.Limit = number(h.Get(prefix + "Limit"))
+		}
+		if reset < 0 {
+			reset = number(h.Get(prefix + "Reset"))
+		}
+	}
+	if combined := h.Get("RateLimit"); combined != "" && q.Remaining < 0 {
+		for _, part := range strings.FieldsFunc(combined, func(r rune) bool { return r == ',' || r == ';' }) {
+			key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch strings.ToLower(key) {
+			case "remaining", "r":
+				q.Remaining = number(value)
+			case "limit":
+				q.Limit = number(value)
+			case "reset", "t":
+				reset = number(value)
+			}
+		}
+	}
+	if q.Remaining < 0 {
+		return quotaInfo{}, false
+	}
+	switch {
+	case reset > 1_000_000_000:
+		q.Reset = time.Unix(int64(reset), 0)
+	case reset >= 0:
+		q.Reset = now.Add(time.Duration(reset) * time.Second)
+	}
+	return q, true
+}
+
+// describe muestra la cuota para --stats, p. ej. "42/100 remaining (resets in 30s)"
+func (q quotaInfo) describe(now time.Time) string {
+	text := fmt.Sprintf("%d remaining", q.Remaining)
+	if q.Limit >= 0 {
+		text = fmt.Sprintf("%d/%d remaining", q.Remaining, q.Limit)
+	}
+	if !q.Reset.IsZero() {
+		if wait := q.Reset.Sub(now); wait > 0 {
+			text += fmt.Sprintf(" (resets in %v)", wait.Round(time.Second))
+		}
+	}
+	return text
+}
+
+// delay es la pausa antes del siguiente archivo de un lote para no agotar la
+// cuota: ninguna mientras sobren solicitudes; con pocas, el tiempo hasta la
+// renovación repartido entre las que quedan; agotada, hasta la renovación
+func (q quotaInfo) delay(now time.Time) time.Duration {
+	low := quotaLowWater
+	if q.Limit > 0 {
+		low = max(low, q.Limit/10)
+	}
+	if q.Remaining > low {
+		return 0
+	}
+	if q.Reset.IsZero() {
+		if q.Remaining == 0 {
+			return quotaUnknownReset
+		}
+		return 0
+	}
+	// Si ya pasó la renovación, la cuota registrada es vieja
+	untilReset := q.Reset.Sub(now)
+	if untilReset <= 0 {
+		return 0
+	}
+	return min(untilReset/time.Duration(q.Remaining+1), quotaMaxWait)
+}
+
+// waitForQuota espacia los archivos de un lote cuando la cuota está por
+// agotarse, sin pasar del --deadline
+func (c *APIClient) waitForQuota() {
+	q, ok := c.Metrics.quota()
+	if !ok {
+		return
+	}
+	wait := q.delay(time.Now())
+	if wait <= 0 {
+		return
+	}
+	if !c.Deadline.IsZero() {
+		wait = min(wait, time.Until(c.Deadline))
+	}
+	if wait > 0 {
+		slog.Info("Quota nearly exhausted, slowing down", "remaining", q.Remaining, "wait", wait.Round(time.Millisecond))
+		time.Sleep(wait)
+	}
+}
+
+// retryAfter lee la cabecera Retry-After (segundos o fecha HTTP)
+func retryAfter(h http.Header, now time.Time) time.Duration {
+	value := strings.TrimSpace(h.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// ModelTarget es el destino concreto de las solicitudes para un modelo
+type ModelTarget struct {
+	Name     string
+	ID       string
+	Provider string
+	URL      string
+	// Dedicated indica un Inference Endpoint dedicado en lugar del router
+	Dedicated bool
+	// Gradio indica una app Gradio (Space o autoalojada) con su endpoint APIName
+	Gradio  bool
+	APIName string
+	// Chat indica un modelo que sigue instrucciones y puede devolver JSON con
+	// un schema, a diferencia de los modelos de resumen como BART
+	Chat bool
+}
+
+// builtinModels es el registro de alias incluidos por defecto
+// (los modelos alternativos listados en la cabecera de este archivo)
+var builtinModels = map[string]ModelConfig{
+	"bart":       {ID: "facebook/bart-large-cnn"},
+	"distilbart": {ID: "sshleifer/distilbart-cnn-12-6"},
+	"pegasus":    {ID: "google/pegasus-xsum"},
+	"t5":         {ID: "t5-base"},
+}
+
+// resolveModel construye el destino de las solicitudes a partir del nombre del
+// modelo (alias del archivo de configuración, alias incluido o ID del Hub con
+// sufijo opcional ":proveedor") y de los flags --provider/--endpoint
+func resolveModel(name, provider, endpoint string, cfg *Config) (ModelTarget, error) {
+	if name == "" {
+		name = cfg.Model
+	}
+	if name == "" {
+		name = defaultModel
+	}
+
+	model, ok := cfg.Models[name]
+	if !ok {
+		model, ok = builtinModels[name]
+	}
+	if !ok {
+		model = ModelConfig{ID: name}
+	}
+	if model.ID == "" {
+		return ModelTarget{}, fmt.Errorf("model '%s' in config has no id", name)
+	}
+
+	// Formato de enrutamiento del router: "<id del modelo>:<proveedor>"
+	id := model.ID
+	if i := strings.LastIndex(id, ":"); i > 0 {
+		id, model.Provider = id[:i], id[i+1:]
+	}
+	if provider != "" {
+		model.Provider = provider
+	}
+	if model.Provider == "" {
+		model.Provider = defaultProvider
+	}
+	if endpoint != "" {
+		model.Endpoint = endpoint
+	}
+
+	target := ModelTarget{Name: name, ID: id, Provider: model.Provider}
+	target.Chat = model.Chat || model.Provider != defaultProvider
+	if model.Provider == spacesProvider {
+		target.Chat = false
+		return resolveSpace(target, model)
+	}
+	if model.Endpoint != "" {
+		u, err := url.Parse(model.Endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return ModelTarget{}, fmt.Errorf("invalid endpoint URL '%s'", model.Endpoint)
+		}
+		target.URL = strings.TrimRight(model.Endpoint, "/")
+		target.Dedicated = true
+	} else {
+		target.URL = routerBaseURL + "/" + url.PathEscape(model.Provider) + "/models/" + id
+	}
+	return target, nil
+}
+
+// resolveSpace completa el destino de una app Gradio: el Space se sirve en
+// https://<usuario>-<nombre>.hf.space y --endpoint permite una app autoalojada
+func resolveSpace(target ModelTarget, model ModelConfig) (ModelTarget, error) {
+	target.Gradio = true
+	target.APIName = strings.Trim(model.APIName, "/")
+	if target.APIName == "" {
+		target.APIName = defaultGradioAPI
+	}
+	if model.Endpoint != "" {
+		u, err := url.Parse(model.Endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return ModelTarget{}, fmt.Errorf("invalid endpoint URL '%s'", model.Endpoint)
+		}
+		target.URL = strings.TrimRight(model.Endpoint, "/")
+		return target, nil
+	}
+	owner, space, ok := strings.Cut(target.ID, "/")
+	if !ok || owner == "" || space == "" || strings.Contains(space, "/") {
+		return ModelTarget{}, fmt.Errorf("invalid Space '%s': expected <owner>/<name>", target.ID)
+	}
+	subdomain := strings.NewReplacer("_", "-", ".", "-").Replace(strings.ToLower(owner + "-" + space))
+	target.URL = "https://" + subdomain + ".hf.space"
+	return target, nil
+}
+
+// callGradio envía el texto a la app Gradio con su protocolo REST: un POST a
+// /gradio_api/call/<api> devuelve un event_id y el resultado se lee del stream
+// SSE de /gradio_api/call/<api>/<event_id>. Las versiones 4.x de Gradio sirven
+// la misma API sin el prefijo /gradio_api
+func (c *APIClient) callGradio(text string) (string, error) {
+	payload := map[string]interface{}{"data": []string{text}}
+	var resp *http.Response
+	var body []byte
+	var callURL string
+	for _, prefix := range []string{"/gradio_api/call/", "/call/"} {
+		callURL = c.Target.URL + prefix + url.PathEscape(c.Target.APIName)
+		var err error
+		resp, body, err = c.postJSON(callURL, payload)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			break
+		}
+	}
+	if err := gradioStatusError(resp.StatusCode, body); err != nil {
+		return "", err
+	}
+
+	var queued struct {
+		EventID string `json:"event_id"`
+	}
+	if err := json.Unmarshal(body, &queued); err != nil || queued.EventID == "" {
+		slog.Debug("Unexpected Gradio response", "body", truncateBody(body))
+		return "", fmt.Errorf("failed to parse response: no event_id from the Gradio app")
+	}
+
+	req, err := http.NewRequest("GET", callURL+"/"+url.PathEscape(queued.EventID), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, body, err = c.do(req)
+	if err != nil {
+		return "", err
+	}
+	if err := gradioStatusError(resp.StatusCode, body); err != nil {
+		return "", err
+	}
+	return parseGradioEvents(body)
+}
+
+// gradioStatusError convierte una respuesta HTTP fallida de la app Gradio en
+// error. Un Space dormido o reconstruyéndose responde 503 mientras arranca
+func gradioStatusError(status int, body []byte) error {
+	if status == http.StatusOK {
+		return nil
+	}
+	var errResp HuggingFaceError
+	message := string(body)
+	if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+		message = errResp.Error
+	}
+	if status == http.StatusServiceUnavailable || status == http.StatusBadGateway {
+		return &ModelLoadingError{StatusCode: status, Message: "space is starting", EstimatedTime: errResp.EstimatedTime}
+	}
+	return &APIError{StatusCode: status, Message: message}
+}
+
+// parseGradioEvents lee el stream SSE de Gradio ("event: ..." seguido de
+// "data: ...") y decodifica la salida del evento "complete"
+func parseGradioEvents(body []byte) (string, error) {
+	event := ""
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "event:"); ok {
+			event = strings.TrimSpace(value)
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		switch event {
+		case "complete":
+			return decodeSummary([]byte(data))
+		case "error":
+			message := "the Gradio app raised an error"
+			var detail string
+			if json.Unmarshal([]byte(data), &detail) == nil && detail != "" {
+				message = detail
+			}
+			return "", &APIError{StatusCode: http.StatusOK, Message: message}
+		}
+	}
+	slog.Debug("Gradio stream ended without a result", "body", truncateBody(body))
+	return "", fmt.Errorf("failed to parse response: the Gradio stream ended without a result")
+}
+
+// checkHealth comprueba si el endpoint puede atender solicitudes. Devuelve
+// además cuánto conviene esperar antes de volver a consultar si aún no está listo.
+// Los endpoints dedicados exponen /health; en el router se envía una solicitud
+// mínima y se interpreta la respuesta "model is loading"
+func (c *APIClient) checkHealth() (bool, time.Duration, error) {
+	const retryAfter = 5 * time.Second
+
+	// Las apps Gradio sirven su configuración en /config cuando están en marcha
+	if c.Target.Gradio {
+		req, err := http.NewRequest("GET", c.Target.URL+"/config", nil)
+		if err != nil {
+			return false, 0, fmt.Errorf("failed to create request: %w", err)
+		}
+		resp, body, err := c.do(req)
+		if err != nil {
+			return false, 0, err
+		}
+		if err := gradioStatusError(resp.StatusCode, body); err != nil {
+			var loading *ModelLoadingError
+			if errors.As(err, &loading) {
+				return false, retryAfter, nil
+			}
+			return false, 0, err
+		}
+		return true, 0, nil
+	}
+
+	if c.Target.Dedicated {
+		req, err := http.NewRequest("GET", c.Target.URL+"/health", nil)
+		if err != nil {
+			return false, 0, fmt.Errorf("failed to create request: %w", err)
+		}
+		resp, _, err := c.do(req)
+		if err != nil {
+			return false, 0, err
+		}
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return true, 0, nil
+		// Endpoint pausado o escalando desde cero
+		case resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable:
+			return false, retryAfter, nil
+		// Algunos contenedores no exponen /health: se prueba con una solicitud real
+		case resp.StatusCode != http.StatusNotFound:
+			return false, 0, &APIError{StatusCode: resp.StatusCode, Message: "health check failed"}
+		}
+	}
+
+	resp, body, err := c.post(HuggingFaceRequest{Inputs: "Health check."})
+	if err != nil {
+		return false, 0, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return true, 0, nil
+	}
+
+	var errResp HuggingFaceError
+	_ = json.Unmarshal(body, &errResp)
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		if errResp.EstimatedTime > 0 {
+			return false, time.Duration(errResp.EstimatedTime * float64(time.Second)), nil
+		}
+		return false, retryAfter, nil
+	}
+	message := errResp.Error
+	if message == "" {
+		message = string(body)
+	}
+	return false, 0, &APIError{StatusCode: resp.StatusCode, Message: message}
+}
+
+// warmUp espera hasta que el endpoint esté listo o se agote el timeout
+func (c *APIClient) warmUp(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ready, wait, err := c.checkHealth()
+		if err != nil {
+			return err
+		}
+		if ready {
+			slog.Debug("Endpoint is ready", "url", c.Target.URL)
+			return nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("endpoint not ready after %v: %w", timeout, context.DeadlineExceeded)
+		}
+		slog.Info("Endpoint not ready yet, waiting", "url", c.Target.URL, "wait", wait)
+		time.Sleep(wait)
+	}
+}
+
+// summarizeText llama a la API de HuggingFace para generar un resumen según el tipo especificado
+// Implementa lógica de reintentos con backoff exponencial para manejar límites de tasa y errores transitorios
+func (c *APIClient) summarizeText(text, summaryType string) (string, error) {
+	if summary, ok := c.Memo.get(text, summaryType); ok {
+		return summary, nil
+	}
+	var lastErr error
+	maxAttempts := c.Retry.MaxRetries + 1
+
+	// Bucle de reintentos con backoff exponencial
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			// Calcular retraso de backoff exponencial
+			delay := c.Retry.Delay(attempt)
+			// Si el modelo está cargando o la API pidió esperar (Retry-After),
+			// respetar esa espera
+			var loading *ModelLoadingError
+			var apiErr *APIError
+			switch {
+			case errors.As(lastErr, &loading) && loading.EstimatedTime > 0:
+				estimated := time.Duration(loading.EstimatedTime * float64(time.Second))
+				delay = max(delay, min(estimated, c.Retry.MaxDelay))
+			case errors.As(lastErr, &apiErr) && apiErr.RetryAfter > 0:
+				delay = max(delay, min(apiErr.RetryAfter, c.Retry.MaxDelay))
+			}
+			if !c.Deadline.IsZero() && time.Now().Add(delay).After(c.Deadline) {
+				return "", fmt.Errorf("no time left to retry before the deadline: %w: %w", context.DeadlineExceeded, lastErr)
+			}
+			slog.Info("Retrying request", "delay", delay, "attempt", attempt+1, "max_attempts", maxAttempts)
+			c.Metrics.recordRetry()
+			time.Sleep(delay)
+		}
+
+		start := time.Now()
+		summary, err := c.attemptSummarization(text, summaryType)
+		slog.Debug("Attempt finished", "attempt", attempt+1, "duration", time.Since(start), "ok", err == nil)
+		if err == nil {
+			c.Metrics.recordSizes(len(text), len(summary))
+			c.Memo.put(text, summaryType, summary)
+			return summary, nil
+		}
+
+		lastErr = err
+		slog.Debug("Attempt failed", "attempt", attempt+1, "error", err)
+
+		// Verificar si el error es reintentable (límite de tasa o error de servidor)
+		if !isRetryableError(err) {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("failed after %d attempts: %w", maxAttempts, lastErr)
+}
+
+// attemptSummarization realiza un único intento de llamar a la API
+func (c *APIClient) attemptSummarization(text, summaryType string) (string, error) {
+	// Preparar el prompt según el tipo de resumen
+	prompt := buildPrompt(text, summaryType)
+	if instruction := c.Prompts[summaryType]; instruction != "" {
+		prompt = instruction + "\n\n" + text
+	}
+	if strings.Contains(text, priorityMarker) {
+		prompt = priorityNote + "\n" + prompt
+	}
+
+	// Crear payload de solicitud
+	requestBody := HuggingFaceRequest{
+		Inputs:     prompt,
+		Parameters: requestParameters(summaryType, c.Length, text),
+	}
+
+	// Las apps Gradio reciben solo el texto: la instrucción y los parámetros
+	// de generación los fija la propia app
+	if c.Target.Gradio {
+		if err := c.Budget.reserve(len(text)); err != nil {
+			return "", err
+		}
+		return c.callGradio(text)
+	}
+	if err := c.Budget.reserve(len(prompt)); err != nil {
+		return "", err
+	}
+	resp, body, err := c.post(requestBody)
+	if err != nil {
+		return "", err
+	}
+
+	// Verificar errores de la API
+	if resp.StatusCode != http.StatusOK {
+		var errResp HuggingFaceError
+		if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+			if isModelLoading(resp.StatusCode, errResp) {
+				return "", &ModelLoadingError{StatusCode: resp.StatusCode, Message: errResp.Error, EstimatedTime: errResp.EstimatedTime}
+			}
+			apiErr := &APIError{
+				StatusCode:    resp.StatusCode,
+				Message:       errResp.Error,
+				EstimatedTime: errResp.EstimatedTime,
+				RetryAfter:    retryAfter(resp.Header, time.Now()),
+			}
+			// Mejorar mensaje de error 401 con instrucciones útiles
+			if resp.StatusCode == http.StatusUnauthorized {
+				return "", fmt.Errorf("%w\n\nPlease ensure your API token is valid:\n1. Go to https://huggingface.co/settings/tokens\n2. Create or copy your token\n3. Set: $env:HUGGINGFACE_API_TOKEN=\"your_token_here\"", apiErr)
+			}
+			return "", apiErr
+		}
+		return "", &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    string(body),
+			RetryAfter: retryAfter(resp.Header, time.Now()),
+		}
+	}
+
+	return decodeSummary(body)
+}
+
+// summaryTextKeys son los campos con el texto generado en las distintas formas
+// de respuesta, en orden de preferencia
+var summaryTextKeys = []string{"summary_text", "generated_text", "translation_text", "text", "content", "output"}
+
+// decodeSummary extrae el resumen de las formas de respuesta conocidas:
+// [{"summary_text": ...}] (modelos de resumen), [{"generated_text": ...}]
+// (text-generation), un objeto suelto, listas anidadas, un texto JSON o
+// {"choices": [{"message": {"content": ...}}]} (API compatible con OpenAI).
+// Un {"error": ...} con estado 200 también se interpreta
+func decodeSummary(body []byte) (string, error) {
+	var raw interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		slog.Debug("Response is not JSON", "body", truncateBody(body))
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	if obj, ok := raw.(map[string]interface{}); ok {
+		if message, ok := obj["error"].(string); ok && message != "" {
+			errResp := HuggingFaceError{Error: message}
+			errResp.EstimatedTime, _ = obj["estimated_time"].(float64)
+			if isModelLoading(http.StatusOK, errResp) {
+				return "", &ModelLoadingError{StatusCode: http.StatusOK, Message: message, EstimatedTime: errResp.EstimatedTime}
+			}
+			return "", &APIError{StatusCode: http.StatusOK, Message: message}
+		}
+	}
+	text, ok := findSummaryText(raw)
+	if !ok {
+		slog.Debug("Unrecognized response shape", "body", truncateBody(body))
+		return "", fmt.Errorf("failed to parse response: no summary text in %s", describeShape(raw))
+	}
+	if strings.TrimSpace(text) == "" {
+		return "", fmt.Errorf("no summary generated by the API")
+	}
+	return text, nil
+}
+
+// findSummaryText busca el texto generado recorriendo listas y objetos
+func findSummaryText(v interface{}) (string, bool) {
+	switch value := v.(type) {
+	case string:
+		return value, true
+	case []interface{}:
+		for _, item := range value {
+			if text, ok := findSummaryText(item); ok {
+				return text, true
+			}
+		}
+	case map[string]interface{}:
+		for _, key := range summaryTextKeys {
+			if text, ok := value[key].(string); ok {
+				return text, true
+			}
+		}
+		for _, key := range []string{"choices", "message", "outputs", "data"} {
+			if nested, ok := value[key]; ok {
+				if text, ok := findSummaryText(nested); ok {
+					return text, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// describeShape resume la forma de una respuesta para los mensajes de error,
+// p. ej. "[{label, score}]"
+func describeShape(v interface{}) string {
+	switch value := v.(type) {
+	case []interface{}:
+		if len(value) == 0 {
+			return "an empty list"
+		}
+		return "[" + describeShape(value[0]) + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return "{" + strings.Join(keys, ", ") + "}"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// truncateBody acota el cuerpo de una respuesta para los logs
+func truncateBody(body []byte) string {
+	const maxLogged = 2048
+	if len(body) > maxLogged {
+		return string(body[:maxLogged]) + "..."
+	}
+	return string(body)
+}
+
+// ModelLoadingError indica que el modelo todavía se está cargando. Es
+// reintentable y EstimatedTime indica cuánto esperar; envuelve un APIError para
+// que la clasificación por código de estado siga funcionando
+type ModelLoadingError struct {
+	StatusCode int
+	Message    string
+	// EstimatedTime es el tiempo de carga (en segundos) informado por la API
+	EstimatedTime float64
+}
+
+func (e *ModelLoadingError) Error() string {
+	if e.EstimatedTime > 0 {
+		return fmt.Sprintf("model is loading (%s); estimated %.0fs", e.Message, e.EstimatedTime)
+	}
+	return fmt.Sprintf("model is loading (%s)", e.Message)
+}
+
+func (e *ModelLoadingError) Unwrap() error {
+	return &APIError{StatusCode: e.StatusCode, Message: e.Message, EstimatedTime: e.EstimatedTime}
+}
+
+// isModelLoading reconoce la respuesta "model is currently loading"
+func isModelLoading(status int, errResp HuggingFaceError) bool {
+	if strings.Contains(strings.ToLower(errResp.Error), "loading") {
+		return true
+	}
+	return status == http.StatusServiceUnavailable && errResp.EstimatedTime > 0
+}
+
+// APIError representa un error devuelto por la API con código de estado
+type APIError struct {
+	StatusCode int
+	Message    string
+	// EstimatedTime es el tiempo estimado de carga del modelo informado por la API
+	EstimatedTime float64
+	// RetryAfter es la espera pedida por la cabecera Retry-After (p. ej. en un 429)
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error (%d): %s", e.StatusCode, e.Message)
+}
+
+// isRetryableError determina si vale la pena reintentar un error
+func isRetryableError(err error) bool {
+	var loading *ModelLoadingError
+	if errors.As(err, &loading) {
+		return true
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		// Reintentar en límite de tasa (429) o errores de servidor (5xx)
+		return apiErr.StatusCode == http.StatusTooManyRequests ||
+			(apiErr.StatusCode >= 500 && apiErr.StatusCode < 600)
+	}
+	return false
+}
+
+// buildPrompt crea un prompt adaptado al tipo de resumen
+func buildPrompt(text, summaryType string) string {
+	switch summaryType {
+	case "short":
+		return fmt.Sprintf("Summarize this text in 1-2 concise sentences:\n\n%s", text)
+	case "medium":
+		return fmt.Sprintf("Provide a comprehensive paragraph summary of this text:\n\n%s", text)
+	case "bullet":
+		return fmt.Sprintf("Summarize this text as a list of key points:\n\n%s", text)
+	case "outline":
+		return fmt.Sprintf("Summarize the main points of each section of this text:\n\n%s", text)
+	case "toc":
+		return fmt.Sprintf("Summarize this section in one sentence:\n\n%s", text)
+	case "glossary":
+		return fmt.Sprintf("Define the term in one short sentence, as it is used in the passage:\n\n%s", text)
+	case "feedback":
+		return fmt.Sprintf("Summarize the common themes in these customer feedback entries:\n\n%s", text)
+	case "revisions":
+		return fmt.Sprintf("Summarize what substantively changed between two versions of a document:\n\n%s", text)
+	case "proscons":
+		return fmt.Sprintf("Summarize the advantages and disadvantages described in this text:\n\n%s", text)
+	case structuredType:
+		return fmt.Sprintf("Summarize this text as a JSON object matching this JSON Schema. "+
+			"Reply with the JSON object only.\n\nSchema:\n%s\n\nText:\n%s", structuredSummarySchema, text)
+	default:
+		return text
+	}
+}
+
+// requestParameters devuelve los parámetros de generación enviados a la API
+func requestParameters(summaryType string, length LengthOptions, text string) map[string]interface{} {
+	maxLength, minLength := length.resolve(summaryType, text)
+	// La salida estructurada se restringe al schema con el parámetro grammar de
+	// text-generation, en lugar de los límites de longitud de los modelos de resumen
+	if summaryType == structuredType {
+		return map[string]interface{}{
+			"max_new_tokens":   structuredMaxTokens,
+			"return_full_text": false,
+			"grammar":          map[string]interface{}{"type": "json", "value": json.RawMessage(structuredSummarySchema)},
+		}
+	}
+	return map[string]interface{}{
+		"max_length": maxLength,
+		"min_length": minLength,
+	}
+}
+
+// LengthOptions reemplaza la longitud predefinida de cada tipo de resumen.
+// Los valores en cero usan el valor del tipo
+type LengthOptions struct {
+	MaxTokens int
+	MinTokens int
+	// CompressionRatio es la longitud buscada como fracción de la entrada
+	CompressionRatio float64
+}
+
+// wordsToTokens convierte palabras a tokens (en inglés, unos 4 tokens cada 3 palabras)
+func wordsToTokens(words int) int {
+	return (words*4 + 2) / 3
+}
+
+// newLengthOptions valida los flags de longitud. Las palabras se convierten a tokens,
+// que es la unidad que entiende la API
+func newLengthOptions(maxWords, minWords, maxTokens, minTokens int, ratio float64) (LengthOptions, error) {
+	switch {
+	case maxWords < 0 || minWords < 0 || maxTokens < 0 || minTokens < 0:
+		return LengthOptions{}, fmt.Errorf("summary length flags must not be negative")
+	case maxWords > 0 && maxTokens > 0:
+		return LengthOptions{}, fmt.Errorf("--max-words and --max-tokens cannot be combined")
+	case minWords > 0 && minTokens > 0:
+		return LengthOptions{}, fmt.Errorf("--min-words and --min-tokens cannot be combined")
+	case ratio < 0 || ratio > 1:
+		return LengthOptions{}, fmt.Errorf("--compression-ratio must be between 0 and 1, got %v", ratio)
+	}
+	opts := LengthOptions{MaxTokens: maxTokens, MinTokens: minTokens, CompressionRatio: ratio}
+	if maxWords > 0 {
+		opts.MaxTokens = wordsToTokens(maxWords)
+	}
+	if minWords > 0 {
+		opts.MinTokens = wordsToTokens(minWords)
+	}
+	if opts.MaxTokens > 0 && opts.MinTokens > opts.MaxTokens {
+		return LengthOptions{}, fmt.Errorf("minimum summary length (%d tokens) exceeds the maximum (%d tokens)", opts.MinTokens, opts.MaxTokens)
+	}
+	return opts, nil
+}
+
+// resolve calcula max_length y min_length para un texto: primero los flags
+// explícitos, luego la tasa de compresión y por último el valor del tipo
+func (o LengthOptions) resolve(summaryType, text string) (int, int) {
+	maxLength, minLength := getMaxLength(summaryType), getMinLength(summaryType)
+	if o.CompressionRatio > 0 {
+		maxLength = int(math.Ceil(float64(estimateTokens(text)) * o.CompressionRatio))
+		if maxLength < 10 {
+			maxLength = 10
+		}
+		minLength = maxLength / 2
+	}
+	if o.MaxTokens > 0 {
+		maxLength = o.MaxTokens
+	}
+	if o.MinTokens > 0 {
+		minLength = o.MinTokens
+	}
+	// Un mínimo heredado del tipo no puede superar un máximo explícito
+	if minLength > maxLength {
+		minLength = maxLength / 2
+	}
+	return maxLength, minLength
+}
+
+// getMaxLength devuelve la longitud máxima de tokens para el tipo de resumen
+func getMaxLength(summaryType string) int {
+	switch summaryType {
+	case "short":
+		return 50
+	case "medium":
+		return 150
+	case "bullet":
+		return 200
+	case "outline":
+		return 250
+	case "proscons":
+		return 200
+	case "toc", "glossary":
+		return 40
+	case "feedback":
+		return 120
+	default:
+		return 100
+	}
+}
+
+// getMinLength devuelve la longitud mínima de tokens para el tipo de resumen
+func getMinLength(summaryType string) int {
+	switch summaryType {
+	case "short":
+		return 10
+	case "medium":
+		return 50
+	case "bullet":
+		return 30
+	case "outline":
+		return 60
+	case "proscons":
+		return 40
+	case "toc", "glossary":
+		return 5
+	case "feedback":
+		return 30
+	default:
+		return 20
+	}
+}
+
+// formatOutput formatea el resumen según el tipo solicitado
+func formatOutput(summary, summaryType string) string {
+	if summaryType == "bullet" {
+		// Convertir a puntos bullet si no está ya formateado
+		// Maneja múltiples delimitadores: puntos, saltos de línea y punto y coma
+		var bullets []string
+		
+		// Intentar dividir por saltos de línea primero (si la API devuelve lista pre-formateada)
+		lines := strings.Split(summary, "\n")
+		if len(lines) > 1 {
+			for _, line := range lines {
+				line = strings.TrimSpace(line)
+				// Remover marcadores bullet existentes si están presentes
+				line = strings.TrimPrefix(line, "-")
+				line = strings.TrimPrefix(line, "*")
+				line = strings.TrimPrefix(line, "•")
+				line = strings.TrimSpace(line)
+				if line != "" && len(line) > 3 { // Evitar fragmentos muy cortos
+					bullets = append(bullets, "- "+line)
+				}
+			}
+		}
+		
+		// Si no se encontraron saltos de línea, dividir por oraciones y punto y coma
+		if len(bullets) == 0 {
+			for _, sentence := range splitSentences(summary) {
+				for _, line := range strings.FieldsFunc(sentence, func(r rune) bool { return r == ';' || r == '；' }) {
+					line = trimSentenceEnd(strings.TrimSpace(line))
+					if len(line) > 10 { // Evitar fragmentos muy cortos
+						bullets = append(bullets, "- "+line)
+					}
+				}
+			}
+		}
+		
+		if len(bullets) > 0 {
+			return strings.Join(bullets, "\n")
+		}
+		// Fallback: devolver original con bullet único si el parseo falla
+		return "- " + summary
+	}
+	return summary
+}
+
+// outlineSection es una sección del documento detectada a partir de sus encabezados
+type outlineSection struct {
+	Title  string
+	Level  int
+	Body   string
+	Points []string
+}
+
+// formatOutline genera un esquema jerárquico: las secciones del documento
+// (según sus encabezados) con los puntos del resumen que corresponden a cada una.
+// Si el documento no tiene encabezados, cada oración del resumen es un punto
+// principal y sus cláusulas se listan como sub-puntos
+func formatOutline(summary, document string) string {
+	sentences := splitSentences(summary)
+	sections := detectSections(document)
+
+	var b strings.Builder
+	if len(sections) == 0 {
+		for i, sentence := range sentences {
+			clauses := splitClauses(sentence)
+			fmt.Fprintf(&b, "%d. %s\n", i+1, clauses[0])
+			for _, clause := range clauses[1:] {
+				fmt.Fprintf(&b, "   - %s\n", clause)
+			}
+		}
+		return strings.TrimRight(b.String(), "\n")
+	}
+
+	// Asignar cada oración del resumen a la sección con mayor solapamiento
+	// léxico. Una oración sin solapamiento (una paráfrasis o una conclusión
+	// general) no se descarta: va a la sección de la oración anterior, o a la
+	// primera si es la primera oración
+	previous := 0
+	for _, sentence := range sentences {
+		best, bestScore := -1, 0.0
+		words := contentWords(sentence)
+		for i, section := range sections {
+			if score := lexicalOverlap(words, contentWords(section.Title+" "+section.Body)); score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			best = previous
+		}
+		sections[best].Points = append(sections[best].Points, sentence)
+		previous = best
+	}
+
+	labels, depths := numberSections(sections)
+	for i, section := range sections {
+		depth := depths[i]
+		indent := strings.Repeat("   ", depth)
+		fmt.Fprintf(&b, "%s%s. %s\n", indent, labels[i], section.Title)
+		for _, point := range section.Points {
+			fmt.Fprintf(&b, "%s   - %s\n", indent, point)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// numberSections calcula la numeración jerárquica (1, 1.1, 1.1.1) de las
+// secciones y su profundidad, relativa al nivel de encabezado más alto
+func numberSections(sections []outlineSection) ([]string, []int) {
+	if len(sections) == 0 {
+		return nil, nil
+	}
+	minLevel := sections[0].Level
+	for _, section := range sections {
+		if section.Level < minLevel {
+			minLevel = section.Level
+		}
+	}
+
+	labels := make([]string, len(sections))
+	depths := make([]int, len(sections))
+	var counters []int
+	for i, section := range sections {
+		depth := section.Level - minLevel
+		for len(counters) <= depth {
+			counters = append(counters, 0)
+		}
+		counters = counters[:depth+1]
+		counters[depth]++
+
+		numbers := make([]string, len(counters))
+		for j, n := range counters {
+			numbers[j] = strconv.Itoa(n)
+		}
+		labels[i] = strings.Join(numbers, ".")
+		depths[i] = depth
+	}
+	return labels, depths
+}
+
+// detectSections reconoce encabezados Markdown ("#", "##"), encabezados
+// subrayados ("===", "---") y líneas cortas sin puntuación final seguidas de texto
+func detectSections(document string) []outlineSection {
+	_, sections := splitSections(document)
+	return sections
+}
+
+// splitSections hace lo mismo que detectSections y además devuelve el texto
+// anterior al primer encabezado (una introducción sin título)
+func splitSections(document string) (string, []outlineSection) {
+	lines := strings.Split(document, "\n")
+	var sections []outlineSection
+	var body []string
+	preamble := ""
+	flushBody := func() {
+		text := strings.TrimSpace(strings.Join(body, " "))
+		if len(sections) > 0 {
+			sections[len(sections)-1].Body = text
+		} else {
+			preamble = text
+		}
+		body = nil
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		next := ""
+		if i+1 < len(lines) {
+			next = strings.TrimSpace(lines[i+1])
+		}
+
+		switch {
+		case strings.HasPrefix(line, "#"):
+			level := len(line) - len(strings.TrimLeft(line, "#"))
+			flushBody()
+			sections = append(sections, outlineSection{Title: strings.TrimSpace(line[level:]), Level: level})
+		case line != "" && next != "" && strings.Trim(next, "=") == "":
+			flushBody()
+			sections = append(sections, outlineSection{Title: line, Level: 1})
+			i++
+		case line != "" && next != "" && strings.Trim(next, "-") == "":
+			flushBody()
+			sections = append(sections, outlineSection{Title: line, Level: 2})
+			i++
+		case isPlainHeading(line, lines, i):
+			flushBody()
+			sections = append(sections, outlineSection{Title: line, Level: 1})
+		default:
+			body = append(body, line)
+		}
+	}
+	flushBody()
+	return preamble, sections
+}
+
+// isPlainHeading detecta encabezados sin marcado: una línea corta, sin
+// puntuación final, precedida por una línea en blanco y seguida de texto
+func isPlainHeading(line string, lines []string, i int) bool {
+	if line == "" || len(line) > 60 || strings.ContainsAny(line[len(line)-1:], ".!?:;,") {
+		return false
+	}
+	if i > 0 && strings.TrimSpace(lines[i-1]) != "" {
+		return false
+	}
+	return i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != ""
+}
+
+// splitClauses divide una oración en cláusulas por comas y punto y coma,
+// descartando fragmentos demasiado cortos para ser un sub-punto
+func splitClauses(sentence string) []string {
+	sentence = strings.TrimRight(sentence, ".!?")
+	var clauses []string
+	for _, part := range strings.FieldsFunc(sentence, func(r rune) bool { return r == ',' || r == ';' }) {
+		part = strings.TrimSpace(part)
+		if len(clauses) > 0 && len(part) < 15 {
+			clauses[len(clauses)-1] += ", " + part
+			continue
+		}
+		clauses = append(clauses, part)
+	}
+	if len(clauses) == 0 {
+		return []string{sentence}
+	}
+	return clauses
+}
+
+// Palabras que indican una ventaja o una desventaja, además del léxico de
+// sentimiento. Se omiten las ambiguas: "pro"/"con" (nombres de planes, o "con"
+// en español), "requires" y "supports", que aparecen en frases neutras
+var (
+	proCues = []string{"advantage", "advantages", "pros", "benefit", "benefits", "faster", "cheaper",
+		"easier", "simpler", "reliable", "efficient", "saves", "improves", "allows", "enables"}
+	conCues = []string{"disadvantage", "disadvantages", "cons", "drawback", "drawbacks", "downside",
+		"slower", "expensive", "costly", "harder", "complex", "lacks", "lack", "limited", "issue", "issues",
+		"however", "unfortunately", "cannot"}
+)
+
+// polarityScore puntúa una frase: positiva si describe una ventaja y
+// negativa si describe una desventaja
+func polarityScore(text string) int {
+	score := 0
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		word = strings.Trim(word, ".,;:!?\"'()")
+		for _, cue := range append(positiveWords, proCues...) {
+			if word == cue {
+				score++
+			}
+		}
+		for _, cue := range append(negativeWords, conCues...) {
+			if word == cue {
+				score--
+			}
+		}
+	}
+	return score
+}
+
+// splitContrasts separa una oración en las partes unidas por conectores
+// adversativos ("but", "however", "although", "while"), que suelen
+// contraponer una ventaja y una desventaja
+func splitContrasts(sentence string) []string {
+	parts := []string{strings.TrimRight(sentence, ".!?")}
+	for _, sep := range []string{"; ", ", but ", " but ", ", however, ", " however ", ", although ", " although ", ", while ", " whereas "} {
+		var next []string
+		for _, part := range parts {
+			next = append(next, strings.Split(part, sep)...)
+		}
+		parts = next
+	}
+	var result []string
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		for _, connector := range []string{"However, ", "However ", "But ", "Although ", "While "} {
+			part = strings.TrimPrefix(part, connector)
+		}
+		if len(part) > 10 {
+			first, size := utf8.DecodeRuneInString(part)
+			result = append(result, string(unicode.ToUpper(first))+part[size:])
+		}
+	}
+	return result
+}
+
+// formatProsCons clasifica las frases del resumen en ventajas y desventajas.
+// Si alguna de las dos listas queda vacía, se completa con las frases más
+// claramente polarizadas del documento original
+func formatProsCons(summary, document string) string {
+	var pros, cons []string
+	seen := map[string]bool{}
+	classify := func(text string) {
+		for _, part := range splitContrasts(text) {
+			key := strings.ToLower(part)
+			if seen[key] {
+				continue
+			}
+			switch score := polarityScore(part); {
+			case score > 0:
+				pros = append(pros, part)
+			case score < 0:
+				cons = append(cons, part)
+			default:
+				continue
+			}
+			seen[key] = true
+		}
+	}
+
+	for _, sentence := range splitSentences(summary) {
+		classify(sentence)
+	}
+
+	if len(pros) == 0 || len(cons) == 0 {
+		type scored struct {
+			text  string
+			score int
+		}
+		var candidates []scored
+		for _, paragraph := range paragraphs(document) {
+			for _, sentence := range splitSentences(paragraph) {
+				for _, part := range splitContrasts(sentence) {
+					if score := polarityScore(part); score != 0 && !seen[strings.ToLower(part)] {
+						candidates = append(candidates, scored{part, score})
+					}
+				}
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return abs(candidates[i].score) > abs(candidates[j].score)
+		})
+		const maxSupplement = 3
+		addedPros, addedCons := 0, 0
+		needPros, needCons := len(pros) == 0, len(cons) == 0
+		for _, c := range candidates {
+			if needPros && c.score > 0 && addedPros < maxSupplement {
+				pros = append(pros, c.text)
+				addedPros++
+			}
+			if needCons && c.score < 0 && addedCons < maxSupplement {
+				cons = append(cons, c.text)
+				addedCons++
+			}
+		}
+	}
+
+	var b strings.Builder
+	writeList := func(title string, items []string) {
+		b.WriteString(title + ":\n")
+		if len(items) == 0 {
+			b.WriteString("- (none identified)\n")
+		}
+		for _, item := range items {
+			b.WriteString("- " + item + "\n")
+		}
+	}
+	writeList("Pros", pros)
+	b.WriteString("\n")
+	writeList("Cons", cons)
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// feedbackTheme es un tema recurrente en un conjunto de comentarios
+type feedbackTheme struct {
+	Label  string
+	Items  []int
+	Quotes []string
+}
+
+// splitFeedbackItems separa la entrada en comentarios individuales: por
+// párrafos si están separados por líneas en blanco, o si no, uno por línea
+func splitFeedbackItems(document string) []string {
+	items := paragraphs(document)
+	if len(items) < 2 {
+		items = nil
+		for _, line := range strings.Split(document, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				items = append(items, line)
+			}
+		}
+	}
+	for i, item := range items {
+		items[i] = strings.TrimSpace(strings.TrimLeft(item, "-*•0123456789.) "))
+	}
+	return items
+}
+
+// stemWord reduce una palabra a una raíz aproximada para agrupar variantes
+// ("delays", "delayed" → "delay")
+func stemWord(word string) string {
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		if strings.HasSuffix(word, suffix) && len(word)-len(suffix) >= 4 {
+			return strings.TrimSuffix(word, suffix)
+		}
+	}
+	return word
+}
+
+// extractThemes agrupa los comentarios por sus palabras clave más frecuentes.
+// Cada tema se etiqueta con su palabra clave principal y la que más co-ocurre
+// con ella; un comentario puede pertenecer a varios temas
+func extractThemes(items []string, maxThemes int) []feedbackTheme {
+	stems := make([]map[string]bool, len(items))
+	docFreq := map[string]int{}
+	surface := map[string]string{}
+	for i, item := range items {
+		stems[i] = map[string]bool{}
+		for word := range contentWords(item) {
+			stem := stemWord(word)
+			if !stems[i][stem] {
+				stems[i][stem] = true
+				docFreq[stem]++
+			}
+			if current, ok := surface[stem]; !ok || len(word) < len(current) {
+				surface[stem] = word
+			}
+		}
+	}
+
+	keywords := make([]string, 0, len(docFreq))
+	for stem, df := range docFreq {
+		if df >= 2 {
+			keywords = append(keywords, stem)
+		}
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if docFreq[keywords[i]] != docFreq[keywords[j]] {
+			return docFreq[keywords[i]] > docFreq[keywords[j]]
+		}
+		return keywords[i] < keywords[j]
+	})
+
+	var themes []feedbackTheme
+	used := map[string]bool{}
+	for _, keyword := range keywords {
+		if len(themes) == maxThemes {
+			break
+		}
+		if used[keyword] {
+			continue
+		}
+		used[keyword] = true
+
+		theme := feedbackTheme{Label: surface[keyword]}
+		coCounts := map[string]int{}
+		for i := range items {
+			if stems[i][keyword] {
+				theme.Items = append(theme.Items, i)
+				for stem := range stems[i] {
+					if stem != keyword {
+						coCounts[stem]++
+					}
+				}
+			}
+		}
+
+		// Segunda palabra de la etiqueta: la que más co-ocurre (en al menos la mitad)
+		best, bestCount := "", 0
+		for stem, count := range coCounts {
+			if count > bestCount || (count == bestCount && stem < best) {
+				best, bestCount = stem, count
+			}
+		}
+		if best != "" && bestCount*2 >= len(theme.Items) && bestCount >= 2 {
+			theme.Label += " / " + surface[best]
+			used[best] = true
+		}
+
+		// Citas representativas: los comentarios más cortos que mencionan el tema
+		quotes := append([]int(nil), theme.Items...)
+		sort.SliceStable(quotes, func(a, b int) bool { return len(items[quotes[a]]) < len(items[quotes[b]]) })
+		for _, idx := range quotes {
+			if len(theme.Quotes) == 2 {
+				break
+			}
+			if len(items[idx]) >= 15 {
+				theme.Quotes = append(theme.Quotes, items[idx])
+			}
+		}
+		themes = append(themes, theme)
+	}
+	return themes
+}
+
+// formatFeedback combina el resumen general del modelo con los temas
+// recurrentes, su frecuencia aproximada y citas representativas
+func formatFeedback(summary, document string) string {
+	items := splitFeedbackItems(document)
+	if len(items) < 3 {
+		slog.Warn("Feedback mode works best with many short entries", "entries", len(items))
+	}
+	themes := extractThemes(items, 6)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Overview:\n%s\n\n", strings.TrimSpace(summary))
+	fmt.Fprintf(&b, "Themes (%d entries):\n", len(items))
+	if len(themes) == 0 {
+		b.WriteString("- (no recurring themes found)\n")
+	}
+	for i, theme := range themes {
+		percent := 100 * len(theme.Items) / len(items)
+		fmt.Fprintf(&b, "%d. %s — ~%d%% (%d entries)\n", i+1, theme.Label, percent, len(theme.Items))
+		for _, quote := range theme.Quotes {
+			fmt.Fprintf(&b, "   \"%s\"\n", quote)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// defaultFilterTerms es la lista incorporada de groserías e insultos en inglés.
+// Se filtran como palabras completas, para no marcar "assessment" o "Scunthorpe"
+var defaultFilterTerms = []string{
+	"fuck", "fucking", "fucked", "fucker", "motherfucker", "shit", "shitty", "bullshit",
+	"asshole", "bitch", "bastard", "dick", "cunt", "piss", "pissed", "crap", "damn",
+	"goddamn", "wanker", "twat", "douchebag", "slut", "whore",
+	"kill yourself", "kys",
+}
+
+// ContentFilter detecta términos no aptos en la salida y los enmascara
+type ContentFilter struct {
+	Mode  string // off, mask, flag o block
+	terms *regexp.Regexp
+}
+
+// newContentFilter arma el filtro con la lista incorporada más los términos del
+// archivo de configuración y del archivo indicado (uno por línea, # comenta)
+func newContentFilter(mode string, extra []string, termsFile string) (*ContentFilter, error) {
+	mode = strings.ToLower(mode)
+	switch mode {
+	case "", "off":
+		return &ContentFilter{Mode: "off"}, nil
+	case "mask", "flag", "block":
+	default:
+		return nil, fmt.Errorf("invalid content filter '%s'. Must be: off, mask, flag or block", mode)
+	}
+
+	terms := append(append([]string(nil), defaultFilterTerms...), extra...)
+	if termsFile != "" {
+		data, err := os.ReadFile(termsFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading filter terms: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				terms = append(terms, line)
+			}
+		}
+	}
+
+	// Los términos más largos primero, para que "bullshit" gane sobre "shit"
+	sort.Slice(terms, func(i, j int) bool { return len(terms[i]) > len(terms[j]) })
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if term = strings.TrimSpace(term); term != "" {
+			quoted = append(quoted, strings.ReplaceAll(regexp.QuoteMeta(term), " ", `\s+`))
+		}
+	}
+	return &ContentFilter{
+		Mode:  mode,
+		terms: regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`),
+	}, nil
+}
+
+// filter aplica el filtro a la salida: en modo block devuelve un error con
+// código exitContent; en los demás modos informa los términos encontrados
+func (f *ContentFilter) filter(text string) (string, error) {
+	filtered, matches := f.apply(text)
+	if len(matches) == 0 {
+		return filtered, nil
+	}
+	switch f.Mode {
+	case "block":
+		err := newCLIError(exitContent, "content", fmt.Errorf("output blocked by content filter: %d flagged terms (%s)", len(matches), strings.Join(matches, ", ")))
+		err.Hint = "Use --content-filter mask to publish the summary with the terms masked"
+		return "", err
+	case "flag":
+		slog.Warn("Output contains flagged content", "terms", strings.Join(matches, ", "))
+	default:
+		slog.Info("Masked flagged terms in output", "count", len(matches))
+	}
+	return filtered, nil
+}
+
+// apply devuelve el texto (enmascarado en modo mask) y los términos encontrados,
+// ya enmascarados para no repetirlos en los logs
+func (f *ContentFilter) apply(text string) (string, []string) {
+	if f.terms == nil {
+		return text, nil
+	}
+	var matches []string
+	seen := map[string]bool{}
+	masked := f.terms.ReplaceAllStringFunc(text, func(match string) string {
+		m := maskTerm(match)
+		if key := strings.ToLower(m); !seen[key] {
+			seen[key] = true
+			matches = append(matches, m)
+		}
+		return m
+	})
+	if f.Mode == "mask" {
+		return masked, matches
+	}
+	return text, matches
+}
+
+// maskTerm conserva la primera letra de cada palabra y reemplaza el resto por asteriscos
+func maskTerm(term string) string {
+	var b strings.Builder
+	first := true
+	for _, r := range term {
+		switch {
+		case unicode.IsSpace(r):
+			first = true
+			b.WriteRune(r)
+		case first:
+			first = false
+			b.WriteRune(r)
+		default:
+			b.WriteRune('*')
+		}
+	}
+	return b.String()
+}
+
+// transcriptTurn es una intervención de una transcripción
+type transcriptTurn struct {
+	Speaker string
+	Text    string
+	// Timestamped indica que la línea empezaba con una marca de tiempo
+	Timestamped bool
+}
+
+// speakerLineRe reconoce una línea con etiqueta de hablante, con una marca de
+// tiempo opcional: "Alice: ...", "[00:01:15] Bob Smith: ...", "DR. LEE: ..."
+var speakerLineRe = regexp.MustCompile(`^(?:\[?\(?\d{1,2}:\d{2}(?::\d{2})?(?:\.\d+)?\)?\]?\s*(?:-\s*)?)?([A-Z][A-Za-z.'\-]*(?: [A-Z][A-Za-z.'\-]*){0,3})\s*:\s+(\S.*)$`)
+
+// parseTranscript reconoce una transcripción con etiquetas de hablante. Las
+// líneas sin etiqueta continúan la intervención anterior. Devuelve nil si el
+// documento no parece una transcripción (menos de dos hablantes, o la mayoría
+// de los párrafos sin etiqueta)
+func parseTranscript(document string) []transcriptTurn {
+	var turns []transcriptTurn
+	labeled, unlabeled := 0, 0
+	for _, line := range strings.Split(document, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if m := speakerLineRe.FindStringSubmatch(line); m != nil {
+			turns = append(turns, transcriptTurn{Speaker: m[1], Text: strings.TrimSpace(m[2]), Timestamped: !strings.HasPrefix(line, m[1])})
+			labeled++
+			continue
+		}
+		unlabeled++
+		if len(turns) > 0 {
+			turns[len(turns)-1].Text += " " + line
+		}
+	}
+	if len(transcriptSpeakers(turns)) < 2 || labeled < unlabeled {
+		return nil
+	}
+	return turns
+}
+
+// minDialogueAlternations es cuántos cambios de hablante hacen falta para
+// tratar como diálogo un texto sin marcas de tiempo
+const minDialogueAlternations = 3
+
+// isDialogue decide si conviene reescribir la transcripción en estilo
+// indirecto: un documento con unas pocas líneas "Nota: ..." o "Warning: ..."
+// también parece una transcripción, así que sin marcas de tiempo se exige que
+// los hablantes se alternen varias veces
+func isDialogue(turns []transcriptTurn) bool {
+	if turns == nil {
+		return false
+	}
+	timestamped, alternations := 0, 0
+	for i, turn := range turns {
+		if turn.Timestamped {
+			timestamped++
+		}
+		if i > 0 && turn.Speaker != turns[i-1].Speaker {
+			alternations++
+		}
+	}
+	return timestamped*2 >= len(turns) || alternations >= minDialogueAlternations
+}
+
+// transcriptSpeakers devuelve los hablantes en orden de primera aparición
+func transcriptSpeakers(turns []transcriptTurn) []string {
+	var speakers []string
+	seen := map[string]bool{}
+	for _, turn := range turns {
+		if !seen[turn.Speaker] {
+			seen[turn.Speaker] = true
+			speakers = append(speakers, turn.Speaker)
+		}
+	}
+	return speakers
+}
+
+// attributedText reescribe la transcripción en estilo indirecto para que el
+// modelo conserve a quién pertenece cada idea
+func attributedText(turns []transcriptTurn) string {
+	lines := make([]string, len(turns))
+	for i, turn := range turns {
+		lines[i] = fmt.Sprintf("%s said: %s", turn.Speaker, turn.Text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// speakerText junta todas las intervenciones de un hablante
+func speakerText(turns []transcriptTurn, speaker string) string {
+	var parts []string
+	for _, turn := range turns {
+		if turn.Speaker == speaker {
+			parts = append(parts, turn.Text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// glossaryEntry es un término del glosario con su definición
+type glossaryEntry struct {
+	Term       string
+	Definition string
+	// Explicit indica que la definición proviene del propio documento
+	Explicit bool
+	// Context es la oración donde aparece una sigla que el documento no
+	// define; con ella se pide la definición al modelo
+	Context string
+}
+
+// maxGlossaryQueries limita las siglas sin definición que se consultan al
+// modelo (una llamada por sigla); las demás se describen con su oración
+const maxGlossaryQueries = 20
+
+var (
+	// "Long Form (LF)"
+	acronymAfterRe = regexp.MustCompile(`((?:[A-Za-z][\w-]*\s+){1,8})\(([A-Z][A-Za-z0-9&]*[A-Z0-9])s?\)`)
+	// "LF (Long Form)"
+	acronymBeforeRe = regexp.MustCompile(`\b([A-Z][A-Za-z0-9&]*[A-Z0-9])s?\s+\(([A-Za-z][\w\s-]{3,80})\)`)
+	// "A sidecar is a ...", "Kubernetes refers to ...", "X means ..."
+	definitionRe = regexp.MustCompile(`^(?:(?:An?|The)\s+([a-z][\w-]*(?:\s+[a-z][\w-]*){0,2})|([A-Z][\w-]*(?:\s+[A-Za-z][\w-]*){0,3}?))\s+(?:is|are|refers to|means|describes)\s+((?:an?|the|any|one)\s+.+)$`)
+	// Siglas: dos o más mayúsculas (admite dígitos, "&" y plural con "s")
+	acronymRe = regexp.MustCompile(`\b([A-Z][A-Z0-9&]{1,9})s?\b`)
+)
+
+// minorWords se omiten al comparar iniciales de siglas ("Bank of America" → BoA/BA)
+var minorWords = map[string]bool{"of": true, "and": true, "for": true, "the": true, "a": true, "an": true,
+	"in": true, "on": true, "to": true, "&": true}
+
+// matchAcronym busca, al final de las palabras dadas, la expansión cuyas
+// iniciales forman la sigla. Devuelve la expansión o "" si no coincide
+func matchAcronym(words []string, acronym string) string {
+	letters := []rune(strings.ToUpper(strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, acronym)))
+
+	// Recorrer hacia atrás consumiendo una inicial por palabra significativa
+	i := len(letters) - 1
+	start := len(words)
+	for j := len(words) - 1; j >= 0 && i >= 0; j-- {
+		w := words[j]
+		if minorWords[strings.ToLower(w)] {
+			start = j
+			continue
+		}
+		first, _ := utf8.DecodeRuneInString(w)
+		if unicode.ToUpper(first) != letters[i] {
+			return ""
+		}
+		// Palabras compuestas ("Machine-Learning") pueden aportar varias iniciales
+		for _, part := range strings.Split(w, "-")[1:] {
+			if r, _ := utf8.DecodeRuneInString(part); i > 0 && unicode.ToUpper(r) == letters[i] {
+				i--
+			}
+		}
+		i--
+		start = j
+	}
+	if i >= 0 {
+		return ""
+	}
+	for start < len(words) && minorWords[strings.ToLower(words[start])] {
+		start++
+	}
+	return strings.Join(words[start:], " ")
+}
+
+// formatGlossary genera el glosario del documento (ver collectGlossary). Las
+// siglas que el texto no define se le consultan al modelo con la oración
+// donde aparecen; pasado maxGlossaryQueries se describen con esa oración
+func (c *APIClient) formatGlossary(document string) (string, error) {
+	terms, err := collectGlossary(document)
+	if err != nil {
+		return "", newCLIError(exitInput, "input", err)
+	}
+	queries := 0
+	lines := make([]string, len(terms))
+	for i, entry := range terms {
+		if entry.Definition == "" {
+			if queries < maxGlossaryQueries {
+				queries++
+				definition, err := c.summarizeText("Term: "+entry.Term+"\nPassage: "+entry.Context, "glossary")
+				if err != nil {
+					return "", fmt.Errorf("defining '%s': %w", entry.Term, err)
+				}
+				entry.Definition = firstSentence(definition)
+			} else {
+				entry.Definition = "Term used in the document: \"" + entry.Context + "\""
+			}
+		}
+		lines[i] = fmt.Sprintf("- %s: %s", entry.Term, entry.Definition)
+	}
+	if queries == maxGlossaryQueries {
+		slog.Warn("Too many undefined terms; the rest are described by their sentence", "queried", queries)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// collectGlossary identifica siglas y términos definidos en el documento,
+// ordenados alfabéticamente. Las definiciones provienen del propio texto
+// (expansiones de siglas y oraciones del tipo "X is a ..."); las siglas sin
+// definición quedan con Definition vacía y la oración donde aparecen en Context
+func collectGlossary(document string) ([]*glossaryEntry, error) {
+	entries := map[string]*glossaryEntry{}
+	add := func(term, definition string, explicit bool) {
+		term = strings.TrimSpace(term)
+		definition = strings.TrimSpace(strings.TrimRight(definition, ".;,"))
+		if term == "" || definition == "" {
+			return
+		}
+		key := strings.ToLower(term)
+		if existing, ok := entries[key]; ok && (existing.Explicit || !explicit) {
+			return
+		}
+		first, size := utf8.DecodeRuneInString(definition)
+		definition = string(unicode.ToUpper(first)) + definition[size:]
+		entries[key] = &glossaryEntry{Term: term, Definition: definition, Explicit: explicit}
+	}
+
+	var sentences []string
+	for _, paragraph := range paragraphs(document) {
+		if !strings.HasPrefix(paragraph, "#") {
+			sentences = append(sentences, splitSentences(paragraph)...)
+		}
+	}
+
+	for _, sentence := range sentences {
+		for _, m := range acronymAfterRe.FindAllStringSubmatch(sentence, -1) {
+			if expansion := matchAcronym(strings.Fields(m[1]), m[2]); expansion != "" {
+				add(m[2], expansion, true)
+			}
+		}
+		for _, m := range acronymBeforeRe.FindAllStringSubmatch(sentence, -1) {
+			if expansion := matchAcronym(strings.Fields(m[2]), m[1]); expansion != "" {
+				add(m[1], expansion, true)
+			}
+		}
+		if m := definitionRe.FindStringSubmatch(strings.TrimRight(sentence, ".")); m != nil {
+			term := m[1] + m[2]
+			if !stopWords[strings.ToLower(term)] {
+				add(term, m[3], true)
+			}
+		}
+	}
+
+	// Siglas que aparecen sin definición explícita
+	for _, sentence := range sentences {
+		for _, m := range acronymRe.FindAllStringSubmatch(sentence, -1) {
+			acronym := m[1]
+			if _, ok := entries[strings.ToLower(acronym)]; !ok && !isAllCapsSentence(sentence) {
+				entries[strings.ToLower(acronym)] = &glossaryEntry{Term: acronym, Context: sentence}
+			}
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no domain-specific terms or acronyms found in the document")
+	}
+
+	terms := make([]*glossaryEntry, 0, len(entries))
+	for _, entry := range entries {
+		terms = append(terms, entry)
+	}
+	sort.Slice(terms, func(i, j int) bool { return strings.ToLower(terms[i].Term) < strings.ToLower(terms[j].Term) })
+	return terms, nil
+}
+
+// isAllCapsSentence detecta oraciones escritas por completo en mayúsculas,
+// donde cada palabra parecería una sigla
+func isAllCapsSentence(sentence string) bool {
+	letters := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return r
+		}
+		return -1
+	}, sentence)
+	return len(letters) > 20 && strings.ToUpper(letters) == letters
+}
+
+// timelineEvent es un evento fechado extraído del documento
+type timelineEvent struct {
+	Date  time.Time
+	Label string
+	Text  string
+}
+
+// Patrones de fecha reconocidos, de más a menos preciso
+var (
+	monthNames   = `(January|February|March|April|May|June|July|August|September|October|November|December|Jan|Feb|Mar|Apr|Jun|Jul|Aug|Sep|Sept|Oct|Nov|Dec)\.?`
+	isoDateRe    = regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})\b`)
+	monthDayRe   = regexp.MustCompile(`\b` + monthNames + `\s+(\d{1,2})(?:st|nd|rd|th)?,?\s+(\d{4})\b`)
+	dayMonthRe   = regexp.MustCompile(`\b(\d{1,2})(?:st|nd|rd|th)?\s+(?:of\s+)?` + monthNames + `,?\s+(\d{4})\b`)
+	slashDateRe  = regexp.MustCompile(`\b(\d{1,2})/(\d{1,2})/(\d{4})\b`)
+	monthYearRe  = regexp.MustCompile(`\b` + monthNames + `\s+(?:of\s+)?(\d{4})\b`)
+	monthNumbers = map[string]time.Month{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+)
+
+// Un año suelto solo cuenta con contexto de fecha: una palabra temporal delante
+// ("in 2019", "since 1998", "mid-1990") o un rango ("1990-1995", "2019 to
+// 2021"). Así "2000 employees" o "1500 units" no aparecen como eventos
+var (
+	yearContextRe = regexp.MustCompile(`(?i)\b(?:in|since|during|until|till|circa|c\.|early|mid|late|year|fy)(?:\s+|-)(1[5-9]\d{2}|20\d{2})s?\b`)
+	yearRangeRe   = regexp.MustCompile(`\b(1[5-9]\d{2}|20\d{2})\s*(?:-|–|—|to|through)\s*(?:1[5-9]\d{2}|20\d{2}|\d{2})\b`)
+)
+
+// parseMonth convierte un nombre de mes (completo o abreviado) en time.Month
+func parseMonth(name string) time.Month {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if len(name) > 3 {
+		name = name[:3]
+	}
+	return monthNumbers[name]
+}
+
+// findDate busca la primera fecha reconocible en una oración y devuelve la
+// fecha junto con una etiqueta acorde a su precisión (día, mes o año)
+func findDate(sentence string) (time.Time, string, bool) {
+	day := func(year, month, d string) (time.Time, string, bool) {
+		y, _ := strconv.Atoi(year)
+		dd, _ := strconv.Atoi(d)
+		m := parseMonth(month)
+		if m == 0 {
+			if n, err := strconv.Atoi(month); err == nil && n >= 1 && n <= 12 {
+				m = time.Month(n)
+			}
+		}
+		if m == 0 || dd < 1 || dd > 31 {
+			return time.Time{}, "", false
+		}
+		date := time.Date(y, m, dd, 0, 0, 0, 0, time.UTC)
+		return date, date.Format("2006-01-02"), true
+	}
+
+	if m := isoDateRe.FindStringSubmatch(sentence); m != nil {
+		if date, label, ok := day(m[1], m[2], m[3]); ok {
+			return date, label, true
+		}
+	}
+	if m := monthDayRe.FindStringSubmatch(sentence); m != nil {
+		if date, label, ok := day(m[3], m[1], m[2]); ok {
+			return date, label, true
+		}
+	}
+	if m := dayMonthRe.FindStringSubmatch(sentence); m != nil {
+		if date, label, ok := day(m[3], m[2], m[1]); ok {
+			return date, label, true
+		}
+	}
+	// Las fechas numéricas se interpretan como mes/día/año
+	if m := slashDateRe.FindStringSubmatch(sentence); m != nil {
+		if date, label, ok := day(m[3], m[1], m[2]); ok {
+			return date, label, true
+		}
+	}
+	if m := monthYearRe.FindStringSubmatch(sentence); m != nil {
+		y, _ := strconv.Atoi(m[2])
+		date := time.Date(y, parseMonth(m[1]), 1, 0, 0, 0, 0, time.UTC)
+		return date, date.Format("January 2006"), true
+	}
+	for _, re := range []*regexp.Regexp{yearContextRe, yearRangeRe} {
+		if m := re.FindStringSubmatch(sentence); m != nil {
+			y, _ := strconv.Atoi(m[1])
+			return time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC), m[1], true
+		}
+	}
+	return time.Time{}, "", false
+}
+
+// paragraphs divide el documento en párrafos separados por líneas en blanco,
+// uniendo las líneas de cada párrafo
+func paragraphs(document string) []string {
+	var result []string
+	var current []string
+	for _, line := range strings.Split(document, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if len(current) > 0 {
+				result = append(result, strings.Join(current, " "))
+				current = nil
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	if len(current) > 0 {
+		result = append(result, strings.Join(current, " "))
+	}
+	return result
+}
+
+// formatTimeline extrae las oraciones fechadas del documento y las lista en
+// orden cronológico. Los eventos con la misma fecha conservan el orden original
+func formatTimeline(document string) (string, error) {
+	var events []timelineEvent
+	for _, paragraph := range paragraphs(document) {
+		// Los encabezados Markdown no describen eventos
+		if strings.HasPrefix(paragraph, "#") {
+			continue
+		}
+		for _, sentence := range splitSentences(paragraph) {
+			if date, label, ok := findDate(sentence); ok {
+				events = append(events, timelineEvent{Date: date, Label: label, Text: sentence})
+			}
+		}
+	}
+	if len(events) == 0 {
+		return "", fmt.Errorf("no dated events found in the document")
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Date.Before(events[j].Date) })
+
+	lines := make([]string, len(events))
+	for i, event := range events {
+		lines[i] = fmt.Sprintf("- %s: %s", event.Label, event.Text)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// minSectionSummaryLength es el tamaño mínimo de una sección para resumirla con
+// el modelo; las secciones más cortas usan su primera oración directamente
+const minSectionSummaryLength = 300
+
+// sectionNeedsModel indica si una sección es lo bastante larga para resumirla
+func sectionNeedsModel(section outlineSection) bool {
+	return len(section.Body) >= minSectionSummaryLength
+}
+
+// tableOfContents genera una tabla de contenidos anotada: la estructura de
+// encabezados del documento con un resumen de una oración por sección
+func (c *APIClient) tableOfContents(document string) (string, error) {
+	sections := detectSections(document)
+	if len(sections) == 0 {
+		return "", newCLIError(exitInput, "input", fmt.Errorf("no headings found in the document"))
+	}
+
+	labels, depths := numberSections(sections)
+	var b strings.Builder
+	for i, section := range sections {
+		oneLiner := ""
+		switch {
+		case sectionNeedsModel(section):
+			body := section.Body
+			if len(body) > maxInputLength {
+				slog.Debug("Section truncated", "section", section.Title, "original_chars", len(body), "max_chars", maxInputLength)
+				body = body[:maxInputLength]
+			}
+			summary, err := c.summarizeText(body, "toc")
+			if err != nil {
+				return "", fmt.Errorf("section '%s': %w", section.Title, err)
+			}
+			oneLiner = firstSentence(summary)
+		case section.Body != "":
+			oneLiner = firstSentence(section.Body)
+		}
+
+		indent := strings.Repeat("   ", depths[i])
+		if oneLiner == "" {
+			fmt.Fprintf(&b, "%s%s. %s\n", indent, labels[i], section.Title)
+		} else {
+			fmt.Fprintf(&b, "%s%s. %s — %s\n", indent, labels[i], section.Title, oneLiner)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// reportTemplate es la plantilla de --report. Las plantillas HTML usan
+// html/template, que escapa el texto de los resúmenes
+type reportTemplate struct {
+	tmpl      interface{ Execute(io.Writer, any) error }
+	Extension string
+}
+
+// reportFuncs son las funciones disponibles en las plantillas
+var reportFuncs = map[string]any{
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// loadReportTemplate lee y valida la plantilla; los errores de sintaxis se
+// informan antes de llamar al modelo
+func loadReportTemplate(path string) (*reportTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading report template: %w", err)
+	}
+	return parseReportTemplate(filepath.Base(path), string(data))
+}
+
+// parseReportTemplate compila y prueba una plantilla; name decide si es HTML
+func parseReportTemplate(name, data string) (*reportTemplate, error) {
+	var err error
+	report := &reportTemplate{Extension: ".md"}
+	if strings.Contains(strings.ToLower(name), ".htm") {
+		report.Extension = ".html"
+		report.tmpl, err = htmltemplate.New(name).Funcs(reportFuncs).Option("missingkey=error").Parse(data)
+	} else {
+		report.tmpl, err = template.New(name).Funcs(reportFuncs).Option("missingkey=error").Parse(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing report template: %w", err)
+	}
+	// Probar la plantilla sin red: los campos inexistentes fallan aquí y no
+	// después de haber llamado al modelo
+	empty := newReportData("", "medium", func(string) (string, error) { return "", nil })
+	if _, err := report.render(empty); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// render ejecuta la plantilla con los datos de un documento
+func (r *reportTemplate) render(data *reportData) (string, error) {
+	var b strings.Builder
+	if err := r.tmpl.Execute(&b, data); err != nil {
+		// Solo los errores de la propia plantilla son de uso. Los de sus
+		// funciones (la API en .Summary, un timeout o un token inválido) llegan
+		// envueltos por text/template y conservan su código de salida
+		var execErr template.ExecError
+		var htmlErr *htmltemplate.Error
+		if (errors.As(err, &execErr) && errors.Unwrap(execErr.Err) == nil) || errors.As(err, &htmlErr) {
+			return "", newCLIError(exitUsage, "usage", fmt.Errorf("report template: %w", err))
+		}
+		return "", classifyError(err)
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// reportData son los datos de una plantilla de --report. Cada tipo de resumen
+// se pide al modelo una sola vez, aunque la plantilla lo use varias veces
+type reportData struct {
+	document    string
+	summaryType string
+	summarize   func(summaryType string) (string, error)
+	cache       map[string]string
+}
+
+func newReportData(document, summaryType string, summarize func(string) (string, error)) *reportData {
+	return &reportData{document: document, summaryType: summaryType, summarize: summarize, cache: map[string]string{}}
+}
+
+// Title es el título del documento
+func (d *reportData) Title() string {
+	return extractTitle(d.document)
+}
+
+// Summary es el resumen del tipo indicado, o del tipo de --type si no se indica
+func (d *reportData) Summary(summaryType ...string) (string, error) {
+	t := d.summaryType
+	if len(summaryType) > 0 {
+		t = summaryType[0]
+	}
+	if !isValidSummaryType(t) {
+		return "", newCLIError(exitUsage, "usage", fmt.Errorf("report template: unknown summary type '%s'", t))
+	}
+	if summary, ok := d.cache[t]; ok {
+		return summary, nil
+	}
+	summary, err := d.summarize(t)
+	if err != nil {
+		return "", err
+	}
+	d.cache[t] = summary
+	return summary, nil
+}
+
+// KeyPoints son los puntos del resumen en viñetas, sin el guion
+func (d *reportData) KeyPoints() ([]string, error) {
+	summary, err := d.Summary("bullet")
+	if err != nil {
+		return nil, err
+	}
+	var points []string
+	for _, line := range strings.Split(summary, "\n") {
+		if line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "- ")); line != "" {
+			points = append(points, line)
+		}
+	}
+	return points, nil
+}
+
+// Keywords son las n palabras significativas más frecuentes del documento
+func (d *reportData) Keywords(n int) []string {
+	return extractKeywords(d.document, n)
+}
+
+// Entities son los nombres propios del documento
+func (d *reportData) Entities() []string {
+	return extractEntities(d.document)
+}
+
+// Sentiment es el tono general del documento
+func (d *reportData) Sentiment() string {
+	return classifySentiment(d.document)
+}
+
+// Words es la cantidad de palabras del documento
+func (d *reportData) Words() int {
+	return len(strings.Fields(d.document))
+}
+
+// localTasks son las tareas de --tasks que se resuelven sin el modelo; los
+// tipos de resumen (--type) también son tareas válidas
+var localTasks = []string{"title", "keywords", "entities", "sentiment"}
+
+// taskResult es el resultado de una tarea de --tasks
+type taskResult struct {
+	Task  string
+	Value interface{}
+}
+
+// taskError es el resultado de una tarea que no pudo completarse
+type taskError struct {
+	Error string `json:"error"`
+}
+
+// parseTasks valida la lista de --tasks y quita las repetidas
+func parseTasks(list string) ([]string, error) {
+	var tasks []string
+	seen := map[string]bool{}
+	for _, task := range strings.Split(list, ",") {
+		task = strings.ToLower(strings.TrimSpace(task))
+		if task == "" || seen[task] {
+			continue
+		}
+		known := task == "summary" || isValidSummaryType(task)
+		for _, t := range localTasks {
+			known = known || t == task
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown task '%s'. Valid tasks: summary, %s, or a summary type (%s)",
+				task, strings.Join(localTasks, ", "), strings.Join(summaryTypes, ", "))
+		}
+		seen[task] = true
+		tasks = append(tasks, task)
+	}
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("--tasks needs at least one task")
+	}
+	return tasks, nil
+}
+
+// runTasks ejecuta las tareas en orden sobre los datos de un documento; los
+// resúmenes repetidos (summary y el tipo de --type) se piden una sola vez
+func runTasks(tasks []string, data *reportData) ([]taskResult, error) {
+	results := make([]taskResult, 0, len(tasks))
+	for _, task := range tasks {
+		var value interface{}
+		var err error
+		switch task {
+		case "title":
+			value = data.Title()
+		case "keywords":
+			value = data.Keywords(10)
+		case "entities":
+			value = data.Entities()
+		case "sentiment":
+			value = data.Sentiment()
+		case "summary":
+			value, err = data.Summary()
+		default:
+			value, err = data.Summary(task)
+		}
+		if err != nil && classifyError(err).Code == exitInput {
+			// Una extracción sin resultados (p. ej. timeline sin fechas) no
+			// invalida las demás tareas
+			value = taskError{Error: classifyError(err).Err.Error()}
+		} else if err != nil {
+			return nil, fmt.Errorf("task %s: %w", task, err)
+		}
+		results = append(results, taskResult{Task: task, Value: value})
+	}
+	return results, nil
+}
+
+// formatTaskResults muestra un bloque por tarea; las listas van en una línea
+func formatTaskResults(results []taskResult) string {
+	var b strings.Builder
+	for i, r := range results {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		label := strings.ToUpper(r.Task[:1]) + r.Task[1:]
+		switch value := r.Value.(type) {
+		case []string:
+			fmt.Fprintf(&b, "%s: %s\n", label, strings.Join(value, ", "))
+		case taskError:
+			fmt.Fprintf(&b, "%s: (%s)\n", label, value.Error)
+		case string:
+			if strings.Contains(value, "\n") || len(value) > 60 {
+				fmt.Fprintf(&b, "%s:\n%s\n", label, value)
+			} else {
+				fmt.Fprintf(&b, "%s: %s\n", label, value)
+			}
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// extractKeywords devuelve las n palabras significativas más frecuentes
+func extractKeywords(document string, n int) []string {
+	counts := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(document), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if _, err := strconv.Atoi(word); err == nil {
+			continue
+		}
+		if len(word) > 3 && !stopWords[word] {
+			counts[word]++
+		}
+	}
+	keywords := make([]string, 0, len(counts))
+	for word := range counts {
+		keywords = append(keywords, word)
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		if counts[keywords[i]] != counts[keywords[j]] {
+			return counts[keywords[i]] > counts[keywords[j]]
+		}
+		return keywords[i] < keywords[j]
+	})
+	if len(keywords) > n {
+		keywords = keywords[:n]
+	}
+	return keywords
+}
+
+// summaryNode es un nodo del árbol de --hierarchical: el documento, un
+// capítulo o una sección, con su resumen y el de sus partes
+type summaryNode struct {
+	Title   string `json:"title"`
+	Level   int    `json:"level"`
+	Summary string `json:"summary"`
+	// Partial marca los resúmenes armados sin el modelo al vencer --deadline
+	Partial  bool           `json:"partial,omitempty"`
+	Children []*summaryNode `json:"children,omitempty"`
+	// text es el texto propio del nodo (sin el de sus hijos)
+	text string
+}
+
+// hierarchicalFanIn es cuántos fragmentos se agrupan en una parte cuando el
+// documento no tiene encabezados
+const hierarchicalFanIn = 10
+
+// planSummaryTree arma el árbol a partir de los encabezados del documento. Sin
+// encabezados, el texto se divide en fragmentos agrupados en partes. Los
+// niveles por debajo de depth se funden en su ancestro
+func planSummaryTree(document string, depth int) *summaryNode {
+	root := &summaryNode{Title: extractTitle(document), Level: 0}
+	preamble, sections := splitSections(document)
+	if len(sections) == 0 {
+		chunks := splitChunks(document, maxInputLength)
+		if len(chunks) <= hierarchicalFanIn {
+			for i, chunk := range chunks {
+				root.Children = append(root.Children, &summaryNode{Title: fmt.Sprintf("Part %d", i+1), Level: 1, text: chunk})
+			}
+		} else {
+			for i := 0; i < len(chunks); i += hierarchicalFanIn {
+				end := i + hierarchicalFanIn
+				if end > len(chunks) {
+					end = len(chunks)
+				}
+				root.Children = append(root.Children, &summaryNode{
+					Title: fmt.Sprintf("Part %d", len(root.Children)+1),
+					Level: 1,
+					text:  strings.Join(chunks[i:end], "\n\n"),
+				})
+			}
+		}
+		if len(root.Children) == 1 {
+			root.text, root.Children = root.Children[0].text, nil
+		}
+	} else {
+		// El texto anterior al primer encabezado es el texto propio del documento
+		root.text = preamble
+		// Cada encabezado cuelga del último encabezado de nivel menor
+		stack := []*summaryNode{root}
+		for _, section := range sections {
+			for len(stack) > 1 && stack[len(stack)-1].Level >= section.Level {
+				stack = stack[:len(stack)-1]
+			}
+			node := &summaryNode{Title: section.Title, Level: len(stack), text: section.Body}
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, node)
+			stack = append(stack, node)
+		}
+		// Un único encabezado de primer nivel es el título del documento
+		if len(root.Children) == 1 {
+			child := root.Children[0]
+			child.text = strings.TrimSpace(root.text + "\n\n" + child.text)
+			root = child
+			shiftLevels(root, -1)
+		}
+	}
+	if depth > 0 {
+		pruneTree(root, depth)
+	}
+	return root
+}
+
+// shiftLevels desplaza el nivel de un nodo y de todos sus descendientes
+func shiftLevels(node *summaryNode, delta int) {
+	node.Level += delta
+	for _, child := range node.Children {
+		shiftLevels(child, delta)
+	}
+}
+
+// pruneTree funde en cada nodo del nivel depth el texto de sus descendientes
+func pruneTree(node *summaryNode, depth int) {
+	if node.Level >= depth {
+		node.text = strings.TrimSpace(collectText(node))
+		node.Children = nil
+		return
+	}
+	for _, child := range node.Children {
+		pruneTree(child, depth)
+	}
+}
+
+// collectText devuelve el texto de un nodo y de todos sus descendientes
+func collectText(node *summaryNode) string {
+	parts := []string{node.text}
+	for _, child := range node.Children {
+		parts = append(parts, child.Title+". "+collectText(child))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// splitChunks divide un texto en fragmentos de hasta size caracteres sin
+// cortar oraciones (salvo las que por sí solas superan el tamaño)
+func splitChunks(text string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+	for _, paragraph := range paragraphs(text) {
+		for _, sentence := range splitSentences(paragraph) {
+			for len(sentence) > size {
+				if current.Len() > 0 {
+					chunks = append(chunks, current.String())
+					current.Reset()
+				}
+				chunks = append(chunks, sentence[:size])
+				sentence = sentence[size:]
+			}
+			if current.Len() > 0 && current.Len()+1+len(sentence) > size {
+				chunks = append(chunks, current.String())
+				current.Reset()
+			}
+			if current.Len() > 0 {
+				current.WriteString(" ")
+			}
+			current.WriteString(sentence)
+		}
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
+
+// countSummaryCalls cuenta las llamadas al modelo que hará summarizeTree. Con
+// compact, cada texto largo se resume en una sola llamada
+func countSummaryCalls(node *summaryNode, compact bool) int {
+	calls, parts := 0, 0
+	if own := strings.TrimSpace(node.text); own != "" {
+		parts++
+		if len(own) >= minSectionSummaryLength {
+			chunks := len(splitChunks(own, maxInputLength))
+			calls += chunks
+			if chunks > 1 && compact {
+				calls = calls - chunks + 1
+			} else if chunks > 1 {
+				calls += combineCalls(chunks)
+			}
+		}
+	}
+	for _, child := range node.Children {
+		calls += countSummaryCalls(child, compact)
+		if hasContent(child) {
+			parts++
+		}
+	}
+	if parts > 1 {
+		calls += combineCalls(parts)
+	}
+	return calls
+}
+
+// hasContent indica si un nodo o alguno de sus descendientes tiene texto
+func hasContent(node *summaryNode) bool {
+	if strings.TrimSpace(node.text) != "" {
+		return true
+	}
+	for _, child := range node.Children {
+		if hasContent(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// countNodes cuenta los nodos del árbol
+func countNodes(node *summaryNode) int {
+	n := 1
+	for _, child := range node.Children {
+		n += countNodes(child)
+	}
+	return n
+}
+
+// treeHeight devuelve la cantidad de niveles del árbol
+func treeHeight(node *summaryNode) int {
+	height := 0
+	for _, child := range node.Children {
+		if h := treeHeight(child); h > height {
+			height = h
+		}
+	}
+	return height + 1
+}
+
+// treeRun es una ejecución de --hierarchical: el cliente, el tipo de resumen,
+// el progreso y, con --deadline, el presupuesto de tiempo
+type treeRun struct {
+	client      *APIClient
+	summaryType string
+	progress    *progressReporter
+	budget      *treeBudget
+	// compact resume cada texto largo en una sola llamada, sobre una muestra
+	compact bool
+}
+
+// errDeadlineReached indica que no queda tiempo para otra llamada al modelo
+var errDeadlineReached = errors.New("deadline reached")
+
+// call resume un fragmento, salvo que la llamada no alcance a terminar antes
+// del plazo
+func (r *treeRun) call(text string) (string, error) {
+	if r.budget.exhausted() {
+		return "", errDeadlineReached
+	}
+	summary, err := r.client.summarizeText(text, r.summaryType)
+	if err != nil {
+		return "", err
+	}
+	r.progress.Advance(1)
+	return summary, nil
+}
+
+// summarizeTree resume el árbol de abajo hacia arriba: cada nodo combina el
+// resumen de su texto propio con los resúmenes de sus hijos
+func (r *treeRun) summarizeTree(node *summaryNode) error {
+	var parts []string
+	if own := strings.TrimSpace(node.text); own != "" {
+		summary, err := r.reduceText(own)
+		if err != nil {
+			return fmt.Errorf("%s: %w", node.Title, err)
+		}
+		parts = append(parts, summary)
+	}
+	for _, child := range node.Children {
+		if err := r.summarizeTree(child); err != nil {
+			return err
+		}
+		if child.Summary != "" {
+			parts = append(parts, child.Summary)
+		}
+	}
+
+	switch len(parts) {
+	case 0:
+		return nil
+	case 1:
+		node.Summary = parts[0]
+	default:
+		summary, err := r.combineSummaries(parts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", node.Title, err)
+		}
+		node.Summary = summary
+	}
+	return nil
+}
+
+// reduceText resume un texto de cualquier largo: los textos breves se usan tal
+// cual y los largos se dividen en fragmentos cuyos resúmenes se combinan. Si el
+// tiempo no alcanza para todos los fragmentos, se resume en una sola llamada
+// una muestra de cada uno
+func (r *treeRun) reduceText(text string) (string, error) {
+	if len(text) < minSectionSummaryLength {
+		return text, nil
+	}
+	chunks := splitChunks(text, maxInputLength)
+	if len(chunks) > 1 && !r.compact && r.budget.tight(r.progress) {
+		saved := len(chunks) + combineCalls(len(chunks)) - 1
+		slog.Info("Short on time: summarizing a sample of the section in one call", "chunks", len(chunks), "calls_saved", saved)
+		r.progress.Total -= saved
+		return r.call(sampleChunks(chunks, maxInputLength))
+	}
+	if len(chunks) > 1 && r.compact {
+		return r.call(sampleChunks(chunks, maxInputLength))
+	}
+	summaries := make([]string, 0, len(chunks))
+	for _, chunk := range chunks {
+		summary, err := r.call(chunk)
+		if err != nil {
+			return "", err
+		}
+		summaries = append(summaries, summary)
+	}
+	if len(summaries) == 1 {
+		return summaries[0], nil
+	}
+	return r.combineSummaries(summaries)
+}
+
+// combineSummaries resume varios resúmenes en uno. Cada solicitud combina a lo
+// sumo combineFanIn resúmenes, recortados para que entren juntos; si hay más,
+// se combinan por grupos y luego se combinan los grupos
+func (r *treeRun) combineSummaries(summaries []string) (string, error) {
+	for len(summaries) > combineFanIn {
+		var combined []string
+		for i := 0; i < len(summaries); i += combineFanIn {
+			end := i + combineFanIn
+			if end > len(summaries) {
+				end = len(summaries)
+			}
+			summary, err := r.call(joinClipped(summaries[i:end]))
+			if err != nil {
+				return "", err
+			}
+			combined = append(combined, summary)
+		}
+		summaries = combined
+	}
+	return r.call(joinClipped(summaries))
+}
+
+// combineFanIn es cuántos resúmenes entran en una solicitud con al menos
+// minCombineShare caracteres cada uno
+const (
+	minCombineShare = 200
+	combineFanIn    = maxInputLength / minCombineShare
+)
+
+// joinClipped une un grupo de resúmenes para una solicitud, dando a cada uno
+// la misma parte de la entrada
+func joinClipped(summaries []string) string {
+	share := maxInputLength/len(summaries) - 2
+	parts := make([]string, len(summaries))
+	for i, summary := range summaries {
+		parts[i] = clipText(summary, share)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// minSampleShare es lo mínimo que sampleChunks toma de cada fragmento
+const minSampleShare = 40
+
+// sampleChunks arma una entrada de hasta size caracteres con el comienzo de
+// cada fragmento, para cubrir toda la sección en una sola llamada. Si son
+// demasiados para minSampleShare caracteres cada uno, se toman fragmentos
+// repartidos a lo largo de la sección. Los pasajes prioritarios entran
+// completos antes que la muestra
+func sampleChunks(chunks []string, size int) string {
+	if joined := strings.Join(chunks, "\n\n"); strings.Contains(joined, priorityMarker) {
+		return fitPriority(joined, size)
+	}
+	if n := max(size/(minSampleShare+1), 1); len(chunks) > n {
+		sampled := make([]string, n)
+		for i := range sampled {
+			sampled[i] = chunks[i*len(chunks)/n]
+		}
+		chunks = sampled
+	}
+	share := max(size/len(chunks)-1, 1)
+	parts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		parts[i] = clipText(chunk, share)
+	}
+	return strings.Join(parts, " ")
+}
+
+// treeBudget reparte el tiempo de --deadline entre las llamadas del árbol
+type treeBudget struct {
+	deadline time.Time
+	metrics  *RunMetrics
+}
+
+// defaultCallEstimate es la duración supuesta de una llamada mientras no haya
+// latencias medidas
+const defaultCallEstimate = 2 * time.Second
+
+// perCall estima la duración de una llamada con el percentil 90 medido
+func (b *treeBudget) perCall() time.Duration {
+	if d := b.metrics.percentile(90); d > 0 {
+		return d
+	}
+	return defaultCallEstimate
+}
+
+// affordable devuelve cuántas llamadas entran en el tiempo que queda
+func (b *treeBudget) affordable() int {
+	return int(time.Until(b.deadline) / b.perCall())
+}
 
-	flag.StringVar(&summaryType, "type", "medium", "Summary type: short, medium, or bullet")
-	flag.StringVar(&summaryType, "t", "medium", "Summary type: short, medium, or bullet (shorthand)")
-	flag.StringVar(&inputFile, "input", "", "Path to the text file to summarize")
+// exhausted indica que otra llamada no terminaría antes del plazo. Sin
+// latencias medidas solo se corta al vencer el plazo
+func (b *treeBudget) exhausted() bool {
+	if b == nil {
+		return false
+	}
+	if b.metrics.percentile(90) == 0 {
+		return !time.Now().Before(b.deadline)
+	}
+	return b.affordable() < 1
+}
 
-	flag.Parse()
+// tight indica que las llamadas pendientes no entran en el tiempo que queda
+func (b *treeBudget) tight(progress *progressReporter) bool {
+	return b != nil && b.affordable() < progress.Total-progress.done
+}
 
-	// Handle positional argument if --input not provided
-	if inputFile == "" {
-		args := flag.Args()
-		if len(args) > 0 {
-			inputFile = args[0]
-		} else {
-			fmt.Println("Error: No input file specified")
-			fmt.Println("Usage: go run solution_summarizer.go --input <file> --type <short|medium|bullet>")
-			fmt.Println("   or: go run solution_summarizer.go -t <short|medium|bullet> <file>")
-			os.Exit(1)
+// fit elige el plan que entra en el plazo: primero resume cada sección larga en
+// una sola llamada y, si no alcanza, funde los niveles más profundos
+func (b *treeBudget) fit(document string, depth int) (*summaryNode, bool) {
+	tree := planSummaryTree(document, depth)
+	if countSummaryCalls(tree, false) <= b.affordable() {
+		return tree, false
+	}
+	for d := treeHeight(tree) - 2; d >= 1 && countSummaryCalls(tree, true) > b.affordable(); d-- {
+		tree = planSummaryTree(document, d)
+	}
+	return tree, true
+}
+
+// fillPartial completa los nodos que quedaron sin resumir al vencer el plazo
+// con lo que ya resumieron sus hijos, o con el comienzo de su texto
+func fillPartial(node *summaryNode) {
+	var parts []string
+	for _, child := range node.Children {
+		fillPartial(child)
+		if child.Summary != "" {
+			parts = append(parts, child.Summary)
 		}
 	}
+	if node.Summary != "" {
+		return
+	}
+	node.Partial = true
+	if len(parts) > 0 {
+		node.Summary = strings.Join(strings.Split(joinClipped(parts), "\n\n"), " ")
+	} else {
+		node.Summary = clipText(strings.TrimSpace(node.text), minCombineShare)
+	}
+}
 
-	// Validate summary type
-	summaryType = strings.ToLower(summaryType)
-	if summaryType != "short" && summaryType != "medium" && summaryType != "bullet" {
-		fmt.Printf("Error: Invalid summary type '%s'. Must be: short, medium, or bullet\n", summaryType)
-		os.Exit(1)
+// combineCalls devuelve cuántas solicitudes hace combineSummaries con n resúmenes
+func combineCalls(n int) int {
+	calls := 0
+	for n > combineFanIn {
+		n = (n + combineFanIn - 1) / combineFanIn
+		calls += n
 	}
+	return calls + 1
+}
 
-	// Leer el archivo de entrada
-	content, err := readFile(inputFile)
-	if err != nil {
-		fmt.Printf("Error reading file '%s': %v\n", inputFile, err)
-		os.Exit(1)
+// clipText recorta un texto a size caracteres, en el último fin de oración o
+// espacio si lo hay
+func clipText(text string, size int) string {
+	if size <= 0 {
+		return ""
+	}
+	if len(text) <= size {
+		return text
+	}
+	cut := text[:size]
+	ends := sentenceEnds(text[:size+1])
+	for i := len(ends) - 1; i >= 0 && ends[i] > size/2; i-- {
+		if ends[i] <= size {
+			return cut[:ends[i]]
+		}
+	}
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		return cut[:i]
 	}
+	return cut
+}
 
-	// Truncar contenido si es muy largo
-	if len(content) > maxInputLength {
-		content = content[:maxInputLength]
-		fmt.Fprintf(os.Stderr, "Warning: Input truncated to %d characters\n", maxInputLength)
+// formatSummaryTree muestra el árbol como texto con sangría, como Markdown con
+// un encabezado por nivel, o como JSON
+func formatSummaryTree(root *summaryNode, summaryType, outputFormat string) string {
+	if outputFormat == "json" {
+		data, _ := json.MarshalIndent(root, "", "  ")
+		return string(data)
 	}
 
-	// Generar resumen
-	summary, err := summarizeText(content, summaryType, apiToken)
-	if err != nil {
-		fmt.Printf("Error generating summary: %v\n", err)
-		os.Exit(1)
+	var b strings.Builder
+	var walk func(node *summaryNode, label string)
+	walk = func(node *summaryNode, label string) {
+		summary := formatOutput(node.Summary, summaryType)
+		title := node.Title
+		if node.Partial {
+			title += " (partial)"
+		}
+		if outputFormat == "markdown" {
+			level := node.Level + 1
+			if level > 6 {
+				level = 6
+			}
+			if node.Level == 0 {
+				title = "Summary: " + title
+			}
+			fmt.Fprintf(&b, "%s %s\n\n", strings.Repeat("#", level), title)
+			if summary != "" {
+				fmt.Fprintf(&b, "%s\n\n", summary)
+			}
+		} else {
+			indent := strings.Repeat("   ", node.Level)
+			if node.Level == 0 {
+				fmt.Fprintf(&b, "%s\n", title)
+			} else {
+				fmt.Fprintf(&b, "%s%s. %s\n", strings.Repeat("   ", node.Level-1), label, title)
+			}
+			for _, line := range strings.Split(summary, "\n") {
+				if line != "" {
+					fmt.Fprintf(&b, "%s%s\n", indent, line)
+				}
+			}
+			b.WriteString("\n")
+		}
+		for i, child := range node.Children {
+			childLabel := strconv.Itoa(i + 1)
+			if label != "" {
+				childLabel = label + "." + childLabel
+			}
+			walk(child, childLabel)
+		}
 	}
+	walk(root, "")
+	return strings.TrimRight(b.String(), "\n")
+}
 
-	// Mostrar el resumen
-	fmt.Println(summary)
+// speakerSummary es el resumen de las intervenciones de un participante
+type speakerSummary struct {
+	Speaker string `json:"speaker"`
+	Turns   int    `json:"turns"`
+	Summary string `json:"summary"`
 }
 
-// readFile lee todo el contenido de un archivo de texto
-func readFile(filePath string) (string, error) {
-	// Verificar si el archivo existe
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return "", fmt.Errorf("file does not exist: %s", filePath)
+// summarizeSpeakers resume por separado lo que dijo cada participante. Las
+// intervenciones breves se muestran tal cual, sin llamar al modelo
+func (c *APIClient) summarizeSpeakers(turns []transcriptTurn, summaryType string) ([]speakerSummary, error) {
+	var result []speakerSummary
+	for _, speaker := range transcriptSpeakers(turns) {
+		text := speakerText(turns, speaker)
+		entry := speakerSummary{Speaker: speaker, Summary: text}
+		for _, turn := range turns {
+			if turn.Speaker == speaker {
+				entry.Turns++
+			}
+		}
+		if len(text) >= minSectionSummaryLength {
+			if len(text) > maxInputLength {
+				slog.Debug("Speaker contributions truncated", "speaker", speaker, "original_chars", len(text), "max_chars", maxInputLength)
+				text = text[:maxInputLength]
+			}
+			summary, err := c.summarizeText(text, summaryType)
+			if err != nil {
+				return nil, fmt.Errorf("speaker '%s': %w", speaker, err)
+			}
+			entry.Summary = formatOutput(summary, summaryType)
+		}
+		result = append(result, entry)
 	}
+	return result, nil
+}
 
-	// Leer contenido del archivo
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to read file: %w", err)
+// formatSpeakerSummaries muestra un bloque por participante
+func formatSpeakerSummaries(summaries []speakerSummary) string {
+	var b strings.Builder
+	for _, s := range summaries {
+		fmt.Fprintf(&b, "%s (turns: %d):\n%s\n\n", s.Speaker, s.Turns, s.Summary)
 	}
+	return strings.TrimRight(b.String(), "\n")
+}
 
-	// Asegurar que el archivo no esté vacío
-	content := strings.TrimSpace(string(data))
-	if content == "" {
-		return "", fmt.Errorf("file is empty")
+// firstSentence devuelve la primera oración de un texto
+func firstSentence(text string) string {
+	if sentences := splitSentences(text); len(sentences) > 0 {
+		return sentences[0]
 	}
-
-	return content, nil
+	return strings.TrimSpace(text)
 }
 
-// summarizeText llama a la API de HuggingFace para generar un resumen según el tipo especificado
-// Implementa lógica de reintentos con backoff exponencial para manejar límites de tasa y errores transitorios
-func summarizeText(text, summaryType, apiToken string) (string, error) {
-	var lastErr error
-
-	// Bucle de reintentos con backoff exponencial
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		if attempt > 0 {
-			// Calcular retraso de backoff exponencial
-			delay := initialRetryDelay * time.Duration(1<<uint(attempt-1))
-			fmt.Fprintf(os.Stderr, "Retrying in %v... (attempt %d/%d)\n", delay, attempt+1, maxRetries)
-			time.Sleep(delay)
-		}
+// stopWords son palabras frecuentes que no aportan al solapamiento léxico
+var stopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "and": true, "or": true, "but": true, "of": true, "to": true, "in": true,
+	"on": true, "for": true, "with": true, "at": true, "by": true, "from": true, "as": true, "is": true, "are": true,
+	"was": true, "were": true, "be": true, "been": true, "it": true, "its": true, "this": true, "that": true,
+	"these": true, "those": true, "has": true, "have": true, "had": true, "not": true, "they": true, "their": true,
+	"he": true, "she": true, "his": true, "her": true, "we": true, "our": true, "you": true, "your": true,
+	"will": true, "would": true, "can": true, "could": true, "also": true, "which": true, "who": true,
+}
 
-		summary, err := attemptSummarization(text, summaryType, apiToken)
-		if err == nil {
-			return summary, nil
+// contentWords devuelve el conjunto de palabras significativas de un texto
+func contentWords(text string) map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) > 2 && !stopWords[word] {
+			words[word] = true
 		}
+	}
+	return words
+}
 
-		lastErr = err
-
-		// Verificar si el error es reintentable (límite de tasa o error de servidor)
-		if !isRetryableError(err) {
-			return "", err
+// lexicalOverlap mide la similitud entre dos conjuntos de palabras como la
+// fracción de palabras de a que también aparecen en b
+func lexicalOverlap(a, b map[string]bool) float64 {
+	if len(a) == 0 {
+		return 0
+	}
+	shared := 0
+	for word := range a {
+		if b[word] {
+			shared++
 		}
 	}
+	return float64(shared) / float64(len(a))
+}
 
-	return "", fmt.Errorf("failed after %d attempts: %w", maxRetries, lastErr)
+// StructuredSummary es el resumen tipado que produce --format structured
+type StructuredSummary struct {
+	Title     string   `json:"title"`
+	OneLiner  string   `json:"one_liner"`
+	KeyPoints []string `json:"key_points"`
+	Entities  []string `json:"entities"`
+	Sentiment string   `json:"sentiment"`
 }
 
-// attemptSummarization realiza un único intento de llamar a la API
-func attemptSummarization(text, summaryType, apiToken string) (string, error) {
-	// Preparar el prompt según el tipo de resumen
-	prompt := buildPrompt(text, summaryType)
+// structuredSummarySchema es el JSON Schema contra el que se valida la salida estructurada
+const structuredSummarySchema = `{
+  "type": "object",
+  "required": ["title", "one_liner", "key_points", "entities", "sentiment"],
+  "properties": {
+    "title": {"type": "string"},
+    "one_liner": {"type": "string", "minLength": 1},
+    "key_points": {"type": "array", "items": {"type": "string", "minLength": 1}},
+    "entities": {"type": "array", "items": {"type": "string", "minLength": 1}},
+    "sentiment": {"type": "string", "enum": ["positive", "negative", "neutral", "mixed"]}
+  }
+}`
 
-	// Crear payload de solicitud
-	requestBody := HuggingFaceRequest{
-		Inputs: prompt,
-		Parameters: map[string]interface{}{
-			"max_length": getMaxLength(summaryType),
-			"min_length": getMinLength(summaryType),
-		},
-	}
+// structuredType es el tipo interno con el que se pide la salida estructurada
+// a los modelos de chat
+const structuredType = "structured"
 
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+// structuredMaxTokens limita la respuesta JSON de los modelos de chat
+const structuredMaxTokens = 600
+
+// parseStructuredSummary decodifica el objeto JSON devuelto por un modelo de
+// chat (con o sin bloque de código Markdown) y lo valida con el schema
+func parseStructuredSummary(output string) (*StructuredSummary, error) {
+	output = strings.TrimSpace(output)
+	if fenced, ok := strings.CutPrefix(output, "```"); ok {
+		fenced = strings.TrimPrefix(fenced, "json")
+		output = strings.TrimSpace(strings.TrimSuffix(fenced, "```"))
+	}
+	var raw interface{}
+	if err := json.Unmarshal([]byte(output), &raw); err != nil {
+		return nil, fmt.Errorf("model did not return a JSON object: %w", err)
 	}
+	if _, isObject := raw.(map[string]interface{}); !isObject {
+		return nil, fmt.Errorf("model did not return a JSON object")
+	}
+	if err := validateAgainstSchema(raw, structuredSummarySchema); err != nil {
+		return nil, fmt.Errorf("model returned JSON that does not match the schema: %w", err)
+	}
+	var structured StructuredSummary
+	if err := json.Unmarshal([]byte(output), &structured); err != nil {
+		return nil, err
+	}
+	return &structured, nil
+}
 
-	// Crear solicitud HTTP
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+// buildStructuredSummary arma el resumen estructurado a partir del resumen de
+// un modelo de resumen (como BART), que devuelve texto libre: los campos se
+// derivan del resumen y del documento original con heurísticas locales. Si el
+// resumen ya es un objeto JSON se usa tal cual. En ambos casos el resultado se
+// valida con el schema
+func buildStructuredSummary(summary, document string) (*StructuredSummary, error) {
+	var raw interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(summary)), &raw); err == nil {
+		if _, isObject := raw.(map[string]interface{}); isObject {
+			return parseStructuredSummary(summary)
+		}
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiToken)
+	keyPoints := splitSentences(summary)
+	if keyPoints == nil {
+		keyPoints = []string{}
+	}
+	oneLiner := strings.TrimSpace(summary)
+	if len(keyPoints) > 0 {
+		oneLiner = keyPoints[0]
+	}
 
-	// Crear cliente HTTP con timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	structured := &StructuredSummary{
+		Title:     extractTitle(document),
+		OneLiner:  oneLiner,
+		KeyPoints: keyPoints,
+		Entities:  extractEntities(document),
+		Sentiment: classifySentiment(document),
 	}
 
-	// Ejecutar solicitud
-	resp, err := client.Do(req)
+	// Validar el resultado derivado con el mismo schema
+	data, err := json.Marshal(structured)
 	if err != nil {
-		return "", fmt.Errorf("API request failed: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if err := validateAgainstSchema(raw, structuredSummarySchema); err != nil {
+		return nil, err
+	}
+	return structured, nil
+}
 
-	// Leer cuerpo de la respuesta
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+// validateAgainstSchema valida un valor JSON decodificado contra un schema.
+// Soporta el subconjunto de JSON Schema necesario aquí: type, required,
+// properties, items, enum y minLength
+func validateAgainstSchema(value interface{}, schema string) error {
+	var s map[string]interface{}
+	if err := json.Unmarshal([]byte(schema), &s); err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
 	}
+	return validateSchemaNode(value, s, "$")
+}
 
-	// Verificar errores de la API
-	if resp.StatusCode != http.StatusOK {
-		var errResp HuggingFaceError
-		if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
-			apiErr := &APIError{
-				StatusCode: resp.StatusCode,
-				Message:    errResp.Error,
+func validateSchemaNode(value interface{}, schema map[string]interface{}, path string) error {
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object", path)
+		}
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, present := obj[name.(string)]; !present {
+					return fmt.Errorf("%s: missing required field '%s'", path, name)
+				}
 			}
-			// Mejorar mensaje de error 401 con instrucciones útiles
-			if resp.StatusCode == http.StatusUnauthorized {
-				return "", fmt.Errorf("%w\n\nPlease ensure your API token is valid:\n1. Go to https://huggingface.co/settings/tokens\n2. Create or copy your token\n3. Set: $env:HUGGINGFACE_API_TOKEN=\"your_token_here\"", apiErr)
+		}
+		if props, ok := schema["properties"].(map[string]interface{}); ok {
+			for name, propSchema := range props {
+				if v, present := obj[name]; present {
+					if err := validateSchemaNode(v, propSchema.(map[string]interface{}), path+"."+name); err != nil {
+						return err
+					}
+				}
 			}
-			return "", apiErr
 		}
-		return "", &APIError{
-			StatusCode: resp.StatusCode,
-			Message:    string(body),
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array", path)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range arr {
+				if err := validateSchemaNode(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected string", path)
+		}
+		if minLen, ok := schema["minLength"].(float64); ok && len(str) < int(minLen) {
+			return fmt.Errorf("%s: string shorter than %d characters", path, int(minLen))
+		}
+		if enum, ok := schema["enum"].([]interface{}); ok {
+			for _, allowed := range enum {
+				if str == allowed {
+					return nil
+				}
+			}
+			return fmt.Errorf("%s: value '%s' is not one of %v", path, str, enum)
 		}
 	}
+	return nil
+}
 
-	// Parsear respuesta
-	var response HuggingFaceResponse
-	if err := json.Unmarshal(body, &response); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+// splitSentences divide un texto en oraciones (ver sentenceEnds)
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	for _, end := range sentenceEnds(text) {
+		if sentence := strings.TrimSpace(text[start:end]); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = end
 	}
-
-	if len(response) == 0 || response[0].SummaryText == "" {
-		return "", fmt.Errorf("no summary generated by the API")
+	if rest := strings.TrimSpace(text[start:]); rest != "" {
+		sentences = append(sentences, rest)
 	}
+	return sentences
+}
 
-	// Formatear la salida según el tipo de resumen
-	summary := response[0].SummaryText
-	return formatOutput(summary, summaryType), nil
+const (
+	// sentenceTerminators cierran una oración cuando los sigue un espacio o el
+	// fin del texto (incluye el signo de interrogación árabe y el danda)
+	sentenceTerminators = ".!?…؟।॥։።"
+	// fullWidthTerminators cierran una oración aunque no los siga un espacio,
+	// como en chino y japonés
+	fullWidthTerminators = "。！？"
+	// sentenceClosers son comillas y paréntesis que pueden seguir al
+	// terminador y pertenecen todavía a la oración
+	sentenceClosers = "\"')]}”’»」』】"
+)
+
+// abbreviations son abreviaturas (inglés, español, francés y alemán, en
+// minúsculas y sin el punto final) que no cierran una oración aunque las siga
+// una mayúscula: títulos antes de un nombre, "e.g.", "Fig. 3", "pág. 12".
+// Las demás ("etc.", "Inc.") cierran la oración salvo que la siguiente palabra
+// empiece en minúscula
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "st": true, "mt": true,
+	"gen": true, "col": true, "lt": true, "sgt": true, "capt": true, "rev": true, "hon": true,
+	"e.g": true, "i.e": true, "cf": true, "vs": true, "approx": true, "ca": true, "viz": true,
+	"fig": true, "figs": true, "no": true, "nos": true, "vol": true, "vols": true, "p": true, "pp": true,
+	"ch": true, "sec": true, "eq": true, "ed": true, "eds": true, "dept": true, "est": true,
+	"sr": true, "sra": true, "srta": true, "dra": true, "d": true, "dña": true, "ud": true, "uds": true,
+	"lic": true, "ing": true, "av": true, "avda": true, "ej": true, "pág": true, "págs": true,
+	"núm": true, "aprox": true, "cap": true, "mme": true, "mlle": true, "z.b": true, "bzw": true,
 }
 
-// APIError representa un error devuelto por la API con código de estado
-type APIError struct {
-	StatusCode int
-	Message    string
+// sentenceEnds devuelve las posiciones (en bytes) donde termina cada oración.
+// Un punto no cierra la oración en una abreviatura conocida, en una inicial
+// ("J. Smith"), entre dígitos (3.14) ni cuando la palabra siguiente empieza en
+// minúscula; los signos de ancho completo cierran aunque no los siga un espacio
+func sentenceEnds(text string) []int {
+	var ends []int
+	var runes []rune
+	var offsets []int
+	for i, r := range text {
+		runes = append(runes, r)
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(text))
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		fullWidth := strings.ContainsRune(fullWidthTerminators, r)
+		if !fullWidth && !strings.ContainsRune(sentenceTerminators, r) {
+			continue
+		}
+		// Agrupar terminadores seguidos ("?!", "...") y las comillas de cierre
+		j := i + 1
+		for j < len(runes) && (strings.ContainsRune(sentenceTerminators, runes[j]) || strings.ContainsRune(fullWidthTerminators, runes[j])) {
+			j++
+		}
+		last := runes[j-1]
+		for j < len(runes) && strings.ContainsRune(sentenceClosers, runes[j]) {
+			j++
+		}
+		if !fullWidth && j < len(runes) && !unicode.IsSpace(runes[j]) {
+			i = j - 1
+			continue
+		}
+		if (last == '.' || last == '…') && !fullWidth && continuesSentence(runes, i, j) {
+			i = j - 1
+			continue
+		}
+		ends = append(ends, offsets[j])
+		i = j - 1
+	}
+	return ends
 }
 
-func (e *APIError) Error() string {
-	return fmt.Sprintf("API error (%d): %s", e.StatusCode, e.Message)
+// continuesSentence indica si el punto en runes[dot] (con la puntuación que lo
+// sigue hasta next) no termina la oración
+func continuesSentence(runes []rune, dot, next int) bool {
+	if runes[dot] == '.' && isAbbreviation(string(runes[wordStart(runes, dot):dot])) {
+		return true
+	}
+	for next < len(runes) && unicode.IsSpace(runes[next]) {
+		next++
+	}
+	return next < len(runes) && unicode.IsLower(runes[next])
 }
 
-// isRetryableError determina si vale la pena reintentar un error
-func isRetryableError(err error) bool {
-	if apiErr, ok := err.(*APIError); ok {
-		// Reintentar en límite de tasa (429) o errores de servidor (5xx)
-		return apiErr.StatusCode == http.StatusTooManyRequests ||
-			(apiErr.StatusCode >= 500 && apiErr.StatusCode < 600)
+// wordStart devuelve dónde empieza la palabra (letras y puntos internos) que
+// termina en end
+func wordStart(runes []rune, end int) int {
+	start := end
+	for start > 0 && (unicode.IsLetter(runes[start-1]) || (runes[start-1] == '.' && start > 1 && unicode.IsLetter(runes[start-2]))) {
+		start--
 	}
-	return false
+	return start
 }
 
-// buildPrompt crea un prompt adaptado al tipo de resumen
-func buildPrompt(text, summaryType string) string {
-	switch summaryType {
-	case "short":
-		return fmt.Sprintf("Summarize this text in 1-2 concise sentences:\n\n%s", text)
-	case "medium":
-		return fmt.Sprintf("Provide a comprehensive paragraph summary of this text:\n\n%s", text)
-	case "bullet":
-		return fmt.Sprintf("Summarize this text as a list of key points:\n\n%s", text)
-	default:
-		return text
+// isAbbreviation indica si una palabra seguida de punto es una abreviatura o
+// una inicial en mayúscula
+func isAbbreviation(word string) bool {
+	if r, size := utf8.DecodeRuneInString(word); size == len(word) && unicode.IsUpper(r) {
+		return true
 	}
+	return abbreviations[strings.ToLower(word)]
 }
 
-// getMaxLength devuelve la longitud máxima de tokens para el tipo de resumen
-func getMaxLength(summaryType string) int {
-	switch summaryType {
-	case "short":
-		return 50
-	case "medium":
-		return 150
-	case "bullet":
-		return 200
-	default:
-		return 100
+// trimSentenceEnd quita el punto final de una oración, salvo que sea el de una
+// abreviatura ("etc." no, "U.S." sí lo conserva)
+func trimSentenceEnd(sentence string) string {
+	if !strings.HasSuffix(sentence, ".") || strings.HasSuffix(sentence, "..") {
+		return sentence
+	}
+	runes := []rune(sentence)
+	word := string(runes[wordStart(runes, len(runes)-1) : len(runes)-1])
+	if isAbbreviation(word) || strings.Contains(word, ".") {
+		return sentence
 	}
+	return strings.TrimSuffix(sentence, ".")
 }
 
-// getMinLength devuelve la longitud mínima de tokens para el tipo de resumen
-func getMinLength(summaryType string) int {
-	switch summaryType {
-	case "short":
-		return 10
-	case "medium":
-		return 50
-	case "bullet":
-		return 30
-	default:
-		return 20
+// extractTitle toma como título el primer encabezado o la primera línea corta
+// del documento; si no hay ninguno, usa el comienzo de la primera oración
+func extractTitle(document string) string {
+	for _, line := range strings.Split(document, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			return strings.TrimSpace(strings.TrimLeft(line, "#"))
+		}
+		if len(line) <= 80 && !strings.ContainsAny(line[len(line)-1:], ".!?:;,") {
+			return line
+		}
+		break
 	}
+	words := strings.Fields(document)
+	if len(words) > 8 {
+		words = words[:8]
+	}
+	return strings.TrimRight(strings.Join(words, " "), ".,;:!?") + "..."
 }
 
-// formatOutput formatea el resumen según el tipo solicitado
-func formatOutput(summary, summaryType string) string {
-	if summaryType == "bullet" {
-		// Convertir a puntos bullet si no está ya formateado
-		// Maneja múltiples delimitadores: puntos, saltos de línea y punto y coma
-		var bullets []string
-		
-		// Intentar dividir por saltos de línea primero (si la API devuelve lista pre-formateada)
-		lines := strings.Split(summary, "\n")
-		if len(lines) > 1 {
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-				// Remover marcadores bullet existentes si están presentes
-				line = strings.TrimPrefix(line, "-")
-				line = strings.TrimPrefix(line, "*")
-				line = strings.TrimPrefix(line, "•")
-				line = strings.TrimSpace(line)
-				if line != "" && len(line) > 3 { // Evitar fragmentos muy cortos
-					bullets = append(bullets, "- "+line)
+// extractEntities detecta entidades nombradas con una heurística simple:
+// secuencias de palabras capitalizadas, ordenadas por frecuencia de aparición.
+// Al comienzo de una oración solo cuentan las secuencias de dos o más palabras
+// para no confundir la mayúscula inicial con un nombre propio
+func extractEntities(document string) []string {
+	counts := map[string]int{}
+	var order []string
+	var sentences []string
+	for _, line := range strings.Split(document, "\n") {
+		sentences = append(sentences, splitSentences(line)...)
+	}
+	for _, sentence := range sentences {
+		var current []string
+		startsSentence := false
+		flush := func() {
+			if len(current) > 1 || (len(current) == 1 && !startsSentence) {
+				entity := strings.Join(current, " ")
+				if counts[entity] == 0 {
+					order = append(order, entity)
 				}
+				counts[entity]++
 			}
+			current = nil
 		}
-		
-		// Si no se encontraron saltos de línea, dividir por puntos o punto y coma
-		if len(bullets) == 0 {
-			// Dividir tanto por puntos como por punto y coma
-			text := strings.ReplaceAll(summary, ";", ".")
-			lines = strings.Split(text, ".")
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-				if line != "" && len(line) > 10 { // Evitar fragmentos muy cortos
-					bullets = append(bullets, "- "+line)
+		for i, word := range strings.Fields(sentence) {
+			clean := strings.TrimFunc(word, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+			first, _ := utf8.DecodeRuneInString(clean)
+			if clean != "" && unicode.IsUpper(first) {
+				if len(current) == 0 {
+					startsSentence = i == 0
 				}
+				current = append(current, clean)
+				// La puntuación dentro de la oración cierra la entidad
+				if strings.ContainsAny(word, ",;:)") {
+					flush()
+				}
+				continue
 			}
+			flush()
 		}
-		
-		if len(bullets) > 0 {
-			return strings.Join(bullets, "\n")
+		flush()
+	}
+
+	sort.SliceStable(order, func(i, j int) bool { return counts[order[i]] > counts[order[j]] })
+	entities := []string{}
+	for _, entity := range order {
+		if len(entities) == 10 {
+			break
 		}
-		// Fallback: devolver original con bullet único si el parseo falla
-		return "- " + summary
+		entities = append(entities, entity)
+	}
+	return entities
+}
+
+// Léxico mínimo para estimar el sentimiento del documento. Es solo en inglés:
+// en otros idiomas el resultado tiende a "neutral"
+var (
+	positiveWords = []string{"good", "great", "excellent", "positive", "success", "successful", "improve", "improved",
+		"benefit", "growth", "gain", "win", "strong", "happy", "love", "best", "better", "effective", "progress"}
+	negativeWords = []string{"bad", "poor", "negative", "fail", "failed", "failure", "loss", "decline", "risk",
+		"problem", "crisis", "weak", "worse", "worst", "hate", "concern", "damage", "threat", "drop"}
+)
+
+// classifySentiment estima el sentimiento contando palabras del léxico
+func classifySentiment(document string) string {
+	positive, negative := 0, 0
+	for _, word := range strings.Fields(strings.ToLower(document)) {
+		word = strings.Trim(word, ".,;:!?\"'()")
+		for _, p := range positiveWords {
+			if word == p {
+				positive++
+			}
+		}
+		for _, n := range negativeWords {
+			if word == n {
+				negative++
+			}
+		}
+	}
+	switch {
+	case positive == 0 && negative == 0:
+		return "neutral"
+	case positive > 2*negative:
+		return "positive"
+	case negative > 2*positive:
+		return "negative"
+	default:
+		return "mixed"
 	}
-	return summary
 }
 
 /*
@@ -408,12 +10448,41 @@ DECISIONES CLAVE DE DISEÑO:
    - Se seleccionó el modelo facebook/bart-large-cnn: modelo de última generación
      específicamente entrenado para artículos de noticias y texto general
    - Requiere token de API gratuito (obtenible en huggingface.co/settings/tokens)
-   - El token se pasa vía variable de entorno para seguridad
+   - El token se pasa vía variable de entorno para seguridad, o se guarda en el
+     llavero del sistema con "auth login" (la variable de entorno tiene prioridad)
+   - Las solicitudes van al router (router.huggingface.co/<proveedor>/models/<id>),
+     ya que la URL serverless api-inference está siendo retirada; --model,
+     --provider y --endpoint (o la sección "models" de la configuración) permiten
+     elegir otro modelo, proveedor o un Inference Endpoint dedicado
+   - El proveedor "spaces" llama a una app Gradio alojada en un Space
+     (<usuario>-<nombre>.hf.space, o --endpoint si es autoalojada) con su API
+     REST: POST /gradio_api/call/<api> y lectura del stream SSE del resultado.
+     La app recibe solo el texto, y el token solo se envía a *.hf.space y
+     huggingface.co, nunca a una app autoalojada
+   - --warmup y --health-check esperan/consultan a que el endpoint esté listo
+     (modelo cargado o endpoint escalado desde cero)
 
 2. PARSEO DE ARGUMENTOS CLI:
    - Se utilizó el paquete estándar "flag" de Go para parseo CLI nativo e idiomático
    - Soporta tanto flags nombrados (--input, --type) como abreviados (-t)
    - Permite argumentos posicionales como alternativa para mayor flexibilidad UX
+   - Subcomandos: "auth" (token en el llavero) y "revisions" (resumen de cambios
+     entre dos versiones de un documento .txt/.docx, con redline por párrafo)
+   - "history list|show|search": cada resumen se guarda en SQLite a través del
+     cliente sqlite3 del sistema, para no agregar un driver como dependencia.
+     Sin sqlite3, el historial por defecto se omite en silencio; solo se
+     advierte si se pidió una base con --history-db o "history.path"
+   - "completion bash|zsh|fish|powershell" y "docs man" se generan desde los
+     flags ya definidos, así que no se desactualizan; --model y --preset se
+     completan consultando al propio binario ("completion __models") para
+     incluir lo definido en la configuración
+   - "config import" instala un paquete (JSON local o por HTTP, sin enviar el
+     token) con alias de modelos, presets y plantillas: se valida completo antes
+     de escribir, se conservan las demás secciones del archivo y las entradas
+     existentes distintas solo se reemplazan con --force. --preset aplica un
+     tipo con su propia instrucción, longitud y plantilla. Un paquete descargado
+     con modelos de endpoint propio se rechaza salvo --allow-endpoints, que
+     muestra los hosts que recibirán el token
    - Proporciona mensajes de uso claros y valida todas las entradas
 
 3. INGENIERÍA DE PROMPTS:
@@ -424,6 +10493,8 @@ DECISIONES CLAVE DE DISEÑO:
      * bullet: Solicita "lista de puntos clave"
    - Se combinó la ingeniería de prompts con parámetros de API (max_length, min_length)
      para asegurar formatos de salida consistentes
+   - --max-words/--min-words, --max-tokens/--min-tokens y --compression-ratio
+     reemplazan esos valores; las palabras se convierten a tokens (~4/3)
 
 4. ESTRATEGIA DE MANEJO DE ERRORES:
    - Validación comprehensiva en cada paso: existencia de archivo, archivos vacíos,
@@ -432,10 +10503,15 @@ DECISIONES CLAVE DE DISEÑO:
    - Mensajes de error amigables que guían a los usuarios a resolver problemas
    - Lógica de reintentos con backoff exponencial para fallos transitorios
    - Distingue entre errores reintentables (429, 5xx) y no reintentables
+   - Códigos de salida por categoría (2 uso, 3 autenticación, 4 entrada, 5 API,
+     6 timeout, 7 contenido bloqueado) y --error-format json para que los scripts puedan ramificar
 
 5. LÓGICA DE REINTENTOS CON BACKOFF EXPONENCIAL:
-   - Implementa hasta 3 intentos de reintento para llamadas a la API
+   - Por defecto hasta 3 intentos (2 reintentos) para llamadas a la API
    - Usa backoff exponencial (2s, 4s, 8s) para evitar saturar la API
+   - Política configurable (--max-retries, --retry-base-delay, --retry-max-delay,
+     --retry-jitter o la sección "retry" del archivo de configuración), ya que
+     el tier gratuito y los Inference Endpoints de pago requieren valores distintos
    - Solo reintenta en rate limit (429) o errores de servidor (500-599)
    - Falla rápido en errores de cliente (400-499 excepto 429) para ahorrar tiempo
    - Proporciona feedback al usuario durante los intentos de reintento
@@ -443,18 +10519,131 @@ DECISIONES CLAVE DE DISEÑO:
 6. DISEÑO DEL CLIENTE HTTP:
    - Usa el paquete estándar net/http de Go por confiabilidad
    - Timeout de 30 segundos previene colgarse en solicitudes lentas/fallidas
+   - Proxy, CAs corporativas, timeouts y (con advertencia) desactivar la
+     verificación TLS se configuran con flags o la sección "http" del config
    - Limpieza apropiada de recursos con defer resp.Body.Close()
    - Establece el header Content-Type correcto para solicitudes JSON
    - Separa la lógica HTTP en attemptSummarization() para manejo limpio de reintentos
+   - Con SUMMARIZER_CHAOS=1 existe el flag oculto --chaos: un RoundTripper que
+     simula 429, 503 con estimated_time, cuerpos truncados y timeouts con una
+     semilla reproducible, para probar reintentos sin depender de caídas reales
 
 7. FORMATEO DE SALIDA:
    - Función formatOutput() mejorada maneja múltiples casos edge
    - Para puntos bullet: intenta múltiples estrategias de parseo:
      * Primero intenta items separados por saltos de línea (la API puede pre-formatear)
-     * Se repliega a separación por puntos/punto y coma
+     * Se repliega a separación por oraciones y punto y coma
+   - sentenceEnds segmenta oraciones sin cortar abreviaturas ("Dr.", "e.g.",
+     "pág."), iniciales ni números decimales, y reconoce la puntuación final
+     de otras escrituras (。！？, ؟, ।); lo usan las viñetas, los fragmentos
+     de --hierarchical y clipText
      * Remueve marcadores de bullet existentes para evitar duplicación
      * Filtra fragmentos muy cortos (< 10 chars) para mantener calidad
    - Proporciona fallback sensato si todo el parseo falla
+   - outline: asigna cada oración del resumen a la sección del documento con
+     mayor solapamiento léxico y las presenta numeradas según sus encabezados
+   - timeline: extrae localmente las oraciones fechadas del documento (fechas
+     ISO, "March 3, 2024", "3 March 2024", "March 2024", años) y las ordena
+     cronológicamente; no requiere llamar al modelo. Un año suelto necesita
+     contexto de fecha ("in 2019", "since 1998", "1990-1995"): sin él, "2000
+     employees" aparecería como un evento
+   - proscons: separa las frases del resumen por conectores adversativos y las
+     clasifica en ventajas/desventajas con un léxico de palabras clave
+   - glossary: extrae localmente siglas con su expansión ("Long Form (LF)"),
+     términos definidos en el texto ("X is a ...") y siglas sin definición,
+     cuya definición se pide al modelo con la oración donde aparecen (una
+     llamada por sigla, hasta maxGlossaryQueries)
+   - toc: tabla de contenidos anotada; cada sección con cuerpo suficiente se
+     resume por separado (una llamada por sección, truncada individualmente)
+   - feedback: trata la entrada como muchos comentarios cortos (uno por línea o
+     párrafo), agrupa temas por palabras clave frecuentes y muestra su
+     frecuencia aproximada y citas representativas junto al resumen general
+   - Transcripciones: si la mayoría de las líneas tienen etiqueta de hablante y
+     hay marcas de tiempo o los hablantes se alternan varias veces, cada
+     intervención se reescribe como "Alice said: ..." para que el resumen conserve
+     la atribución; --per-speaker resume a cada participante por separado
+   - La entrada se convierte a UTF-8 (BOM, UTF-16 con o sin BOM, y Windows-1252/
+     Latin-1 como alternativa cuando no es UTF-8 válido) y se normaliza: CRLF,
+     caracteres de ancho cero y espacios repetidos
+   - --csv/--jsonl resumen una columna fila por fila; cada fila se escribe apenas
+     se completa, así --resume continúa desde la salida parcial
+   - En Windows, fsPath rechaza nombres reservados (CON, NUL, COM1...) y convierte
+     rutas largas y UNC a la forma extendida \\?\ antes de abrir archivos
+   - Varios archivos con --output-dir: un resumen por archivo y un manifiesto
+     (manifest.jsonl) con el hash de cada entrada; --resume saltea los terminados
+   - --protocol jsonrpc atiende JSON-RPC 2.0 por stdin/stdout (una solicitud por
+     línea, en orden), para integrar el resumidor en editores como proceso hijo;
+     los errores al resumir llevan en "data" la categoría y el código de salida
+   - --tui dibuja con secuencias ANSI y lee teclas con stty (como readSecret), sin
+     dependencias: un worker resume los archivos de a uno y la interfaz se
+     redibuja cada 100 ms; los logs se muestran como última línea de estado
+   - Los directorios de entrada se recorren recursivamente: los enlaces simbólicos
+     se saltean, siguen (--follow-symlinks, con detección de ciclos) o son un error,
+     y los FIFOs y dispositivos se saltean para no bloquear la lectura
+   - Los archivos de salida se escriben en un temporal y se renombran (atómico); la
+     salida de --csv/--jsonl se acumula en <salida>.partial hasta terminar.
+     --no-clobber no reemplaza archivos existentes y --backup guarda un .bak
+   - --with-sources asocia cada oración o viñeta del resumen con las oraciones del
+     original de mayor solapamiento léxico (por raíces) y muestra sus líneas; las
+     afirmaciones sin fuente se marcan para revisarlas
+   - El historial, el manifiesto y las salidas parciales se protegen con archivos
+     <ruta>.lock creados con O_EXCL (portables, también en discos de red); los
+     bloqueos de procesos que ya terminaron se eliminan solos
+   - --deadline limita todas las solicitudes. En --hierarchical el plan se ajusta
+     al tiempo disponible (secciones largas en una sola llamada sobre una muestra,
+     menos niveles) y, si el plazo vence, se muestra el árbol parcial marcado
+   - decodeSummary acepta las distintas formas de respuesta de los modelos
+     (summary_text, generated_text, objetos sueltos, listas anidadas, choices) y
+     "model is loading" es un ModelLoadingError reintentable
+   - Los pasajes marcados con <!-- summarize:must --> (o que coinciden con
+     --weight-pattern) llevan el prefijo [IMPORTANT]: al recortar la entrada o
+     muestrear fragmentos entran primero, y el prompt pide cubrirlos
+   - --tasks reutiliza los datos de --report: una sola lectura del documento y un
+     pedido al modelo por tipo de resumen, con un resultado combinado
+   - --report llena una plantilla de Go; cada sección ({{.Summary "short"}},
+     {{.KeyPoints}}...) es un resumen aparte, pedido una sola vez por tipo. Las
+     plantillas .html usan html/template para escapar los resúmenes
+   - Los límites de uso (--max-requests, --max-input-chars y la sección "budget"
+     con topes diarios) se consultan antes de cada solicitud y, en los lotes,
+     antes de empezar; el contador diario vive en usage.json bajo su lock
+   - En los lotes, batchDashboard redibuja en stderr un solo bloque (en cola,
+     en curso, terminados, fallidos, ritmo, ETA y cuota); mientras está activo
+     los logs pasan por él, así los avisos quedan encima y no lo cortan
+   - --notify-webhook y --slack-channel entregan cada resumen terminado (archivo
+     único o cada archivo del lote); un fallo de entrega es solo una advertencia
+   - La sección "outputs" del config reparte el resumen de un solo documento
+     entre archivos, webhooks y canales de Slack, cada uno con su formato. Los
+     formatos extra se generan con la memoria de resúmenes del cliente, así que
+     el modelo se llama una sola vez por texto
+   - Los hashes de las entradas (manifiesto de --resume, historial) se calculan
+     sobre el texto canónico: sin BOM, saltos LF, sin espacios finales y con los
+     acentos latinos compuestos (NFC parcial: sin dependencias no hay tablas
+     Unicode completas)
+   - Las cabeceras de cuota (X-RateLimit-*, RateLimit-*) se registran en las
+     métricas: -v muestra la cuota restante, --stats la última conocida y los
+     lotes espacian los archivos cuando quedan pocas solicitudes; Retry-After
+     alarga la espera del reintento
+   - --run-report escribe al terminar un lote (también si se detiene) un JSON con
+     cada archivo, su duración y sus reintentos, los totales y la configuración
+     (los flags ya interpretados, con las URLs sin credenciales y el webhook
+     reducido a su host, en lugar de la línea de comandos literal)
+   - En el modo de varios archivos, --max-memory limita el texto que se conserva
+     en memoria entre la lectura (necesaria para comparar hashes con --resume) y
+     el resumen; los documentos que no entran se guardan en archivos temporales
+     (copiados sin leerlos enteros, salvo que --resume necesite su hash) y cada
+     documento procesado libera su parte del límite
+   - --hierarchical arma un árbol de resúmenes (sección -> capítulo -> documento)
+     a partir de los encabezados, o de fragmentos agrupados si no los hay; cada
+     nodo combina su texto con los resúmenes de sus hijos y --depth poda el árbol
+   - --content-filter enmascara (mask), advierte (flag) o bloquea (block, código 7)
+     groserías y términos no aptos en la salida, como último paso antes de imprimir
+   - --format structured devuelve un objeto JSON (title, one_liner, key_points,
+     entities, sentiment) validado contra un schema. Si el modelo devuelve JSON
+     se usa directamente. A los modelos de chat (proveedores del router distintos
+     de hf-inference, o "chat": true en el alias) se les envía el schema en el
+     prompt y en el parámetro grammar de text-generation; con modelos de resumen
+     puro los campos se derivan del resumen y del documento con heurísticas
+     locales (el léxico de sentimiento es solo en inglés)
    - Formato de salida limpio y consistente para todos los tipos de resumen
 
 8. MANEJO DE ENTRADA:
@@ -462,6 +10651,9 @@ DECISIONES CLAVE DE DISEÑO:
    - Advierte al usuario en stderr cuando ocurre truncado (no contamina stdout)
    - Valida existencia de archivo antes de intentar leer
    - Asegura que el archivo no esté vacío para evitar llamadas de API desperdiciadas
+   - --min-input-words (o "input.min_words" en el config) no llama al modelo con
+     entradas muy cortas: devuelve el texto tal cual o, con --short-input
+     notice, un aviso; así un pipeline no paga por "resumir" dos oraciones
 
 9. ORGANIZACIÓN DEL CÓDIGO:
    - Clara separación de responsabilidades con funciones enfocadas